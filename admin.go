@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+// adminHandler returns a handler for the administrative endpoints: a health
+// check (failing while a log source exceeds its parse error ratio), the
+// profiling endpoints of net/http/pprof, the status page (if enabled) and (if
+// a reload token is configured) the relabel reload and the pausing of log
+// sources
+func adminHandler(opts *config.StartupFlags, nsMetricsByName map[string]*NSMetrics, reloadToken string, statusPage bool) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		problems := unhealthySources.problems()
+		if len(problems) == 0 {
+			fmt.Fprintln(w, "ok")
+			return
+		}
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, p := range problems {
+			fmt.Fprintln(w, p)
+		}
+	})
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	if statusPage {
+		mux.Handle("/status", statusHandler(nsMetricsByName))
+	}
+
+	if reloadToken != "" {
+		mux.Handle("/-/reload-relabel", reloadRelabelHandler(opts, nsMetricsByName, reloadToken))
+		mux.Handle("/-/pause", pauseHandler(pausableSources, reloadToken, true))
+		mux.Handle("/-/resume", pauseHandler(pausableSources, reloadToken, false))
+	}
+
+	return mux
+}
+
+// serveAdmin starts the HTTP server for the administrative endpoints, using
+// the timeouts of the global listen configuration. The server is shut down
+// when stopChan is closed.
+func serveAdmin(adminCfg *config.AdminListenConfig, listenCfg *config.ListenConfig, handler http.Handler, stopChan <-chan bool, stopHandlers *sync.WaitGroup) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", adminCfg.AddressOrDefault(), adminCfg.Port))
+	if err != nil {
+		panic(err)
+	}
+
+	server, err := newHTTPServer(listenCfg, handler)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("running admin HTTP server on address %s\n", listener.Addr().String())
+
+	serveInBackground("admin HTTP server", server, listener, stopChan, stopHandlers)
+}
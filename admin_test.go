@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+func adminStatus(handler http.Handler, method, path string) int {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+
+	return rec.Code
+}
+
+func TestAdminHandlerServesHealthAndProfiling(t *testing.T) {
+	handler := adminHandler(nil, nil, "", false)
+
+	assert.Equal(t, http.StatusOK, adminStatus(handler, http.MethodGet, "/healthz"))
+	assert.Equal(t, http.StatusOK, adminStatus(handler, http.MethodGet, "/debug/pprof/"))
+	assert.Equal(t, http.StatusNotFound, adminStatus(handler, http.MethodGet, "/metrics"))
+	assert.Equal(t, http.StatusNotFound, adminStatus(handler, http.MethodPost, "/-/reload-relabel"))
+}
+
+func TestAdminHandlerRequiresReloadToken(t *testing.T) {
+	handler := adminHandler(nil, nil, "secret", false)
+
+	assert.Equal(t, http.StatusUnauthorized, adminStatus(handler, http.MethodPost, "/-/reload-relabel"))
+}
+
+func TestHealthFailsForUnhealthySources(t *testing.T) {
+	handler := adminHandler(nil, nil, "", false)
+
+	unhealthySources.markUnhealthy("test", "/var/log/nginx/access.log", "too many parse errors")
+	assert.Equal(t, http.StatusServiceUnavailable, adminStatus(handler, http.MethodGet, "/healthz"))
+
+	unhealthySources.markHealthy("test", "/var/log/nginx/access.log")
+	assert.Equal(t, http.StatusOK, adminStatus(handler, http.MethodGet, "/healthz"))
+}
+
+func TestStatusPageShowsNamespaceRates(t *testing.T) {
+	var cfg config.Config
+	require.Nil(t, config.LoadConfigFromStream(&cfg, strings.NewReader(upstreamErrorsConfig), config.TypeHCL))
+
+	nsMetrics := NewNSMetrics(&cfg.Namespaces[0], nil)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	for i := 0; i < 60; i++ {
+		p.process(`200 "10.0.0.1:80"`)
+	}
+	p.process(`garbage`)
+
+	assert.Equal(t, http.StatusNotFound, adminStatus(adminHandler(nil, nil, "", false), http.MethodGet, "/status"))
+
+	rec := httptest.NewRecorder()
+	adminHandler(nil, map[string]*NSMetrics{"test": nsMetrics}, "", true).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<td>test</td><td>1.02</td><td>0.02</td><td>0s ago</td>")
+	assert.Contains(t, rec.Body.String(), `<meta http-equiv="refresh" content="5">`)
+}
+
+func TestMainListenerDoesNotServeProfiling(t *testing.T) {
+	cfg := config.Config{}
+	mux := http.NewServeMux()
+	registerMainHandlers(mux, &cfg, nil, nil, prometheus.Gatherers{prometheus.NewRegistry()}, nil)
+
+	assert.Equal(t, http.StatusOK, adminStatus(mux, http.MethodGet, "/metrics"))
+	assert.Equal(t, http.StatusNotFound, adminStatus(mux, http.MethodGet, "/debug/pprof/cmdline"))
+	assert.Equal(t, http.StatusNotFound, adminStatus(mux, http.MethodGet, "/debug/pprof/"))
+}
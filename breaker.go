@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// datadogSendFailuresTotal counts failed attempts to send metrics to or to
+// probe a Datadog agent
+var datadogSendFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "nginxlog_exporter_datadog_send_failures_total",
+	Help: "Total number of metrics that could not be sent to Datadog and of failed probes of a Datadog agent",
+})
+
+// circuitBreaker stops calling an unavailable backend for a cooldown period
+// after a number of consecutive failures. All methods may be called on a nil
+// circuitBreaker, which never opens.
+type circuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	failures  prometheus.Counter
+
+	lock        sync.Mutex
+	consecutive int
+	openUntil   time.Time
+}
+
+func newCircuitBreaker(name string, threshold int, cooldown time.Duration, failures prometheus.Counter) *circuitBreaker {
+	return &circuitBreaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		failures:  failures,
+	}
+}
+
+// allow tests if the backend may be called; this is the case unless the
+// breaker was opened within the cooldown period
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return !time.Now().Before(b.openUntil)
+}
+
+// record records the result of a call to or a probe of the backend, opening
+// the breaker when the number of consecutive failures reaches the threshold
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+
+	if err != nil {
+		b.failures.Inc()
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err == nil {
+		b.consecutive = 0
+		return
+	}
+
+	b.consecutive++
+	if b.threshold > 0 && b.consecutive >= b.threshold {
+		fmt.Printf("%d consecutive errors while sending to or probing %s (last: %s); pausing for %s\n", b.consecutive, b.name, err.Error(), b.cooldown)
+
+		b.consecutive = 0
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeBuckets are histogram buckets of a time in seconds as configured: either
+// as numbers of seconds (like 0.005), or as duration strings (like "5ms")
+type TimeBuckets []interface{}
+
+// Seconds converts the buckets to seconds
+func (b TimeBuckets) Seconds() ([]float64, error) {
+	seconds := make([]float64, len(b))
+
+	for i, bucket := range b {
+		switch v := bucket.(type) {
+		case int:
+			seconds[i] = float64(v)
+		case int64:
+			seconds[i] = float64(v)
+		case float64:
+			seconds[i] = v
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bucket '%s': %s", v, err.Error())
+			}
+
+			seconds[i] = d.Seconds()
+		default:
+			return nil, fmt.Errorf("invalid bucket '%v': buckets need to be numbers or durations", bucket)
+		}
+	}
+
+	return seconds, nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// BuiltinFormats contains named log formats that can be selected using the
+// "format_name" property instead of spelling out the full format string
+var BuiltinFormats = map[string]string{
+	// NGINX' predefined "combined" log format
+	"nginx-combined": `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+
+	// Apache's "combined" log format (%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i");
+	// in contrast to NGINX, the second field contains the identd user name
+	"apache-combined": `$remote_addr $remote_ident $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+
+	// JSON log format; requires a matching NGINX log_format with escape=json (see README)
+	"nginx-json": `{"time_local":"$time_local","remote_addr":"$remote_addr","remote_user":"$remote_user","request":"$request","status":"$status","body_bytes_sent":"$body_bytes_sent","request_time":"$request_time","upstream_response_time":"$upstream_response_time","http_referer":"$http_referer","http_user_agent":"$http_user_agent"}`,
+}
+
+// BuiltinFormatNames returns the (sorted) names of all built-in log formats
+func BuiltinFormatNames() []string {
+	names := make([]string, 0, len(BuiltinFormats))
+	for n := range BuiltinFormats {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func resolveFormatName(name string) (string, error) {
+	format, ok := BuiltinFormats[name]
+	if !ok {
+		return "", fmt.Errorf("unknown format_name '%s' (available: %s)", name, strings.Join(BuiltinFormatNames(), ", "))
+	}
+
+	return format, nil
+}
+
+// FormatContainsField tests if a log format contains a variable for the given
+// field (for example, "request_time" for "$request_time")
+func FormatContainsField(format string, field string) bool {
+	r := regexp.MustCompile(`\$` + regexp.QuoteMeta(field) + `([^a-zA-Z0-9_]|$)`)
+	return r.MatchString(format)
+}
+
+var formatFieldRegexp = regexp.MustCompile(`\$([a-zA-Z0-9_]+)`)
+
+// FormatFields returns the names of all variables in a log format, in the
+// order of their first occurrence
+func FormatFields(format string) []string {
+	var fields []string
+	seen := make(map[string]struct{})
+
+	for _, m := range formatFieldRegexp.FindAllStringSubmatch(format, -1) {
+		if _, ok := seen[m[1]]; ok {
+			continue
+		}
+
+		seen[m[1]] = struct{}{}
+		fields = append(fields, m[1])
+	}
+
+	return fields
+}
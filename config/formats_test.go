@@ -0,0 +1,25 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatContainsField(t *testing.T) {
+	t.Parallel()
+
+	format := `$remote_addr "$request" $request_time`
+
+	assert.True(t, FormatContainsField(format, "remote_addr"))
+	assert.True(t, FormatContainsField(format, "request"))
+	assert.True(t, FormatContainsField(format, "request_time"))
+	assert.False(t, FormatContainsField(format, "remote"))
+	assert.False(t, FormatContainsField(format, "upstream_response_time"))
+}
+
+func TestFormatFieldsAreExtractedInOrder(t *testing.T) {
+	fields := FormatFields(`$remote_addr - $remote_user [$time_local] "$request" $status $remote_addr`)
+
+	assert.Equal(t, []string{"remote_addr", "remote_user", "time_local", "request", "status"}, fields)
+}
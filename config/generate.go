@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// relabelSuggestion is a relabel configuration (as YAML) that is suggested in
+// generated configuration files when its field is part of the log format
+type relabelSuggestion struct {
+	field string
+	yaml  string
+}
+
+var relabelSuggestions = []relabelSuggestion{
+	{"request", `
+      # Request paths usually contain IDs; map them to placeholders to keep
+      # the number of time series low
+      - target_label: request_uri
+        from: request
+        split: 2
+        matches:
+          - regexp: "^/users/[0-9]+"
+            replacement: "/users/:id"
+          - regexp: "^/.*"
+            replacement: "other"`},
+	{"remote_user", `
+      - target_label: user
+        from: remote_user
+        max_values: 50`},
+	{"http_x_forwarded_for", `
+      - target_label: client_ip
+        from: http_x_forwarded_for
+        action: first_ip
+        anonymize_ip: true
+        max_values: 100`},
+	{"upstream_addr", `
+      - target_label: upstream
+        from: upstream_addr
+        action: upstream_addr
+        max_values: 50`},
+	{"sent_http_content_type", `
+      - target_label: content_type
+        from: sent_http_content_type
+        action: content_type`},
+	{"server_name", `
+      - target_label: vhost
+        from: server_name
+        max_values: 50`},
+}
+
+// GenerateConfig generates a starter configuration file (in YAML format) for a
+// namespace with the given log format. If sample contains the fields of a
+// parsed sample line, their values are added as comments.
+func GenerateConfig(namespace string, format string, sample map[string]string) string {
+	var b strings.Builder
+	fields := FormatFields(format)
+
+	b.WriteString("# Generated by prometheus-nginxlog-exporter -generate-config; adjust to your needs.\n")
+	b.WriteString("listen:\n")
+	b.WriteString("  port: 4040\n")
+	b.WriteString("  address: \"0.0.0.0\"\n")
+	b.WriteString("  metrics_endpoint: \"/metrics\"\n")
+	b.WriteString("\n")
+	b.WriteString("namespaces:\n")
+	fmt.Fprintf(&b, "  - name: %s\n", strconv.Quote(namespace))
+	fmt.Fprintf(&b, "    format: %s\n", strconv.Quote(format))
+
+	if sample != nil {
+		b.WriteString("    # Fields of the sample line:\n")
+		for _, f := range fields {
+			fmt.Fprintf(&b, "    #   %s: %s\n", f, strconv.Quote(sample[f]))
+		}
+	}
+
+	b.WriteString("    source:\n")
+	b.WriteString("      files:\n")
+	b.WriteString("        - /var/log/nginx/access.log\n")
+	b.WriteString("    labels:\n")
+	fmt.Fprintf(&b, "      app: %s\n", strconv.Quote(namespace))
+
+	b.WriteString("    # The \"method\" and \"status\" labels are always added (from the\n")
+	b.WriteString("    # $request and $status fields) and need not be configured.\n")
+
+	var suggestions []string
+	for _, s := range relabelSuggestions {
+		if FormatContainsField(format, s.field) {
+			suggestions = append(suggestions, s.yaml)
+		}
+	}
+
+	if len(suggestions) > 0 {
+		b.WriteString("    relabel_configs:")
+		for _, s := range suggestions {
+			b.WriteString(s)
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
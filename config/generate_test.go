@@ -0,0 +1,47 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratedConfigCanBeLoaded(t *testing.T) {
+	t.Parallel()
+
+	format := `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_x_forwarded_for" $upstream_addr`
+	sample := map[string]string{"remote_addr": "10.0.0.1", "status": "200"}
+
+	cfg := Config{}
+	err := LoadConfigFromStream(&cfg, bytes.NewBufferString(GenerateConfig("myapp", format, sample)), TypeYAML)
+	require.Nil(t, err, "unexpected error: %v", err)
+
+	require.Len(t, cfg.Namespaces, 1)
+	n := cfg.Namespaces[0]
+
+	assert.Equal(t, "myapp", n.Name)
+	assert.Equal(t, format, n.Format)
+	assert.Equal(t, FileSource{"/var/log/nginx/access.log"}, n.SourceData.Files)
+
+	targets := make([]string, 0, len(n.RelabelConfigs))
+	for _, r := range n.RelabelConfigs {
+		require.Nil(t, r.Compile())
+		targets = append(targets, r.TargetLabel)
+	}
+
+	assert.Equal(t, []string{"request_uri", "user", "client_ip", "upstream"}, targets)
+	assert.Nil(t, n.Compile())
+}
+
+func TestGeneratedConfigWithoutSuggestions(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{}
+	err := LoadConfigFromStream(&cfg, bytes.NewBufferString(GenerateConfig("plain", "$status", nil)), TypeYAML)
+	require.Nil(t, err, "unexpected error: %v", err)
+
+	require.Len(t, cfg.Namespaces, 1)
+	assert.Empty(t, cfg.Namespaces[0].RelabelConfigs)
+}
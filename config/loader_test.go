@@ -271,3 +271,143 @@ func TestLoadsNSLabeledYAMLConfigFile(t *testing.T) {
 	assert.Nil(t, err, "unexpected error: %v", err)
 	assertLabeledConfigContents(t, cfg)
 }
+
+const HCLNamespaceListenInput = `
+namespace "team1" {
+  source_files = ["team1.log"]
+  format = "$remote_addr - $remote_user [$time_local] \"$request\" $status"
+
+  listen {
+    address = "10.0.0.2"
+    port = 4041
+    exclude_from_global = true
+  }
+}
+`
+
+func TestLoadsNamespaceListenFromHCLConfigFile(t *testing.T) {
+	t.Parallel()
+
+	buf := bytes.NewBufferString(HCLNamespaceListenInput)
+	cfg := Config{}
+
+	err := LoadConfigFromStream(&cfg, buf, TypeHCL)
+	require.Nil(t, err, "unexpected error: %v", err)
+	require.Len(t, cfg.Namespaces, 1)
+	require.NotNil(t, cfg.Namespaces[0].Listen)
+
+	assert.Equal(t, "10.0.0.2", cfg.Namespaces[0].Listen.Address)
+	assert.Equal(t, 4041, cfg.Namespaces[0].Listen.Port)
+	assert.True(t, cfg.Namespaces[0].Listen.ExcludeFromGlobal)
+}
+
+const HCLDatadogURLsInput = `
+datadog {
+  urls = ["127.0.0.1:8125", "10.0.0.5:8125"]
+}
+
+namespace "nginx" {
+  source_files = ["test.log"]
+  format = "$remote_addr \"$request\" $status"
+
+  datadog {
+    metric_prefix = "web"
+  }
+}
+`
+
+func TestLoadsDatadogURLsFromHCLConfigFile(t *testing.T) {
+	t.Parallel()
+
+	buf := bytes.NewBufferString(HCLDatadogURLsInput)
+	cfg := Config{}
+
+	err := LoadConfigFromStream(&cfg, buf, TypeHCL)
+	require.Nil(t, err, "unexpected error: %v", err)
+
+	assert.Equal(t, []string{"127.0.0.1:8125", "10.0.0.5:8125"}, cfg.Datadog.URLs)
+	require.Len(t, cfg.Namespaces, 1)
+	assert.Equal(t, "web", cfg.Namespaces[0].Datadog.MetricPrefix)
+}
+
+const HCLDurationBucketsInput = `
+namespace "nginx" {
+  source_files = ["test.log"]
+  format = "$remote_addr \"$request\" $status"
+  histogram_buckets = ["5ms", "10ms", 0.5, 1, "2.5s"]
+  interarrival_buckets = ["1s", "1m"]
+}
+`
+
+const YAMLDurationBucketsInput = `
+namespaces:
+  - name: nginx
+    source_files:
+      - test.log
+    format: "$remote_addr \"$request\" $status"
+    histogram_buckets: ["5ms", "10ms", 0.5, 1, "2.5s"]
+    interarrival_buckets: ["1s", "1m"]
+`
+
+func TestLoadsDurationBuckets(t *testing.T) {
+	t.Parallel()
+
+	inputs := map[FileFormat]string{
+		TypeHCL:  HCLDurationBucketsInput,
+		TypeYAML: YAMLDurationBucketsInput,
+	}
+
+	for typ, input := range inputs {
+		cfg := Config{}
+
+		err := LoadConfigFromStream(&cfg, bytes.NewBufferString(input), typ)
+		require.Nil(t, err, "unexpected error: %v", err)
+		require.Len(t, cfg.Namespaces, 1)
+
+		n := cfg.Namespaces[0]
+		require.Nil(t, n.Compile())
+
+		assert.Equal(t, []float64{0.005, 0.01, 0.5, 1, 2.5}, n.HistogramBuckets)
+		assert.Equal(t, []float64{1, 60}, n.InterarrivalBucketsOrDefault())
+	}
+}
+
+const HCLPrefixWithNamespaceInput = `
+namespace "app1" {
+  source_files = ["app1.log"]
+  format = "$remote_addr \"$request\" $status"
+  metrics_override = { prefix = "nginxlog" }
+  prefix_with_namespace = true
+}
+
+namespace "app2" {
+  source_files = ["app2.log"]
+  format = "$remote_addr \"$request\" $status"
+  metrics_override = { prefix = "" }
+  prefix_with_namespace = true
+}
+
+namespace "app3" {
+  source_files = ["app3.log"]
+  format = "$remote_addr \"$request\" $status"
+  metrics_override = { prefix = "nginxlog" }
+}
+`
+
+func TestPrefixWithNamespace(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{}
+
+	err := LoadConfigFromStream(&cfg, bytes.NewBufferString(HCLPrefixWithNamespaceInput), TypeHCL)
+	require.Nil(t, err, "unexpected error: %v", err)
+	require.Len(t, cfg.Namespaces, 3)
+
+	for i := range cfg.Namespaces {
+		require.Nil(t, cfg.Namespaces[i].Compile())
+	}
+
+	assert.Equal(t, "nginxlog_app1", cfg.Namespaces[0].NamespacePrefix)
+	assert.Equal(t, "app2", cfg.Namespaces[1].NamespacePrefix)
+	assert.Equal(t, "nginxlog", cfg.Namespaces[2].NamespacePrefix)
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// NginxLogFormat is a log_format directive read from an NGINX configuration
+type NginxLogFormat struct {
+	Format string
+
+	// Escape is the value of the directive's "escape" parameter; empty if
+	// not set
+	Escape string
+}
+
+// ReadNginxLogFormat reads the log_format directive with the given name from an
+// NGINX configuration file. Files included by the configuration are not read.
+// NGINX' predefined "combined" format is returned if the configuration does not
+// redefine it.
+func ReadNginxLogFormat(filename string, name string) (*NginxLogFormat, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return ParseNginxLogFormat(f, name)
+}
+
+// ParseNginxLogFormat reads the log_format directive with the given name from
+// an NGINX configuration. The directive's strings may span several lines.
+func ParseNginxLogFormat(r io.Reader, name string) (*NginxLogFormat, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := tokenizeNginxConfig(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var directive []nginxToken
+
+	for _, t := range tokens {
+		if !t.quoted && (t.value == ";" || t.value == "{" || t.value == "}") {
+			if len(directive) > 1 && !directive[0].quoted && directive[0].value == "log_format" && directive[1].value == name {
+				return newNginxLogFormat(name, directive[2:])
+			}
+
+			directive = directive[:0]
+			continue
+		}
+
+		directive = append(directive, t)
+	}
+
+	if name == "combined" {
+		return &NginxLogFormat{Format: BuiltinFormats["nginx-combined"]}, nil
+	}
+
+	return nil, fmt.Errorf("log_format '%s' not found in NGINX configuration", name)
+}
+
+func newNginxLogFormat(name string, args []nginxToken) (*NginxLogFormat, error) {
+	l := &NginxLogFormat{}
+
+	if len(args) > 0 && !args[0].quoted && strings.HasPrefix(args[0].value, "escape=") {
+		l.Escape = strings.TrimPrefix(args[0].value, "escape=")
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("log_format '%s' has no format string", name)
+	}
+
+	var b strings.Builder
+	for _, a := range args {
+		b.WriteString(a.value)
+	}
+
+	l.Format = b.String()
+	return l, nil
+}
+
+type nginxToken struct {
+	value  string
+	quoted bool
+}
+
+// tokenizeNginxConfig splits an NGINX configuration into words, quoted strings
+// and the special characters ";", "{" and "}", skipping comments
+func tokenizeNginxConfig(content string) ([]nginxToken, error) {
+	var tokens []nginxToken
+
+	for i := 0; i < len(content); {
+		c := content[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case c == ';' || c == '{' || c == '}':
+			tokens = append(tokens, nginxToken{value: string(c)})
+			i++
+		case c == '"' || c == '\'':
+			value, n, err := readNginxString(content[i:])
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, nginxToken{value: value, quoted: true})
+			i += n
+		default:
+			start := i
+			for i < len(content) && !strings.ContainsRune(" \t\r\n;{}\"'#", rune(content[i])) {
+				i++
+			}
+
+			tokens = append(tokens, nginxToken{value: content[start:i]})
+		}
+	}
+
+	return tokens, nil
+}
+
+// readNginxString reads a quoted string from the beginning of s, returning its
+// unescaped value and its length including the quotes
+func readNginxString(s string) (string, int, error) {
+	quote := s[0]
+
+	var b strings.Builder
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				break
+			}
+
+			i++
+			switch s[i] {
+			case '"', '\'', '\\':
+				b.WriteByte(s[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string in NGINX configuration")
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const nginxConfig = `
+http {
+    # log_format commented '$remote_addr';
+    log_format main '$remote_addr - $remote_user [$time_local] "$request" '
+                    '$status $body_bytes_sent "$http_referer" '
+                    "\"$http_user_agent\" $request_time";
+
+    log_format json escape=json '{"status":"$status"}';
+
+    access_log /var/log/nginx/access.log main;
+}
+`
+
+func TestMultiLineLogFormatIsParsed(t *testing.T) {
+	t.Parallel()
+
+	l, err := ParseNginxLogFormat(strings.NewReader(nginxConfig), "main")
+	require.Nil(t, err)
+
+	assert.Equal(t, `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" $request_time`, l.Format)
+	assert.Equal(t, "", l.Escape)
+}
+
+func TestLogFormatEscapeIsParsed(t *testing.T) {
+	t.Parallel()
+
+	l, err := ParseNginxLogFormat(strings.NewReader(nginxConfig), "json")
+	require.Nil(t, err)
+
+	assert.Equal(t, `{"status":"$status"}`, l.Format)
+	assert.Equal(t, EscapeJSON, l.Escape)
+}
+
+func TestMissingLogFormatIsRejected(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseNginxLogFormat(strings.NewReader(nginxConfig), "commented")
+	assert.NotNil(t, err)
+
+	// NGINX' predefined format need not be defined
+	l, err := ParseNginxLogFormat(strings.NewReader(nginxConfig), "combined")
+	require.Nil(t, err)
+	assert.Equal(t, BuiltinFormats["nginx-combined"], l.Format)
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// DefaultCustomCounterMaxValues is the number of distinct label values of a
+// custom counter, unless configured otherwise
+const DefaultCustomCounterMaxValues = 100
+
+// CustomCounter describes a counter that is incremented for each log line,
+// labeled by the value of a log field (like "$http2_stream_reset"). Unlike a
+// relabeling, it is exported as a separate metric and does not add a label to
+// the other metrics.
+type CustomCounter struct {
+	Name        string `hcl:",key" yaml:"name"`
+	SourceValue string `hcl:"from" yaml:"from"`
+	Help        string `hcl:"help" yaml:"help"`
+
+	// Label is the name of the label holding the field's value; the name of
+	// the field if not set
+	Label string `hcl:"label" yaml:"label"`
+
+	// MaxValues caps the number of distinct label values; further values
+	// are counted as "other"
+	MaxValues int `hcl:"max_values" yaml:"max_values"`
+}
+
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Validate checks if the counter has a valid name and label and a source field
+func (c *CustomCounter) Validate() error {
+	if !metricNameRegexp.MatchString(c.Name) {
+		return fmt.Errorf("invalid custom counter name '%s'", c.Name)
+	}
+
+	if c.SourceValue == "" {
+		return fmt.Errorf("custom counter '%s' has no source field ('from')", c.Name)
+	}
+
+	if !labelNameRegexp.MatchString(c.LabelOrDefault()) {
+		return fmt.Errorf("custom counter '%s' has invalid label name '%s'", c.Name, c.LabelOrDefault())
+	}
+
+	if c.MaxValues < 0 {
+		return fmt.Errorf("max_values of custom counter '%s' must not be negative", c.Name)
+	}
+
+	return nil
+}
+
+// LabelOrDefault returns the configured label name, or the name of the source
+// field if none was configured
+func (c *CustomCounter) LabelOrDefault() string {
+	if c.Label == "" {
+		return c.SourceValue
+	}
+
+	return c.Label
+}
+
+// MaxValuesOrDefault returns the configured number of distinct label values,
+// or the default if none was configured
+func (c *CustomCounter) MaxValuesOrDefault() int {
+	if c.MaxValues == 0 {
+		return DefaultCustomCounterMaxValues
+	}
+
+	return c.MaxValues
+}
+
+// HelpOrDefault returns the configured help text of the counter, or a generic
+// help text if none was configured
+func (c *CustomCounter) HelpOrDefault() string {
+	if c.Help == "" {
+		return fmt.Sprintf("Number of log lines by the value of the log field '%s'", c.SourceValue)
+	}
+
+	return c.Help
+}
@@ -2,7 +2,13 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
 	"sort"
+	"strings"
+	"time"
 )
 
 // NamespaceConfig is a struct describing single metric namespaces
@@ -17,22 +23,544 @@ type NamespaceConfig struct {
 	} `hcl:"metrics_override" yaml:"metrics_override"`
 	NamespacePrefix string
 
-	SourceFiles      []string          `hcl:"source_files" yaml:"source_files"`
-	SourceData       SourceData        `hcl:"source" yaml:"source"`
-	Format           string            `hcl:"format"`
-	Labels           map[string]string `hcl:"labels"`
-	RelabelConfigs   []RelabelConfig   `hcl:"relabel" yaml:"relabel_configs"`
-	HistogramBuckets []float64         `hcl:"histogram_buckets" yaml:"histogram_buckets"`
+	// PrefixWithNamespace appends the namespace name to the prefix of
+	// metrics_override, so that namespaces sharing a prefix still export
+	// distinct metric names
+	PrefixWithNamespace bool `hcl:"prefix_with_namespace" yaml:"prefix_with_namespace"`
+
+	SourceFiles    []string          `hcl:"source_files" yaml:"source_files"`
+	SourceData     SourceData        `hcl:"source" yaml:"source"`
+	Format         string            `hcl:"format"`
+	FormatName     string            `hcl:"format_name" yaml:"format_name"`
+	Labels         map[string]string `hcl:"labels"`
+	RelabelConfigs []RelabelConfig   `hcl:"relabel" yaml:"relabel_configs"`
+	NumericMetrics []NumericMetric   `hcl:"numeric_metric" yaml:"numeric_metrics"`
+	CustomCounters []CustomCounter   `hcl:"custom_counter" yaml:"custom_counters"`
+
+	// HistogramBucketsConfig are the buckets of the time histograms as
+	// configured, either as numbers of seconds or as duration strings; they
+	// are converted to seconds in HistogramBuckets. The same applies to the
+	// other options for the buckets of time histograms.
+	HistogramBuckets       []float64
+	HistogramBucketsConfig TimeBuckets `hcl:"histogram_buckets" yaml:"histogram_buckets"`
+
+	// DynamicLabels maps label names to files whose content (like the
+	// current release version) is added as label value to all metrics. The
+	// files are re-read after DynamicLabelInterval (like "30s").
+	DynamicLabels                map[string]string `hcl:"dynamic_labels" yaml:"dynamic_labels"`
+	DynamicLabelInterval         string            `hcl:"dynamic_label_interval" yaml:"dynamic_label_interval"`
+	DynamicLabelIntervalDuration time.Duration
+
+	// NginxConfig and NginxLogFormat read the format from the log_format
+	// directive of the given name in an NGINX configuration file
+	NginxConfig    string `hcl:"nginx_config" yaml:"nginx_config"`
+	NginxLogFormat string `hcl:"nginx_log_format" yaml:"nginx_log_format"`
+
+	// SummaryMaxAge is the duration (like "30m") for which observations are
+	// kept in the summaries, and SummaryAgeBuckets the number of buckets
+	// this duration is split into. Prometheus' defaults are used if not set.
+	SummaryMaxAge         string `hcl:"summary_max_age" yaml:"summary_max_age"`
+	SummaryMaxAgeDuration time.Duration
+	SummaryAgeBuckets     int `hcl:"summary_age_buckets" yaml:"summary_age_buckets"`
+
+	// UpstreamHistogramBuckets and ResponseHistogramBuckets override the
+	// HistogramBuckets for the upstream and response time histograms
+	UpstreamHistogramBuckets       []float64
+	UpstreamHistogramBucketsConfig TimeBuckets `hcl:"upstream_histogram_buckets" yaml:"upstream_histogram_buckets"`
+	ResponseHistogramBuckets       []float64
+	ResponseHistogramBucketsConfig TimeBuckets `hcl:"response_histogram_buckets" yaml:"response_histogram_buckets"`
+
+	// InterarrivalBuckets are the buckets of the histogram of the time
+	// between two consecutive lines of a log source
+	InterarrivalBuckets       []float64
+	InterarrivalBucketsConfig TimeBuckets `hcl:"interarrival_buckets" yaml:"interarrival_buckets"`
+
+	// ResponseSizeHistogram enables a histogram of the response body sizes
+	// (in addition to the counter of transferred bytes), with the buckets
+	// given by ResponseSizeBuckets
+	ResponseSizeHistogram bool      `hcl:"response_size_histogram" yaml:"response_size_histogram"`
+	ResponseSizeBuckets   []float64 `hcl:"response_size_buckets" yaml:"response_size_buckets"`
+
+	// UpstreamErrors enables a counter of the failed requests (with a status
+	// of 400 or above) by upstream server and status class
+	UpstreamErrors bool `hcl:"upstream_errors" yaml:"upstream_errors"`
+
+	// AutoDetectFormat selects the built-in format that parses the most of
+	// the first AutoDetectSampleLines lines of the namespace's log files
+	// (instead of setting Format or FormatName)
+	AutoDetectFormat      bool `hcl:"auto_detect_format" yaml:"auto_detect_format"`
+	AutoDetectSampleLines int  `hcl:"auto_detect_sample_lines" yaml:"auto_detect_sample_lines"`
 
 	PrintLog bool `hcl:"print_log" yaml:"print_log"`
 
+	// DebugMetrics enables additional metrics that help debugging the
+	// configuration, but might add a considerable amount of time series
+	DebugMetrics bool `hcl:"debug_metrics" yaml:"debug_metrics"`
+
+	// MetricsProfile selects which metrics are exported for this namespace;
+	// may be "full" (default) or "minimal"
+	MetricsProfile string `hcl:"metrics_profile" yaml:"metrics_profile"`
+
+	// MetricNameStyle selects the names of the built-in metrics; may be
+	// "legacy" (default) or "openmetrics"
+	MetricNameStyle string `hcl:"metric_name_style" yaml:"metric_name_style"`
+
+	// PrometheusSampleRate causes only every Nth line of each log source to
+	// update the Prometheus metrics, with counter increments scaled by N;
+	// disabled (all lines are counted) if 0 or 1
+	PrometheusSampleRate int `hcl:"prometheus_sample_rate" yaml:"prometheus_sample_rate"`
+
+	// Metrics enables or disables the built-in metrics individually
+	Metrics MetricsConfig `hcl:"metrics" yaml:"metrics"`
+
+	// HelpOverrides replaces the help texts of built-in metrics, keyed by
+	// the metric name without the namespace prefix
+	HelpOverrides map[string]string `hcl:"help_overrides" yaml:"help_overrides"`
+
+	// ResetOnReload causes the namespace's metrics to be re-created from
+	// scratch when the configuration is reloaded
+	ResetOnReload bool `hcl:"reset_on_reload" yaml:"reset_on_reload"`
+
+	// LabelConflict describes what to do when a relabeling target label has
+	// the same name as a static label; may be "error" (default) or "override"
+	LabelConflict string `hcl:"label_conflict" yaml:"label_conflict"`
+
+	// SanitizeUTF8 causes invalid UTF-8 sequences in relabeled label values
+	// to be replaced with UTF8Replacement (or U+FFFD if not set)
+	SanitizeUTF8    bool    `hcl:"sanitize_utf8" yaml:"sanitize_utf8"`
+	UTF8Replacement *string `hcl:"utf8_replacement" yaml:"utf8_replacement"`
+
+	// MaskQueryParams are query parameters (like "token") whose values are
+	// replaced with "***" in all relabeled values, before any other mapping
+	MaskQueryParams []string `hcl:"mask_query_params" yaml:"mask_query_params"`
+
+	// PathRoot adds a "path_root" label containing the first segment of the
+	// request path; disabled if not set
+	PathRoot           *PathRootConfig `hcl:"path_root" yaml:"path_root"`
+	PathRootRelabeling *RelabelConfig
+
+	// OnNegativeTiming describes what to do with negative timing values; may
+	// be "keep" (default), "clamp" (to zero) or "drop"
+	OnNegativeTiming string `hcl:"on_negative_timing" yaml:"on_negative_timing"`
+
+	// Escape describes how NGINX escapes special characters in the log
+	// format (like the "escape" parameter of NGINX' log_format directive);
+	// may be "none" (default), "default" or "json"
+	Escape string `hcl:"escape" yaml:"escape"`
+
+	// ParseTimeout is the maximum time that parsing and relabeling a single
+	// log line may take, given as duration string like "100ms"; lines that
+	// take longer are dropped. Disabled if empty.
+	ParseTimeout         string `hcl:"parse_timeout" yaml:"parse_timeout"`
+	ParseTimeoutDuration time.Duration
+
+	// StartupGrace is a duration (like "30s") after a log source was opened
+	// during which parse errors are not logged (but still counted); this
+	// suppresses errors for partial lines when starting to read in the middle
+	// of a file
+	StartupGrace         string `hcl:"startup_grace" yaml:"startup_grace"`
+	StartupGraceDuration time.Duration
+
+	// IdleWarning is a duration (like "10m") after which a warning is logged
+	// if no line of a log source could be processed; disabled if not set
+	IdleWarning         string `hcl:"idle_warning" yaml:"idle_warning"`
+	IdleWarningDuration time.Duration
+
+	// DistinctFields are log fields whose number of distinct values is
+	// estimated (with a HyperLogLog sketch) and exported as gauge. If
+	// DistinctWindow (like "1h") is set, the estimates are reset after each
+	// window.
+	DistinctFields         []string `hcl:"distinct_fields" yaml:"distinct_fields"`
+	DistinctWindow         string   `hcl:"distinct_window" yaml:"distinct_window"`
+	DistinctWindowDuration time.Duration
+
+	// RequestWindow is a duration in whole seconds (like "60s"); if set, the
+	// number of lines counted by each log source within this sliding window
+	// is exported as gauge
+	RequestWindow         string `hcl:"request_window" yaml:"request_window"`
+	RequestWindowDuration time.Duration
+
+	// MaxSeries limits the number of label value combinations that are
+	// exported; if exceeded, the least recently seen combination is removed
+	// from all metrics. Unlimited if not set.
+	MaxSeries int `hcl:"max_series" yaml:"max_series"`
+
+	// MaxParseErrorRatio (between 0 and 1) marks a log source as down, and
+	// fails the health check, while more than this ratio of its lines within
+	// ParseErrorWindow (a duration in whole seconds like "5m") could not be
+	// parsed. Disabled if not set.
+	MaxParseErrorRatio       float64 `hcl:"max_parse_error_ratio" yaml:"max_parse_error_ratio"`
+	ParseErrorWindow         string  `hcl:"parse_error_window" yaml:"parse_error_window"`
+	ParseErrorWindowDuration time.Duration
+
+	Datadog NamespaceDatadogConfig `hcl:"datadog" yaml:"datadog"`
+
+	// Listen optionally configures a separate HTTP server that serves only
+	// this namespace's metrics
+	Listen *NamespaceListenConfig `hcl:"listen" yaml:"listen"`
+
+	// Federate causes the namespace's metrics to be served at the
+	// "/federate" endpoint (in addition to the regular metrics endpoint)
+	Federate bool `hcl:"federate" yaml:"federate"`
+
+	// RequireFields are fields that must be part of the log format; this
+	// protects against format changes that silently disable metrics
+	RequireFields []string `hcl:"require_fields" yaml:"require_fields"`
+
 	OrderedLabelNames  []string
 	OrderedLabelValues []string
 }
 
+const (
+	// LabelConflictError causes label name conflicts between static and
+	// relabeled labels to be reported as configuration error
+	LabelConflictError = "error"
+
+	// LabelConflictOverride causes relabeled labels to take precedence over
+	// static labels of the same name
+	LabelConflictOverride = "override"
+
+	// NegativeTimingKeep observes negative timing values as they are
+	NegativeTimingKeep = "keep"
+
+	// NegativeTimingClamp observes negative timing values as zero
+	NegativeTimingClamp = "clamp"
+
+	// NegativeTimingDrop does not observe negative timing values at all
+	NegativeTimingDrop = "drop"
+
+	// EscapeNone parses log lines as they are
+	EscapeNone = "none"
+
+	// EscapeDefault decodes "\xHH" escape sequences in field values
+	EscapeDefault = "default"
+
+	// EscapeJSON handles escaped quotes (\") within quoted fields and decodes
+	// JSON escape sequences in field values
+	EscapeJSON = "json"
+
+	// MetricsProfileFull exports all metrics
+	MetricsProfileFull = "full"
+
+	// MetricsProfileMinimal only exports the request counter (and metrics
+	// about the exporter's operation), but no latency or size metrics
+	MetricsProfileMinimal = "minimal"
+
+	// MetricNameStyleLegacy keeps the names that the built-in metrics always
+	// had
+	MetricNameStyleLegacy = "legacy"
+
+	// MetricNameStyleOpenMetrics renames the built-in metrics that do not
+	// follow the OpenMetrics naming conventions
+	MetricNameStyleOpenMetrics = "openmetrics"
+)
+
+// DefaultParseErrorWindow is the sliding window over which the parse error
+// ratio is computed, unless configured otherwise
+const DefaultParseErrorWindow = 5 * time.Minute
+
+// DefaultAutoDetectSampleLines is the number of lines that the log format is
+// detected from, unless configured otherwise
+const DefaultAutoDetectSampleLines = 20
+
+// AutoDetectMinMatchRatio is the share of sample lines that a detected log
+// format needs to parse
+const AutoDetectMinMatchRatio = 0.8
+
+// AutoDetectSampleLinesOrDefault returns the configured number of lines that
+// the log format is detected from, or the default if none is configured
+func (c *NamespaceConfig) AutoDetectSampleLinesOrDefault() int {
+	if c.AutoDetectSampleLines > 0 {
+		return c.AutoDetectSampleLines
+	}
+
+	return DefaultAutoDetectSampleLines
+}
+
+// BuiltinMetricNames are the names (without namespace prefix) of all built-in
+// metrics of a namespace, whose help texts can be overridden
+var BuiltinMetricNames = []string{
+	"http_response_count_total",
+	"http_response_size_bytes",
+	"http_response_size_bytes_hist",
+	"http_upstream_time_seconds",
+	"http_upstream_time_seconds_hist",
+	"http_response_time_seconds",
+	"http_response_time_seconds_hist",
+	"parse_errors_total",
+	"startup_parse_errors_total",
+	"parse_timeouts_total",
+	"syslog_duplicates_dropped_total",
+	"syslog_messages_received_total",
+	"invalid_timing_total",
+	"log_bytes_read_total",
+	"log_line_interarrival_seconds",
+	"distinct_status_codes",
+	"upstream_errors_total",
+	"invalid_label_values_total",
+	"relabel_unmatched_total",
+	"timing_field_missing_total",
+	"line_processing_seconds",
+	"distinct_values_estimate",
+	"requests_in_last_window",
+	"series_evicted_total",
+}
+
+// openMetricsNames maps the legacy names of built-in metrics to names that
+// follow the OpenMetrics conventions: counters end in "_total", and all other
+// metrics end in their unit. Metrics that already follow the conventions are
+// not listed.
+var openMetricsNames = map[string]string{
+	"http_response_size_bytes":        "http_response_size_bytes_total",
+	"http_response_size_bytes_hist":   "http_response_size_hist_bytes",
+	"http_upstream_time_seconds_hist": "http_upstream_time_hist_seconds",
+	"http_response_time_seconds_hist": "http_response_time_hist_seconds",
+}
+
+// MetricName returns the name (without namespace prefix) of a built-in metric
+// in the configured metric name style. Built-in metrics are always referred to
+// by their legacy name in the configuration (like in help_overrides).
+func (c *NamespaceConfig) MetricName(name string) string {
+	if c.MetricNameStyle == MetricNameStyleOpenMetrics {
+		if renamed, ok := openMetricsNames[name]; ok {
+			return renamed
+		}
+	}
+
+	return name
+}
+
+// isBuiltinMetricName checks if a name is used by a built-in metric, in either
+// metric name style
+func isBuiltinMetricName(name string) bool {
+	for _, builtin := range BuiltinMetricNames {
+		if name == builtin || name == openMetricsNames[builtin] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HelpOrDefault returns the configured help text of a built-in metric, or
+// the given default help text if it is not overridden
+func (c *NamespaceConfig) HelpOrDefault(metric string, help string) string {
+	if override, ok := c.HelpOverrides[metric]; ok {
+		return override
+	}
+
+	return help
+}
+
+// validateHelpOverrides checks that help texts are only overridden for
+// existing metrics
+func (c *NamespaceConfig) validateHelpOverrides() error {
+	for metric := range c.HelpOverrides {
+		known := false
+		for _, name := range BuiltinMetricNames {
+			if name == metric {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("namespace '%s': help_overrides references unknown metric '%s'", c.Name, metric)
+		}
+	}
+
+	return nil
+}
+
+// MetricsConfig enables or disables each of the built-in metrics of a
+// namespace. All metrics are enabled unless disabled explicitly.
+type MetricsConfig struct {
+	Count             *bool `hcl:"count" yaml:"count"`
+	Bytes             *bool `hcl:"bytes" yaml:"bytes"`
+	UpstreamSummary   *bool `hcl:"upstream_summary" yaml:"upstream_summary"`
+	UpstreamHistogram *bool `hcl:"upstream_histogram" yaml:"upstream_histogram"`
+	ResponseSummary   *bool `hcl:"response_summary" yaml:"response_summary"`
+	ResponseHistogram *bool `hcl:"response_histogram" yaml:"response_histogram"`
+	ParseErrors       *bool `hcl:"parse_errors" yaml:"parse_errors"`
+}
+
+func enabledOrDefault(b *bool) bool {
+	return b == nil || *b
+}
+
+// CountEnabled returns whether the request counter is enabled
+func (m *MetricsConfig) CountEnabled() bool { return enabledOrDefault(m.Count) }
+
+// BytesEnabled returns whether the transferred bytes counter is enabled
+func (m *MetricsConfig) BytesEnabled() bool { return enabledOrDefault(m.Bytes) }
+
+// UpstreamSummaryEnabled returns whether the upstream time summary is enabled
+func (m *MetricsConfig) UpstreamSummaryEnabled() bool { return enabledOrDefault(m.UpstreamSummary) }
+
+// UpstreamHistogramEnabled returns whether the upstream time histogram is
+// enabled
+func (m *MetricsConfig) UpstreamHistogramEnabled() bool { return enabledOrDefault(m.UpstreamHistogram) }
+
+// ResponseSummaryEnabled returns whether the response time summary is enabled
+func (m *MetricsConfig) ResponseSummaryEnabled() bool { return enabledOrDefault(m.ResponseSummary) }
+
+// ResponseHistogramEnabled returns whether the response time histogram is
+// enabled
+func (m *MetricsConfig) ResponseHistogramEnabled() bool { return enabledOrDefault(m.ResponseHistogram) }
+
+// ParseErrorsEnabled returns whether the parse error counter is enabled
+func (m *MetricsConfig) ParseErrorsEnabled() bool { return enabledOrDefault(m.ParseErrors) }
+
+// NamespaceDatadogConfig describes how a namespace's metrics are sent to Datadog
+type NamespaceDatadogConfig struct {
+	MetricPrefix string `hcl:"metric_prefix" yaml:"metric_prefix"`
+
+	// ExcludeLabels are the names of labels that are exported to Prometheus,
+	// but not sent as tags to Datadog
+	ExcludeLabels []string `hcl:"exclude_labels" yaml:"exclude_labels"`
+
+	// FlushInterval (like "10s") causes counts and histograms to be summed up
+	// in memory and sent once per interval, instead of once per log line
+	FlushInterval         string `hcl:"flush_interval" yaml:"flush_interval"`
+	FlushIntervalDuration time.Duration
+}
+
+// NamespaceListenConfig describes a dedicated HTTP server for a namespace
+type NamespaceListenConfig struct {
+	Port            int
+	Address         string
+	MetricsEndpoint string `hcl:"metrics_endpoint" yaml:"metrics_endpoint"`
+
+	// ExcludeFromGlobal causes the namespace's metrics to be served only by
+	// the dedicated server, and not by the global one
+	ExcludeFromGlobal bool `hcl:"exclude_from_global" yaml:"exclude_from_global"`
+}
+
 type SourceData struct {
 	Files  FileSource    `hcl:"files" yaml:"files"`
 	Syslog *SyslogSource `hcl:"syslog" yaml:"syslog"`
+
+	// Journald reads the messages of systemd units from the journal
+	Journald *JournaldSource `hcl:"journald" yaml:"journald"`
+
+	// S3 reads the objects below a prefix of an S3 bucket (oneshot mode only)
+	S3 *S3Source `hcl:"s3" yaml:"s3"`
+
+	// ReopenBackoff is the maximum (randomized) delay before re-opening
+	// rotated files, given as duration string like "500ms"
+	ReopenBackoff         string `hcl:"reopen_backoff" yaml:"reopen_backoff"`
+	ReopenBackoffDuration time.Duration
+
+	// EOFGrace is the time to wait for a moved or deleted file to re-appear,
+	// given as duration string like "5s"; after that, the file is no longer
+	// followed. Files are waited for indefinitely if not set.
+	EOFGrace         string `hcl:"eof_grace" yaml:"eof_grace"`
+	EOFGraceDuration time.Duration
+
+	// ReadFromStart causes log files to be read from their beginning when
+	// the exporter starts, instead of only following new lines
+	ReadFromStart bool `hcl:"read_from_start" yaml:"read_from_start"`
+
+	// ReadBufferBytes is the size of the buffer that log files and the
+	// journal are read with; the libraries' defaults are used if not set
+	ReadBufferBytes int `hcl:"read_buffer_bytes" yaml:"read_buffer_bytes"`
+
+	// IncludeRegex and ExcludeRegex filter the lines read from the sources
+	// before they are parsed; lines that are not included or that are
+	// excluded are skipped
+	IncludeRegex  string `hcl:"include_regex" yaml:"include_regex"`
+	ExcludeRegex  string `hcl:"exclude_regex" yaml:"exclude_regex"`
+	IncludeRegexp *regexp.Regexp
+	ExcludeRegexp *regexp.Regexp
+
+	// SkipLinesPrefix lists prefixes of lines (like header or "#Fields:"
+	// comment lines) that are skipped before parsing
+	SkipLinesPrefix []string `hcl:"skip_lines_prefix" yaml:"skip_lines_prefix"`
+
+	// MultilineStartRegex matches the first line of each log entry; lines
+	// that do not match are appended to the previous line before parsing.
+	// MaxLineBytes limits the length of the joined entries.
+	MultilineStartRegex  string `hcl:"multiline_start_regex" yaml:"multiline_start_regex"`
+	MultilineStartRegexp *regexp.Regexp
+	MaxLineBytes         int `hcl:"max_line_bytes" yaml:"max_line_bytes"`
+}
+
+// DefaultMaxLineBytes is the maximum length of a joined multi-line entry,
+// unless configured otherwise
+const DefaultMaxLineBytes = 64 * 1024
+
+// MaxLineBytesOrDefault returns the configured maximum length of joined
+// multi-line entries, or the default length
+func (s *SourceData) MaxLineBytesOrDefault() int {
+	if s.MaxLineBytes > 0 {
+		return s.MaxLineBytes
+	}
+
+	return DefaultMaxLineBytes
+}
+
+// MinReadBufferBytes is the smallest read buffer size that can be configured
+const MinReadBufferBytes = 4096
+
+// Skip tests if a line should be skipped according to the skipped prefixes
+// and the include and exclude filters
+func (s *SourceData) Skip(line string) bool {
+	for _, prefix := range s.SkipLinesPrefix {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	if s.IncludeRegexp != nil && !s.IncludeRegexp.MatchString(line) {
+		return true
+	}
+
+	return s.ExcludeRegexp != nil && s.ExcludeRegexp.MatchString(line)
+}
+
+func (s *SourceData) compileFilters() error {
+	s.IncludeRegexp = nil
+	s.ExcludeRegexp = nil
+	s.MultilineStartRegexp = nil
+
+	if s.IncludeRegex != "" {
+		r, err := regexp.Compile(s.IncludeRegex)
+		if err != nil {
+			return fmt.Errorf("could not compile include_regex '%s': %s", s.IncludeRegex, err.Error())
+		}
+
+		s.IncludeRegexp = r
+	}
+
+	if s.ExcludeRegex != "" {
+		r, err := regexp.Compile(s.ExcludeRegex)
+		if err != nil {
+			return fmt.Errorf("could not compile exclude_regex '%s': %s", s.ExcludeRegex, err.Error())
+		}
+
+		s.ExcludeRegexp = r
+	}
+
+	if s.MultilineStartRegex != "" {
+		r, err := regexp.Compile(s.MultilineStartRegex)
+		if err != nil {
+			return fmt.Errorf("could not compile multiline_start_regex '%s': %s", s.MultilineStartRegex, err.Error())
+		}
+
+		s.MultilineStartRegexp = r
+	}
+
+	if s.MaxLineBytes < 0 {
+		return errors.New("max_line_bytes must not be negative")
+	}
+
+	for _, prefix := range s.SkipLinesPrefix {
+		if prefix == "" {
+			return errors.New("skip_lines_prefix must not contain an empty prefix, which would skip all lines")
+		}
+	}
+
+	return nil
 }
 
 type FileSource []string
@@ -41,6 +569,143 @@ type SyslogSource struct {
 	ListenAddress string   `hcl:"listen_address" yaml:"listen_address"`
 	Format        string   `hcl:"format" yaml:"format"`
 	Tags          []string `hcl:"tags" yaml:"tags"`
+
+	// Dedup optionally drops messages that are received more than once
+	Dedup *SyslogDedupConfig `hcl:"dedup" yaml:"dedup"`
+
+	// LabelBySource adds a "transport" label to all metrics of the
+	// namespace, containing the protocol of the listen address
+	LabelBySource bool `hcl:"label_by_source" yaml:"label_by_source"`
+}
+
+// PathRootConfig describes the "path_root" label. Its values are limited to
+// the whitelisted path segments if a whitelist is given (all others become
+// "other"), and to MaxValues distinct segments otherwise.
+type PathRootConfig struct {
+	Whitelist []string `hcl:"whitelist" yaml:"whitelist"`
+	MaxValues int      `hcl:"max_values" yaml:"max_values"`
+}
+
+// PathRootLabelName is the name of the label added by the path_root option
+const PathRootLabelName = "path_root"
+
+// DefaultUpstreamErrorsMaxValues is the number of distinct upstream servers
+// of the upstream error counter; further servers are counted as "other"
+const DefaultUpstreamErrorsMaxValues = 50
+
+// DefaultPathRootMaxValues is the default number of distinct values of the
+// "path_root" label
+const DefaultPathRootMaxValues = 20
+
+// TransportLabelName is the name of the label added by the label_by_source
+// option of syslog sources
+const TransportLabelName = "transport"
+
+// Transport returns the protocol of the listen address (like "tcp" or "udp"),
+// or an empty string if the address is not a valid URL
+func (s *SyslogSource) Transport() string {
+	u, err := url.Parse(s.ListenAddress)
+	if err != nil {
+		return ""
+	}
+
+	return u.Scheme
+}
+
+// JournaldSource describes which systemd units are read from the journal. If
+// CursorFile is set, the position of the last read message is persisted in
+// this file, so that no messages are lost or read twice across restarts.
+type JournaldSource struct {
+	Units      []string `hcl:"units" yaml:"units"`
+	CursorFile string   `hcl:"cursor_file" yaml:"cursor_file"`
+}
+
+// S3Source describes the objects of an S3-compatible object store that are
+// read in oneshot mode. Credentials and region that are not configured are
+// taken from the usual AWS environment variables.
+type S3Source struct {
+	Bucket string `hcl:"bucket" yaml:"bucket"`
+	Prefix string `hcl:"prefix" yaml:"prefix"`
+
+	// Endpoint is the URL of an S3-compatible store (like MinIO); AWS S3 is
+	// used if not set
+	Endpoint string `hcl:"endpoint" yaml:"endpoint"`
+	Region   string `hcl:"region" yaml:"region"`
+
+	AccessKeyID     string `hcl:"access_key_id" yaml:"access_key_id"`
+	SecretAccessKey string `hcl:"secret_access_key" yaml:"secret_access_key"`
+	SessionToken    string `hcl:"session_token" yaml:"session_token"`
+}
+
+// DefaultS3Region is the region used for S3 sources if neither configured nor
+// set in the environment
+const DefaultS3Region = "us-east-1"
+
+// RegionOrDefault returns the configured region, the region from the
+// AWS_REGION environment variable, or the default region
+func (s *S3Source) RegionOrDefault() string {
+	if s.Region != "" {
+		return s.Region
+	}
+
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+
+	return DefaultS3Region
+}
+
+// Credentials returns the configured access key, secret and session token,
+// falling back to the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables if no access key is configured
+func (s *S3Source) Credentials() (string, string, string) {
+	if s.AccessKeyID != "" {
+		return s.AccessKeyID, s.SecretAccessKey, s.SessionToken
+	}
+
+	return os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")
+}
+
+// SyslogDedupConfig describes how duplicate syslog messages are detected: two
+// messages are considered duplicates if they have the same value in Field and
+// are received within Window of each other
+type SyslogDedupConfig struct {
+	Field      string `hcl:"field" yaml:"field"`
+	Window     string `hcl:"window" yaml:"window"`
+	MaxEntries int    `hcl:"max_entries" yaml:"max_entries"`
+
+	WindowDuration time.Duration
+}
+
+// DefaultSyslogDedupMaxEntries is the default number of message keys that are
+// remembered for deduplication
+const DefaultSyslogDedupMaxEntries = 10000
+
+// Compile validates the deduplication settings and parses the window duration
+func (c *SyslogDedupConfig) Compile() error {
+	if c.Field == "" {
+		return errors.New("syslog dedup requires a field")
+	}
+
+	if c.MaxEntries < 0 {
+		return errors.New("max_entries of syslog dedup must not be negative")
+	}
+
+	if c.MaxEntries == 0 {
+		c.MaxEntries = DefaultSyslogDedupMaxEntries
+	}
+
+	d, err := time.ParseDuration(c.Window)
+	if err != nil {
+		return fmt.Errorf("invalid syslog dedup window: %s", err.Error())
+	}
+
+	if d <= 0 {
+		return errors.New("syslog dedup window must be positive")
+	}
+
+	c.WindowDuration = d
+	return nil
 }
 
 // StabilityWarnings tests if the NamespaceConfig uses any configuration settings
@@ -83,40 +748,803 @@ func (c *NamespaceConfig) ResolveDeprecations() {
 // Compile compiles the configuration (mostly regular expressions that are used
 // in configuration variables) for later use
 func (c *NamespaceConfig) Compile() error {
+	if c.FormatName != "" {
+		format, err := resolveFormatName(c.FormatName)
+		if err != nil {
+			return err
+		}
+
+		if c.Format != "" && c.Format != format {
+			return fmt.Errorf("namespace '%s' must not set both 'format' and 'format_name'", c.Name)
+		}
+
+		c.Format = format
+	}
+
+	if err := c.readNginxLogFormat(); err != nil {
+		return err
+	}
+
 	for i := range c.RelabelConfigs {
 		if err := c.RelabelConfigs[i].Compile(); err != nil {
-			return nil
+			return err
 		}
 	}
-	if c.NamespaceLabelName != "" {
-		c.NamespaceLabels = make(map[string]string)
-		c.NamespaceLabels[c.NamespaceLabelName] = c.Name
+
+	if err := c.validateNumericMetrics(); err != nil {
+		return err
 	}
 
-	c.OrderLabels()
-	c.NamespacePrefix = c.Name
-	if c.MetricsOverride != nil {
-		c.NamespacePrefix = c.MetricsOverride.Prefix
+	if err := c.validateCustomCounters(); err != nil {
+		return err
 	}
 
-	return nil
-}
+	if c.SourceData.ReopenBackoff != "" {
+		d, err := time.ParseDuration(c.SourceData.ReopenBackoff)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid reopen_backoff: %s", c.Name, err.Error())
+		}
 
-// OrderLabels builds two lists of label keys and values, ordered by label name
-func (c *NamespaceConfig) OrderLabels() {
-	keys := make([]string, 0, len(c.Labels))
-	values := make([]string, len(c.Labels))
+		c.SourceData.ReopenBackoffDuration = d
+	}
 
-	for k := range c.Labels {
-		keys = append(keys, k)
+	if c.SourceData.EOFGrace != "" {
+		d, err := time.ParseDuration(c.SourceData.EOFGrace)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid eof_grace: %s", c.Name, err.Error())
+		}
+
+		if d <= 0 {
+			return fmt.Errorf("namespace '%s': eof_grace must be positive", c.Name)
+		}
+
+		c.SourceData.EOFGraceDuration = d
 	}
 
-	sort.Strings(keys)
+	if c.SourceData.ReadBufferBytes != 0 && c.SourceData.ReadBufferBytes < MinReadBufferBytes {
+		return fmt.Errorf("namespace '%s': read_buffer_bytes must be at least %d", c.Name, MinReadBufferBytes)
+	}
 
-	for i, k := range keys {
-		values[i] = c.Labels[k]
+	if err := c.SourceData.compileFilters(); err != nil {
+		return fmt.Errorf("namespace '%s': %s", c.Name, err.Error())
 	}
 
-	c.OrderedLabelNames = keys
-	c.OrderedLabelValues = values
+	if c.SourceData.Journald != nil && len(c.SourceData.Journald.Units) == 0 {
+		return fmt.Errorf("namespace '%s': journald source requires at least one unit", c.Name)
+	}
+
+	if c.SourceData.S3 != nil && c.SourceData.S3.Bucket == "" {
+		return fmt.Errorf("namespace '%s': s3 source requires a bucket", c.Name)
+	}
+
+	if c.SourceData.Syslog != nil && c.SourceData.Syslog.Dedup != nil {
+		if err := c.SourceData.Syslog.Dedup.Compile(); err != nil {
+			return fmt.Errorf("namespace '%s': %s", c.Name, err.Error())
+		}
+	}
+
+	if c.SummaryMaxAge != "" {
+		d, err := time.ParseDuration(c.SummaryMaxAge)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid summary_max_age: %s", c.Name, err.Error())
+		}
+
+		if d <= 0 {
+			return fmt.Errorf("namespace '%s': summary_max_age must be positive", c.Name)
+		}
+
+		c.SummaryMaxAgeDuration = d
+	}
+
+	if c.SummaryAgeBuckets < 0 {
+		return fmt.Errorf("namespace '%s': summary_age_buckets must not be negative", c.Name)
+	}
+
+	if err := c.compileTimeBuckets(); err != nil {
+		return err
+	}
+
+	if c.ParseTimeout != "" {
+		d, err := time.ParseDuration(c.ParseTimeout)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid parse_timeout: %s", c.Name, err.Error())
+		}
+
+		if d <= 0 {
+			return fmt.Errorf("namespace '%s': parse_timeout must be positive", c.Name)
+		}
+
+		c.ParseTimeoutDuration = d
+	}
+
+	if c.StartupGrace != "" {
+		d, err := time.ParseDuration(c.StartupGrace)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid startup_grace: %s", c.Name, err.Error())
+		}
+
+		if d < 0 {
+			return fmt.Errorf("namespace '%s': startup_grace must not be negative", c.Name)
+		}
+
+		c.StartupGraceDuration = d
+	}
+
+	if c.IdleWarning != "" {
+		d, err := time.ParseDuration(c.IdleWarning)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid idle_warning: %s", c.Name, err.Error())
+		}
+
+		if d <= 0 {
+			return fmt.Errorf("namespace '%s': idle_warning must be positive", c.Name)
+		}
+
+		c.IdleWarningDuration = d
+	}
+
+	if c.AutoDetectFormat && len(c.SourceData.Files) == 0 {
+		return fmt.Errorf("namespace '%s': auto_detect_format requires a file source", c.Name)
+	}
+
+	if c.PrometheusSampleRate < 0 {
+		return fmt.Errorf("namespace '%s': prometheus_sample_rate must not be negative", c.Name)
+	}
+
+	if c.RequestWindow != "" {
+		d, err := time.ParseDuration(c.RequestWindow)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid request_window: %s", c.Name, err.Error())
+		}
+
+		if d < time.Second || d%time.Second != 0 {
+			return fmt.Errorf("namespace '%s': request_window must be a positive number of whole seconds", c.Name)
+		}
+
+		c.RequestWindowDuration = d
+	}
+
+	if c.MaxSeries < 0 {
+		return fmt.Errorf("namespace '%s': max_series must not be negative", c.Name)
+	}
+
+	if c.Datadog.FlushInterval != "" {
+		d, err := time.ParseDuration(c.Datadog.FlushInterval)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid datadog flush_interval: %s", c.Name, err.Error())
+		}
+
+		if d <= 0 {
+			return fmt.Errorf("namespace '%s': datadog flush_interval must be positive", c.Name)
+		}
+
+		c.Datadog.FlushIntervalDuration = d
+	}
+
+	if c.MaxParseErrorRatio < 0 || c.MaxParseErrorRatio > 1 {
+		return fmt.Errorf("namespace '%s': max_parse_error_ratio must be between 0 and 1", c.Name)
+	}
+
+	c.ParseErrorWindowDuration = DefaultParseErrorWindow
+	if c.ParseErrorWindow != "" {
+		d, err := time.ParseDuration(c.ParseErrorWindow)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid parse_error_window: %s", c.Name, err.Error())
+		}
+
+		if d < time.Second || d%time.Second != 0 {
+			return fmt.Errorf("namespace '%s': parse_error_window must be a positive number of whole seconds", c.Name)
+		}
+
+		c.ParseErrorWindowDuration = d
+	}
+
+	for _, f := range c.RequireFields {
+		if !FormatContainsField(c.Format, f) {
+			return fmt.Errorf("namespace '%s': required field '%s' is not part of the log format", c.Name, f)
+		}
+	}
+
+	if err := c.validateDistinctFields(); err != nil {
+		return err
+	}
+
+	if c.Listen != nil && c.Listen.Port <= 0 {
+		return fmt.Errorf("namespace '%s': listen block requires a port", c.Name)
+	}
+
+	if err := c.addTransportLabel(); err != nil {
+		return err
+	}
+
+	if err := c.compilePathRoot(); err != nil {
+		return err
+	}
+
+	if err := c.resolveLabelConflicts(); err != nil {
+		return err
+	}
+
+	if err := c.validateDynamicLabels(); err != nil {
+		return err
+	}
+
+	switch c.OnNegativeTiming {
+	case "", NegativeTimingKeep, NegativeTimingClamp, NegativeTimingDrop:
+	default:
+		return fmt.Errorf("namespace '%s': unsupported on_negative_timing value '%s' (must be '%s', '%s' or '%s')", c.Name, c.OnNegativeTiming, NegativeTimingKeep, NegativeTimingClamp, NegativeTimingDrop)
+	}
+
+	switch c.Escape {
+	case "", EscapeNone, EscapeDefault, EscapeJSON:
+	default:
+		return fmt.Errorf("namespace '%s': unsupported escape value '%s' (must be '%s', '%s' or '%s')", c.Name, c.Escape, EscapeNone, EscapeDefault, EscapeJSON)
+	}
+
+	if err := c.validateHelpOverrides(); err != nil {
+		return err
+	}
+
+	if err := c.validateMetricsProfile(); err != nil {
+		return err
+	}
+
+	switch c.MetricNameStyle {
+	case "", MetricNameStyleLegacy, MetricNameStyleOpenMetrics:
+	default:
+		return fmt.Errorf("namespace '%s': unsupported metric_name_style '%s' (must be '%s' or '%s')", c.Name, c.MetricNameStyle, MetricNameStyleLegacy, MetricNameStyleOpenMetrics)
+	}
+
+	if c.NamespaceLabelName != "" {
+		c.NamespaceLabels = make(map[string]string)
+		c.NamespaceLabels[c.NamespaceLabelName] = c.Name
+	}
+
+	c.OrderLabels()
+	c.NamespacePrefix = c.metricsPrefix()
+
+	return nil
+}
+
+// metricsPrefix returns the prefix of the namespace's metric names: the
+// namespace name, or the prefix of metrics_override (followed by the namespace
+// name if prefix_with_namespace is set)
+func (c *NamespaceConfig) metricsPrefix() string {
+	if c.MetricsOverride == nil {
+		return c.Name
+	}
+
+	if c.PrefixWithNamespace && c.MetricsOverride.Prefix != "" {
+		return c.MetricsOverride.Prefix + "_" + c.Name
+	} else if c.PrefixWithNamespace {
+		return c.Name
+	}
+
+	return c.MetricsOverride.Prefix
+}
+
+// metricsIdentity returns a string that identifies the series exported by the
+// namespace: its metrics prefix, its namespace label and its static labels.
+// It does not require the configuration to be compiled.
+func (c *NamespaceConfig) metricsIdentity() string {
+	prefix := c.metricsPrefix()
+
+	labels := make([]string, 0, len(c.Labels)+1)
+	if c.NamespaceLabelName != "" {
+		labels = append(labels, c.NamespaceLabelName+"="+c.Name)
+	}
+
+	for name, value := range c.Labels {
+		labels = append(labels, name+"="+value)
+	}
+
+	sort.Strings(labels)
+
+	return fmt.Sprintf("%s{%s}", prefix, strings.Join(labels, ","))
+}
+
+// readNginxLogFormat sets the format (and the escaping, unless configured) from
+// the configured NGINX configuration file
+func (c *NamespaceConfig) readNginxLogFormat() error {
+	if c.NginxConfig == "" {
+		if c.NginxLogFormat != "" {
+			return fmt.Errorf("namespace '%s': nginx_log_format requires nginx_config", c.Name)
+		}
+
+		return nil
+	}
+
+	if c.FormatName != "" {
+		return fmt.Errorf("namespace '%s' must not set both 'format_name' and 'nginx_config'", c.Name)
+	}
+
+	name := c.NginxLogFormat
+	if name == "" {
+		name = "combined"
+	}
+
+	l, err := ReadNginxLogFormat(c.NginxConfig, name)
+	if err != nil {
+		return fmt.Errorf("namespace '%s': could not read log format from %s: %s", c.Name, c.NginxConfig, err.Error())
+	}
+
+	if c.Format != "" && c.Format != l.Format {
+		return fmt.Errorf("namespace '%s' must not set both 'format' and 'nginx_config'", c.Name)
+	}
+
+	c.Format = l.Format
+	if c.Escape == "" {
+		c.Escape = l.Escape
+	}
+
+	return nil
+}
+
+// compilePathRoot builds the relabeling of the "path_root" label, if enabled.
+// The path is read from $request_uri, or from the second part of $request if
+// the log format contains no $request_uri.
+func (c *NamespaceConfig) compilePathRoot() error {
+	c.PathRootRelabeling = nil
+
+	if c.PathRoot == nil {
+		return nil
+	}
+
+	if c.PathRoot.MaxValues < 0 {
+		return fmt.Errorf("namespace '%s': max_values of path_root must not be negative", c.Name)
+	}
+
+	if _, ok := c.Labels[PathRootLabelName]; ok {
+		return fmt.Errorf("namespace '%s': static label '%s' conflicts with path_root", c.Name, PathRootLabelName)
+	}
+
+	if _, ok := c.DynamicLabels[PathRootLabelName]; ok {
+		return fmt.Errorf("namespace '%s': dynamic label '%s' conflicts with path_root", c.Name, PathRootLabelName)
+	}
+
+	for i := range c.RelabelConfigs {
+		if c.RelabelConfigs[i].TargetLabel == PathRootLabelName {
+			return fmt.Errorf("namespace '%s': relabeling '%s' conflicts with path_root", c.Name, PathRootLabelName)
+		}
+	}
+
+	r := &RelabelConfig{
+		TargetLabel: PathRootLabelName,
+		Action:      RelabelActionPathPrefix,
+		Whitelist:   c.PathRoot.Whitelist,
+	}
+
+	switch {
+	case FormatContainsField(c.Format, "request_uri"):
+		r.SourceValue = "request_uri"
+	case FormatContainsField(c.Format, "request"):
+		r.SourceValue = "request"
+		r.Split = 2
+	default:
+		return fmt.Errorf("namespace '%s': path_root requires $request_uri or $request in the log format", c.Name)
+	}
+
+	if len(r.Whitelist) == 0 {
+		r.MaxValues = c.PathRoot.MaxValues
+		if r.MaxValues == 0 {
+			r.MaxValues = DefaultPathRootMaxValues
+		}
+	}
+
+	if err := r.Compile(); err != nil {
+		return err
+	}
+
+	c.PathRootRelabeling = r
+	return nil
+}
+
+// addTransportLabel adds the transport of the syslog source as static label, if
+// enabled
+func (c *NamespaceConfig) addTransportLabel() error {
+	if c.SourceData.Syslog == nil || !c.SourceData.Syslog.LabelBySource {
+		return nil
+	}
+
+	transport := c.SourceData.Syslog.Transport()
+	if transport == "" {
+		return fmt.Errorf("namespace '%s': label_by_source requires a syslog listen_address like 'udp://127.0.0.1:5531'", c.Name)
+	}
+
+	if value, ok := c.Labels[TransportLabelName]; ok && value != transport {
+		return fmt.Errorf("namespace '%s': static label '%s' conflicts with the label_by_source option of the syslog source", c.Name, TransportLabelName)
+	}
+
+	if c.Labels == nil {
+		c.Labels = make(map[string]string)
+	}
+
+	c.Labels[TransportLabelName] = transport
+	return nil
+}
+
+// resolveLabelConflicts checks if any relabeling target label collides with
+// another relabeling or with a static label. Collisions with static labels are
+// either reported as error, or resolved by dropping the static label (in which
+// case the relabeled value takes precedence).
+func (c *NamespaceConfig) resolveLabelConflicts() error {
+	switch c.LabelConflict {
+	case "", LabelConflictError, LabelConflictOverride:
+	default:
+		return fmt.Errorf("namespace '%s': unsupported label_conflict value '%s' (must be '%s' or '%s')", c.Name, c.LabelConflict, LabelConflictError, LabelConflictOverride)
+	}
+
+	targets := make(map[string]struct{}, len(c.RelabelConfigs))
+
+	for i := range c.RelabelConfigs {
+		target := c.RelabelConfigs[i].TargetLabel
+
+		if _, ok := targets[target]; ok {
+			return fmt.Errorf("namespace '%s': label '%s' is used as target of more than one relabeling", c.Name, target)
+		}
+		targets[target] = struct{}{}
+
+		if _, ok := c.Labels[target]; !ok {
+			continue
+		}
+
+		if c.LabelConflict != LabelConflictOverride {
+			return fmt.Errorf("namespace '%s': relabeling target label '%s' conflicts with static label of the same name (set label_conflict = \"%s\" to let the relabeled value take precedence)", c.Name, target, LabelConflictOverride)
+		}
+
+		delete(c.Labels, target)
+	}
+
+	return nil
+}
+
+// DefaultDynamicLabelInterval is the interval after which the files of dynamic
+// labels are re-read, unless configured otherwise
+const DefaultDynamicLabelInterval = time.Minute
+
+// DynamicLabelNames returns the (sorted) names of the dynamic labels
+func (c *NamespaceConfig) DynamicLabelNames() []string {
+	names := make([]string, 0, len(c.DynamicLabels))
+	for name := range c.DynamicLabels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// validateDynamicLabels checks if the dynamic labels have valid names that are
+// not used by other labels, and parses their interval
+func (c *NamespaceConfig) validateDynamicLabels() error {
+	for name, path := range c.DynamicLabels {
+		if !labelNameRegexp.MatchString(name) {
+			return fmt.Errorf("namespace '%s': invalid dynamic label name '%s'", c.Name, name)
+		}
+
+		if path == "" {
+			return fmt.Errorf("namespace '%s': dynamic label '%s' requires a file", c.Name, name)
+		}
+
+		if _, ok := c.Labels[name]; ok {
+			return fmt.Errorf("namespace '%s': dynamic label '%s' conflicts with static label of the same name", c.Name, name)
+		}
+
+		for i := range c.RelabelConfigs {
+			if c.RelabelConfigs[i].TargetLabel == name {
+				return fmt.Errorf("namespace '%s': dynamic label '%s' conflicts with relabeling of the same name", c.Name, name)
+			}
+		}
+	}
+
+	c.DynamicLabelIntervalDuration = DefaultDynamicLabelInterval
+	if c.DynamicLabelInterval != "" {
+		d, err := time.ParseDuration(c.DynamicLabelInterval)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid dynamic_label_interval: %s", c.Name, err.Error())
+		}
+
+		if d <= 0 {
+			return fmt.Errorf("namespace '%s': dynamic_label_interval must be positive", c.Name)
+		}
+
+		c.DynamicLabelIntervalDuration = d
+	}
+
+	return nil
+}
+
+// validateNumericMetrics checks if all custom numeric metrics have valid and
+// unique names that are not used by a built-in metric, and a supported type
+func (c *NamespaceConfig) validateNumericMetrics() error {
+	names := make(map[string]struct{}, len(c.NumericMetrics))
+
+	for i := range c.NumericMetrics {
+		m := &c.NumericMetrics[i]
+
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("namespace '%s': %s", c.Name, err.Error())
+		}
+
+		if isBuiltinMetricName(m.Name) {
+			return fmt.Errorf("namespace '%s': numeric metric '%s' conflicts with a built-in metric", c.Name, m.Name)
+		}
+
+		if _, ok := names[m.Name]; ok {
+			return fmt.Errorf("namespace '%s': numeric metric '%s' is defined more than once", c.Name, m.Name)
+		}
+		names[m.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// validateCustomCounters checks if all custom counters are valid, read from a
+// field of the log format, have names that are not used by a built-in metric,
+// another custom counter or numeric metric, and labels that are not already
+// set as static or namespace label
+func (c *NamespaceConfig) validateCustomCounters() error {
+	names := make(map[string]struct{}, len(c.NumericMetrics)+len(c.CustomCounters))
+	for i := range c.NumericMetrics {
+		names[c.NumericMetrics[i].Name] = struct{}{}
+	}
+
+	for i := range c.CustomCounters {
+		m := &c.CustomCounters[i]
+
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("namespace '%s': %s", c.Name, err.Error())
+		}
+
+		if !FormatContainsField(c.Format, m.SourceValue) {
+			return fmt.Errorf("namespace '%s': source field '%s' of custom counter '%s' is not part of the log format", c.Name, m.SourceValue, m.Name)
+		}
+
+		if isBuiltinMetricName(m.Name) {
+			return fmt.Errorf("namespace '%s': custom counter '%s' conflicts with a built-in metric", c.Name, m.Name)
+		}
+
+		if _, ok := names[m.Name]; ok {
+			return fmt.Errorf("namespace '%s': metric '%s' is defined more than once", c.Name, m.Name)
+		}
+
+		label := m.LabelOrDefault()
+		if _, ok := c.Labels[label]; ok || label == c.NamespaceLabelName {
+			return fmt.Errorf("namespace '%s': label '%s' of custom counter '%s' conflicts with a static or namespace label", c.Name, label, m.Name)
+		}
+		names[m.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// validateDistinctFields checks if the fields whose distinct values should be
+// estimated are part of the log format
+func (c *NamespaceConfig) validateDistinctFields() error {
+	for _, f := range c.DistinctFields {
+		if !FormatContainsField(c.Format, f) {
+			return fmt.Errorf("namespace '%s': distinct field '%s' is not part of the log format", c.Name, f)
+		}
+	}
+
+	if c.DistinctWindow != "" {
+		d, err := time.ParseDuration(c.DistinctWindow)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid distinct_window: %s", c.Name, err.Error())
+		}
+
+		if d <= 0 {
+			return fmt.Errorf("namespace '%s': distinct_window must be positive", c.Name)
+		}
+
+		c.DistinctWindowDuration = d
+	}
+
+	return nil
+}
+
+// validateMetricsProfile checks if the metrics profile is supported. Since the
+// "minimal" profile only exports the (relabeled) request counter, all fields
+// that relabelings read from need to be present in the log format.
+func (c *NamespaceConfig) validateMetricsProfile() error {
+	switch c.MetricsProfile {
+	case "", MetricsProfileFull:
+		return nil
+	case MetricsProfileMinimal:
+	default:
+		return fmt.Errorf("namespace '%s': unsupported metrics_profile '%s' (must be '%s' or '%s')", c.Name, c.MetricsProfile, MetricsProfileFull, MetricsProfileMinimal)
+	}
+
+	if len(c.NumericMetrics) > 0 {
+		return fmt.Errorf("namespace '%s': numeric metrics are not exported with metrics_profile '%s'", c.Name, MetricsProfileMinimal)
+	}
+
+	for i := range c.RelabelConfigs {
+		for _, field := range c.RelabelConfigs[i].SourceFields() {
+			if !FormatContainsField(c.Format, field) {
+				return fmt.Errorf("namespace '%s': relabeling '%s' reads from field '%s', which is not part of the log format", c.Name, c.RelabelConfigs[i].TargetLabel, field)
+			}
+		}
+	}
+
+	return nil
+}
+
+// OrderLabels builds two lists of label keys and values, ordered by label name
+func (c *NamespaceConfig) OrderLabels() {
+	keys := make([]string, 0, len(c.Labels))
+	values := make([]string, len(c.Labels))
+
+	for k := range c.Labels {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		values[i] = c.Labels[k]
+	}
+
+	c.OrderedLabelNames = keys
+	c.OrderedLabelValues = values
+}
+
+// Copy returns a copy of the configuration that can be compiled without
+// modifying c, since Compile writes to some of its maps (like Labels) and
+// sections
+func (c *NamespaceConfig) Copy() *NamespaceConfig {
+	cfg := *c
+
+	cfg.Labels = copyStringMap(c.Labels)
+	cfg.DynamicLabels = copyStringMap(c.DynamicLabels)
+	cfg.NamespaceLabels = copyStringMap(c.NamespaceLabels)
+	cfg.RelabelConfigs = append([]RelabelConfig(nil), c.RelabelConfigs...)
+	cfg.OrderedLabelNames = append([]string(nil), c.OrderedLabelNames...)
+	cfg.OrderedLabelValues = append([]string(nil), c.OrderedLabelValues...)
+
+	if c.SourceData.Syslog != nil {
+		syslog := *c.SourceData.Syslog
+		if syslog.Dedup != nil {
+			dedup := *syslog.Dedup
+			syslog.Dedup = &dedup
+		}
+
+		cfg.SourceData.Syslog = &syslog
+	}
+
+	return &cfg
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+
+	return copied
+}
+
+// DatadogMetricPrefixOrDefault returns the configured prefix for Datadog metric
+// names, or the namespace name if no prefix was configured.
+func (c *NamespaceConfig) DatadogMetricPrefixOrDefault() string {
+	if c.Datadog.MetricPrefix == "" {
+		return c.Name
+	}
+
+	return c.Datadog.MetricPrefix
+}
+
+// AddressOrDefault returns the configured listen address, or "0.0.0.0" if no
+// address was configured
+func (l *NamespaceListenConfig) AddressOrDefault() string {
+	if l.Address == "" {
+		return "0.0.0.0"
+	}
+
+	return l.Address
+}
+
+// MetricsEndpointOrDefault returns the configured metrics endpoint or the
+// default value if no configuration was provided.
+func (l *NamespaceListenConfig) MetricsEndpointOrDefault() string {
+	if l.MetricsEndpoint == "" {
+		return "/metrics"
+	}
+
+	return l.MetricsEndpoint
+}
+
+// UTF8ReplacementOrDefault returns the string that invalid UTF-8 sequences in
+// label values are replaced with
+func (c *NamespaceConfig) UTF8ReplacementOrDefault() string {
+	if c.UTF8Replacement == nil {
+		return "\uFFFD"
+	}
+
+	return *c.UTF8Replacement
+}
+
+// compileTimeBuckets converts the configured buckets of the time histograms to
+// seconds; buckets that are not configured are left as they are
+func (c *NamespaceConfig) compileTimeBuckets() error {
+	options := []struct {
+		name    string
+		config  TimeBuckets
+		buckets *[]float64
+	}{
+		{"histogram_buckets", c.HistogramBucketsConfig, &c.HistogramBuckets},
+		{"upstream_histogram_buckets", c.UpstreamHistogramBucketsConfig, &c.UpstreamHistogramBuckets},
+		{"response_histogram_buckets", c.ResponseHistogramBucketsConfig, &c.ResponseHistogramBuckets},
+		{"interarrival_buckets", c.InterarrivalBucketsConfig, &c.InterarrivalBuckets},
+	}
+
+	for _, o := range options {
+		if len(o.config) == 0 {
+			continue
+		}
+
+		seconds, err := o.config.Seconds()
+		if err != nil {
+			return fmt.Errorf("namespace '%s': invalid %s: %s", c.Name, o.name, err.Error())
+		}
+
+		*o.buckets = seconds
+	}
+
+	return nil
+}
+
+// LineProcessingBuckets are the buckets (from 1µs to 100ms) of the histogram
+// of the time needed to process a single log line
+var LineProcessingBuckets = []float64{.000001, .000005, .00001, .00005, .0001, .0005, .001, .005, .01, .05, .1}
+
+// DefaultInterarrivalBuckets are the default buckets (from 1ms to 5m) of the
+// log line inter-arrival histogram
+var DefaultInterarrivalBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30, 60, 300}
+
+// InterarrivalBucketsOrDefault returns the buckets of the log line
+// inter-arrival histogram
+func (c *NamespaceConfig) InterarrivalBucketsOrDefault() []float64 {
+	if len(c.InterarrivalBuckets) > 0 {
+		return c.InterarrivalBuckets
+	}
+
+	return DefaultInterarrivalBuckets
+}
+
+// DefaultResponseSizeBuckets are the default buckets (from 100B to 100MB) of
+// the response size histogram
+var DefaultResponseSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000, 100000000}
+
+// ResponseSizeBucketsOrDefault returns the buckets of the response size
+// histogram
+func (c *NamespaceConfig) ResponseSizeBucketsOrDefault() []float64 {
+	if len(c.ResponseSizeBuckets) > 0 {
+		return c.ResponseSizeBuckets
+	}
+
+	return DefaultResponseSizeBuckets
+}
+
+// UpstreamHistogramBucketsOrDefault returns the buckets of the upstream time
+// histogram, falling back to the shared histogram buckets
+func (c *NamespaceConfig) UpstreamHistogramBucketsOrDefault() []float64 {
+	if len(c.UpstreamHistogramBuckets) > 0 {
+		return c.UpstreamHistogramBuckets
+	}
+
+	return c.HistogramBuckets
+}
+
+// ResponseHistogramBucketsOrDefault returns the buckets of the response time
+// histogram, falling back to the shared histogram buckets
+func (c *NamespaceConfig) ResponseHistogramBucketsOrDefault() []float64 {
+	if len(c.ResponseHistogramBuckets) > 0 {
+		return c.ResponseHistogramBuckets
+	}
+
+	return c.HistogramBuckets
 }
@@ -1,7 +1,11 @@
 package config
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -16,3 +20,721 @@ func TestSourceFilesAreMappedToNewSourceConfig(t *testing.T) {
 
 	require.Equal(t, FileSource{"bar.log", "baz.log"}, c.SourceData.Files)
 }
+
+func TestFormatNameIsExpandedToBuiltinFormat(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:       "foo",
+		FormatName: "apache-combined",
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, BuiltinFormats["apache-combined"], c.Format)
+}
+
+func TestUnknownFormatNameIsRejected(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:       "foo",
+		FormatName: "does-not-exist",
+	}
+
+	require.NotNil(t, c.Compile())
+}
+
+func TestFormatNameConflictsWithExplicitFormat(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:       "foo",
+		Format:     "$remote_addr",
+		FormatName: "nginx-combined",
+	}
+
+	require.NotNil(t, c.Compile())
+}
+
+func TestDatadogMetricPrefixDefaultsToNamespaceName(t *testing.T) {
+	c := &NamespaceConfig{Name: "foo"}
+	require.Equal(t, "foo", c.DatadogMetricPrefixOrDefault())
+
+	c.Datadog.MetricPrefix = "web"
+	require.Equal(t, "web", c.DatadogMetricPrefixOrDefault())
+}
+
+func TestRelabelTargetCollidingWithStaticLabelIsRejected(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:           "foo",
+		Labels:         map[string]string{"app": "magicapp", "host": "foo.example"},
+		RelabelConfigs: []RelabelConfig{{TargetLabel: "host", SourceValue: "server_name"}},
+	}
+
+	err := c.Compile()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "'host'")
+}
+
+func TestRelabelTargetCollidingWithStaticLabelCanOverride(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:           "foo",
+		Labels:         map[string]string{"app": "magicapp", "host": "foo.example"},
+		RelabelConfigs: []RelabelConfig{{TargetLabel: "host", SourceValue: "server_name"}},
+		LabelConflict:  LabelConflictOverride,
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, []string{"app"}, c.OrderedLabelNames)
+	require.Equal(t, []string{"magicapp"}, c.OrderedLabelValues)
+}
+
+func TestDuplicateRelabelTargetsAreRejected(t *testing.T) {
+	c := &NamespaceConfig{
+		Name: "foo",
+		RelabelConfigs: []RelabelConfig{
+			{TargetLabel: "host", SourceValue: "server_name"},
+			{TargetLabel: "host", SourceValue: "http_host"},
+		},
+	}
+
+	require.NotNil(t, c.Compile())
+}
+
+func TestMinimalMetricsProfileRequiresRelabelFieldsInFormat(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:           "audit",
+		Format:         "$time_local $action $result",
+		MetricsProfile: MetricsProfileMinimal,
+		RelabelConfigs: []RelabelConfig{
+			{TargetLabel: "action", SourceValue: "action"},
+			{TargetLabel: "result", SourceValue: "result"},
+		},
+	}
+
+	require.Nil(t, c.Compile())
+
+	c.RelabelConfigs = append(c.RelabelConfigs, RelabelConfig{TargetLabel: "user", SourceValue: "remote_user"})
+	require.NotNil(t, c.Compile())
+}
+
+func TestUnknownMetricsProfileIsRejected(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:           "foo",
+		MetricsProfile: "everything",
+	}
+
+	require.NotNil(t, c.Compile())
+}
+
+func TestSmallReadBufferIsRejected(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:       "foo",
+		SourceData: SourceData{ReadBufferBytes: 1024},
+	}
+
+	require.NotNil(t, c.Compile())
+
+	c.SourceData.ReadBufferBytes = 1024 * 1024
+	require.Nil(t, c.Compile())
+}
+
+func TestFormatIsReadFromNginxConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nginxconf")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "nginx.conf")
+	require.Nil(t, ioutil.WriteFile(file, []byte(`log_format main escape=json '$remote_addr "$request" '
+		'$status';`), 0644))
+
+	c := &NamespaceConfig{Name: "foo", NginxConfig: file, NginxLogFormat: "main"}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, `$remote_addr "$request" $status`, c.Format)
+	require.Equal(t, EscapeJSON, c.Escape)
+
+	// Compiling again keeps the format
+	require.Nil(t, c.Compile())
+
+	c = &NamespaceConfig{Name: "foo", NginxConfig: file, NginxLogFormat: "main", Format: "$remote_addr"}
+	require.NotNil(t, c.Compile())
+
+	c = &NamespaceConfig{Name: "foo", NginxConfig: file, NginxLogFormat: "other"}
+	require.NotNil(t, c.Compile())
+}
+
+func TestDynamicLabelsAreValidated(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:          "foo",
+		DynamicLabels: map[string]string{"release": "/etc/release"},
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, DefaultDynamicLabelInterval, c.DynamicLabelIntervalDuration)
+
+	c.DynamicLabelInterval = "10s"
+	require.Nil(t, c.Compile())
+	require.Equal(t, 10*time.Second, c.DynamicLabelIntervalDuration)
+
+	c.Labels = map[string]string{"release": "static"}
+	require.NotNil(t, c.Compile())
+
+	c.Labels = nil
+	c.DynamicLabels = map[string]string{"release-version": "/etc/release"}
+	require.NotNil(t, c.Compile())
+}
+
+func TestReopenBackoffIsParsed(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:       "foo",
+		SourceData: SourceData{ReopenBackoff: "250ms"},
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, 250*time.Millisecond, c.SourceData.ReopenBackoffDuration)
+
+	c.SourceData.ReopenBackoff = "soon"
+	require.NotNil(t, c.Compile())
+}
+
+func TestEOFGraceIsParsed(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:       "foo",
+		SourceData: SourceData{EOFGrace: "5s"},
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, 5*time.Second, c.SourceData.EOFGraceDuration)
+
+	c.SourceData.EOFGrace = "0s"
+	require.NotNil(t, c.Compile())
+}
+
+func TestParseTimeoutIsParsed(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:         "foo",
+		ParseTimeout: "100ms",
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, 100*time.Millisecond, c.ParseTimeoutDuration)
+
+	c.ParseTimeout = "0s"
+	require.NotNil(t, c.Compile())
+
+	c.ParseTimeout = "soon"
+	require.NotNil(t, c.Compile())
+}
+
+func TestRequiredFieldsMustBeInFormat(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:          "foo",
+		Format:        "$status $body_bytes_sent",
+		RequireFields: []string{"status", "body_bytes_sent"},
+	}
+
+	require.Nil(t, c.Compile())
+
+	c.RequireFields = append(c.RequireFields, "request_time")
+	require.NotNil(t, c.Compile())
+}
+
+func TestDistinctFieldsMustBeInFormat(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:           "foo",
+		Format:         "$request_id $status",
+		DistinctFields: []string{"request_id"},
+		DistinctWindow: "1h",
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, time.Hour, c.DistinctWindowDuration)
+
+	c.DistinctFields = []string{"request"}
+	require.NotNil(t, c.Compile())
+}
+
+func TestStartupGraceIsParsed(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:         "foo",
+		StartupGrace: "30s",
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, 30*time.Second, c.StartupGraceDuration)
+
+	c.StartupGrace = "-1s"
+	require.NotNil(t, c.Compile())
+
+	c.StartupGrace = "soon"
+	require.NotNil(t, c.Compile())
+}
+
+func TestNumericMetricsAreValidated(t *testing.T) {
+	c := &NamespaceConfig{
+		Name: "foo",
+		NumericMetrics: []NumericMetric{
+			{Name: "app_processing_seconds", SourceValue: "app_processing_time", Type: NumericMetricHistogram},
+			{Name: "app_queue_length", SourceValue: "app_queue_length", Type: NumericMetricGauge},
+		},
+	}
+
+	require.Nil(t, c.Compile())
+}
+
+func TestInvalidNumericMetricsAreRejected(t *testing.T) {
+	invalid := []NumericMetric{
+		{Name: "app-processing-seconds", SourceValue: "app_processing_time", Type: NumericMetricHistogram},
+		{Name: "app_processing_seconds", SourceValue: "", Type: NumericMetricHistogram},
+		{Name: "app_processing_seconds", SourceValue: "app_processing_time", Type: "summary"},
+	}
+
+	for _, m := range invalid {
+		c := &NamespaceConfig{
+			Name:           "foo",
+			NumericMetrics: []NumericMetric{m},
+		}
+
+		require.NotNil(t, c.Compile(), m.Name)
+	}
+}
+
+func TestNumericMetricsMustNotUseBuiltinNames(t *testing.T) {
+	for _, name := range []string{"parse_errors_total", "http_response_time_hist_seconds"} {
+		c := &NamespaceConfig{
+			Name: "foo",
+			NumericMetrics: []NumericMetric{
+				{Name: name, SourceValue: "app_processing_time", Type: NumericMetricHistogram},
+			},
+		}
+
+		require.NotNil(t, c.Compile(), name)
+	}
+}
+
+func TestCustomCountersAreValidated(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:   "foo",
+		Format: `$remote_addr "$request" $status $http2_stream_reset`,
+		CustomCounters: []CustomCounter{
+			{Name: "stream_resets_total", SourceValue: "http2_stream_reset"},
+		},
+		NumericMetrics: []NumericMetric{
+			{Name: "stream_reset_codes", SourceValue: "http2_stream_reset", Type: NumericMetricGauge},
+		},
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, "http2_stream_reset", c.CustomCounters[0].LabelOrDefault())
+	require.Equal(t, DefaultCustomCounterMaxValues, c.CustomCounters[0].MaxValuesOrDefault())
+
+	invalid := []CustomCounter{
+		{Name: "stream-resets", SourceValue: "http2_stream_reset"},
+		{Name: "stream_resets_total", SourceValue: "upstream_status"},
+		{Name: "stream_resets_total", SourceValue: "http2_stream_reset", Label: "reset-code"},
+		{Name: "stream_resets_total", SourceValue: "http2_stream_reset", MaxValues: -1},
+		{Name: "stream_reset_codes", SourceValue: "http2_stream_reset"},
+		{Name: "parse_errors_total", SourceValue: "http2_stream_reset"},
+		{Name: "http_response_size_bytes_total", SourceValue: "http2_stream_reset"},
+	}
+
+	for _, m := range invalid {
+		c.CustomCounters = []CustomCounter{m}
+		require.NotNil(t, c.Compile(), m.Name)
+	}
+}
+
+func TestCustomCounterLabelsMustNotConflictWithNamespaceLabels(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:               "foo",
+		Format:             `$remote_addr "$request" $status $http2_stream_reset`,
+		Labels:             map[string]string{"app": "shop"},
+		NamespaceLabelName: "vhost",
+	}
+
+	for _, label := range []string{"app", "vhost"} {
+		c.CustomCounters = []CustomCounter{
+			{Name: "stream_resets_total", SourceValue: "http2_stream_reset", Label: label},
+		}
+
+		require.NotNil(t, c.Compile(), label)
+	}
+
+	c.CustomCounters[0].Label = "reset_code"
+	require.Nil(t, c.Compile())
+}
+
+func TestDuplicateNumericMetricsAreRejected(t *testing.T) {
+	c := &NamespaceConfig{
+		Name: "foo",
+		NumericMetrics: []NumericMetric{
+			{Name: "app_seconds", SourceValue: "app_processing_time", Type: NumericMetricHistogram},
+			{Name: "app_seconds", SourceValue: "app_queue_time", Type: NumericMetricHistogram},
+		},
+	}
+
+	require.NotNil(t, c.Compile())
+}
+
+func TestNamespaceListenRequiresPort(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:   "foo",
+		Listen: &NamespaceListenConfig{Address: "127.0.0.1"},
+	}
+
+	require.NotNil(t, c.Compile())
+
+	c.Listen.Port = 4041
+	require.Nil(t, c.Compile())
+	require.Equal(t, "/metrics", c.Listen.MetricsEndpointOrDefault())
+}
+
+func TestSyslogDedupIsCompiled(t *testing.T) {
+	c := &NamespaceConfig{
+		Name: "foo",
+		SourceData: SourceData{
+			Syslog: &SyslogSource{
+				Dedup: &SyslogDedupConfig{Field: "request_id", Window: "30s"},
+			},
+		},
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, 30*time.Second, c.SourceData.Syslog.Dedup.WindowDuration)
+	require.Equal(t, DefaultSyslogDedupMaxEntries, c.SourceData.Syslog.Dedup.MaxEntries)
+}
+
+func TestInvalidSyslogDedupIsRejected(t *testing.T) {
+	invalid := []SyslogDedupConfig{
+		{Field: "", Window: "30s"},
+		{Field: "request_id", Window: ""},
+		{Field: "request_id", Window: "0s"},
+		{Field: "request_id", Window: "30s", MaxEntries: -1},
+	}
+
+	for i := range invalid {
+		c := &NamespaceConfig{
+			Name:       "foo",
+			SourceData: SourceData{Syslog: &SyslogSource{Dedup: &invalid[i]}},
+		}
+
+		require.NotNil(t, c.Compile())
+	}
+}
+
+func TestJournaldSourceRequiresUnits(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:       "foo",
+		SourceData: SourceData{Journald: &JournaldSource{CursorFile: "/var/lib/exporter/cursor"}},
+	}
+
+	require.NotNil(t, c.Compile())
+
+	c.SourceData.Journald.Units = []string{"nginx.service"}
+	require.Nil(t, c.Compile())
+}
+
+func TestS3SourceRequiresBucket(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:       "foo",
+		SourceData: SourceData{S3: &S3Source{Prefix: "nginx/"}},
+	}
+
+	require.NotNil(t, c.Compile())
+
+	c.SourceData.S3.Bucket = "logs"
+	require.Nil(t, c.Compile())
+}
+
+func TestHistogramBucketsFallBackToSharedBuckets(t *testing.T) {
+	c := &NamespaceConfig{
+		HistogramBuckets:         []float64{0.1, 1},
+		UpstreamHistogramBuckets: []float64{0.01, 0.1},
+	}
+
+	require.Equal(t, []float64{0.01, 0.1}, c.UpstreamHistogramBucketsOrDefault())
+	require.Equal(t, []float64{0.1, 1}, c.ResponseHistogramBucketsOrDefault())
+}
+
+func TestInvalidDurationBucketIsRejected(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:                           "foo",
+		ResponseHistogramBucketsConfig: TimeBuckets{"5ms", "1 second"},
+	}
+
+	require.NotNil(t, c.Compile())
+
+	c.ResponseHistogramBucketsConfig = TimeBuckets{"5ms", 1}
+	require.Nil(t, c.Compile())
+	require.Equal(t, []float64{0.005, 1}, c.ResponseHistogramBuckets)
+}
+
+func TestInterarrivalBucketsHaveDefault(t *testing.T) {
+	require.Equal(t, DefaultInterarrivalBuckets, (&NamespaceConfig{}).InterarrivalBucketsOrDefault())
+	require.Equal(t, []float64{1, 10}, (&NamespaceConfig{InterarrivalBuckets: []float64{1, 10}}).InterarrivalBucketsOrDefault())
+}
+
+func TestResponseSizeBucketsHaveDefault(t *testing.T) {
+	require.Equal(t, DefaultResponseSizeBuckets, (&NamespaceConfig{}).ResponseSizeBucketsOrDefault())
+	require.Equal(t, []float64{1024}, (&NamespaceConfig{ResponseSizeBuckets: []float64{1024}}).ResponseSizeBucketsOrDefault())
+}
+
+func TestSourceFiltersAreApplied(t *testing.T) {
+	c := &NamespaceConfig{
+		Name: "foo",
+		SourceData: SourceData{
+			IncludeRegex: `^\d+\.\d+\.\d+\.\d+ `,
+			ExcludeRegex: `/healthz`,
+		},
+	}
+
+	require.Nil(t, c.Compile())
+	require.False(t, c.SourceData.Skip(`1.2.3.4 - - "GET /users HTTP/1.1" 200`))
+	require.True(t, c.SourceData.Skip(`1.2.3.4 - - "GET /healthz HTTP/1.1" 200`))
+	require.True(t, c.SourceData.Skip(`2020/10/10 12:00:00 [app] starting`))
+}
+
+func TestIdleWarningIsParsed(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:        "foo",
+		IdleWarning: "10m",
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, 10*time.Minute, c.IdleWarningDuration)
+
+	c.IdleWarning = "0s"
+	require.NotNil(t, c.Compile())
+}
+
+func TestRequestWindowIsParsed(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:          "foo",
+		RequestWindow: "1m",
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, time.Minute, c.RequestWindowDuration)
+
+	c.RequestWindow = "1500ms"
+	require.NotNil(t, c.Compile())
+
+	c.RequestWindow = "0s"
+	require.NotNil(t, c.Compile())
+}
+
+func TestParseErrorRatioIsValidated(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:               "foo",
+		MaxParseErrorRatio: 0.5,
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, DefaultParseErrorWindow, c.ParseErrorWindowDuration)
+
+	c.ParseErrorWindow = "30s"
+	require.Nil(t, c.Compile())
+	require.Equal(t, 30*time.Second, c.ParseErrorWindowDuration)
+
+	c.ParseErrorWindow = "500ms"
+	require.NotNil(t, c.Compile())
+
+	c.ParseErrorWindow = ""
+	c.MaxParseErrorRatio = 1.5
+	require.NotNil(t, c.Compile())
+}
+
+func TestDatadogFlushIntervalIsParsed(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:    "foo",
+		Datadog: NamespaceDatadogConfig{FlushInterval: "10s"},
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, 10*time.Second, c.Datadog.FlushIntervalDuration)
+
+	c.Datadog.FlushInterval = "0s"
+	require.NotNil(t, c.Compile())
+}
+
+func TestNegativeMaxSeriesIsRejected(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:      "foo",
+		MaxSeries: -1,
+	}
+
+	require.NotNil(t, c.Compile())
+
+	c.MaxSeries = 1000
+	require.Nil(t, c.Compile())
+}
+
+func TestNegativePrometheusSampleRateIsRejected(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:                 "foo",
+		PrometheusSampleRate: -1,
+	}
+
+	require.NotNil(t, c.Compile())
+
+	c.PrometheusSampleRate = 10
+	require.Nil(t, c.Compile())
+}
+
+func TestHelpOverridesAreApplied(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:          "foo",
+		HelpOverrides: map[string]string{"http_response_count_total": "Requests by status"},
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, "Requests by status", c.HelpOrDefault("http_response_count_total", "default"))
+	require.Equal(t, "default", c.HelpOrDefault("parse_errors_total", "default"))
+
+	c.HelpOverrides["http_requests_total"] = "Unknown"
+	require.NotNil(t, c.Compile())
+}
+
+func TestMetricsAreEnabledByDefault(t *testing.T) {
+	disabled := false
+	m := MetricsConfig{UpstreamSummary: &disabled}
+
+	require.True(t, m.CountEnabled())
+	require.True(t, m.UpstreamHistogramEnabled())
+	require.False(t, m.UpstreamSummaryEnabled())
+}
+
+func TestLinesWithSkippedPrefixAreSkipped(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:       "foo",
+		SourceData: SourceData{SkipLinesPrefix: []string{"#", "date "}},
+	}
+
+	require.Nil(t, c.Compile())
+	require.True(t, c.SourceData.Skip(`#Fields: remote_addr status`))
+	require.True(t, c.SourceData.Skip(`date time remote_addr status`))
+	require.False(t, c.SourceData.Skip(`1.2.3.4 - - "GET /#anchor HTTP/1.1" 200`))
+}
+
+func TestInvalidSourceFilterIsRejected(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:       "foo",
+		SourceData: SourceData{IncludeRegex: `(`},
+	}
+
+	require.NotNil(t, c.Compile())
+}
+
+func TestMultilineStartRegexIsCompiled(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:       "foo",
+		SourceData: SourceData{MultilineStartRegex: `^\d+\.\d+\.\d+\.\d+ `},
+	}
+
+	require.Nil(t, c.Compile())
+	require.NotNil(t, c.SourceData.MultilineStartRegexp)
+	require.Equal(t, DefaultMaxLineBytes, c.SourceData.MaxLineBytesOrDefault())
+
+	c.SourceData.MultilineStartRegex = `(`
+	require.NotNil(t, c.Compile())
+
+	c.SourceData.MultilineStartRegex = ""
+	c.SourceData.MaxLineBytes = -1
+	require.NotNil(t, c.Compile())
+}
+
+func TestUnknownNegativeTimingPolicyIsRejected(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:             "foo",
+		OnNegativeTiming: NegativeTimingClamp,
+	}
+
+	require.Nil(t, c.Compile())
+
+	c.OnNegativeTiming = "ignore"
+	require.NotNil(t, c.Compile())
+}
+
+func TestSummaryMaxAgeIsParsed(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:              "foo",
+		SummaryMaxAge:     "30m",
+		SummaryAgeBuckets: 10,
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, 30*time.Minute, c.SummaryMaxAgeDuration)
+
+	c.SummaryMaxAge = "-1m"
+	require.NotNil(t, c.Compile())
+
+	c.SummaryMaxAge = ""
+	c.SummaryAgeBuckets = -1
+	require.NotNil(t, c.Compile())
+}
+
+func TestSyslogTransportLabelIsAdded(t *testing.T) {
+	c := &NamespaceConfig{
+		Name: "foo",
+		SourceData: SourceData{
+			Syslog: &SyslogSource{
+				ListenAddress: "tcp://127.0.0.1:8514",
+				Tags:          []string{"nginx"},
+				LabelBySource: true,
+			},
+		},
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, []string{"transport"}, c.OrderedLabelNames)
+	require.Equal(t, []string{"tcp"}, c.OrderedLabelValues)
+
+	c.Labels = map[string]string{"transport": "udp"}
+	require.NotNil(t, c.Compile())
+
+	c.Labels = nil
+	c.SourceData.Syslog.ListenAddress = "127.0.0.1:8514"
+	require.NotNil(t, c.Compile())
+}
+
+func TestPathRootReadsFromRequestURIOrRequest(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:     "foo",
+		Format:   `$remote_addr "$request" $status $request_uri`,
+		PathRoot: &PathRootConfig{},
+	}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, "request_uri", c.PathRootRelabeling.SourceValue)
+	require.Equal(t, DefaultPathRootMaxValues, c.PathRootRelabeling.MaxValues)
+
+	c.Format = `$remote_addr "$request" $status`
+	require.Nil(t, c.Compile())
+	require.Equal(t, "request", c.PathRootRelabeling.SourceValue)
+	require.Equal(t, 2, c.PathRootRelabeling.Split)
+
+	c.Format = `$remote_addr $status`
+	require.NotNil(t, c.Compile())
+}
+
+func TestPathRootConflictsWithRelabeling(t *testing.T) {
+	c := &NamespaceConfig{
+		Name:           "foo",
+		Format:         `$remote_addr "$request" $status`,
+		PathRoot:       &PathRootConfig{Whitelist: []string{"api"}},
+		RelabelConfigs: []RelabelConfig{{TargetLabel: "path_root", SourceValue: "request"}},
+	}
+
+	require.NotNil(t, c.Compile())
+}
+
+func TestMetricNameStyleRenamesBuiltinMetrics(t *testing.T) {
+	c := &NamespaceConfig{Name: "foo"}
+
+	require.Nil(t, c.Compile())
+	require.Equal(t, "http_response_size_bytes", c.MetricName("http_response_size_bytes"))
+
+	c.MetricNameStyle = MetricNameStyleOpenMetrics
+	require.Nil(t, c.Compile())
+	require.Equal(t, "http_response_size_bytes_total", c.MetricName("http_response_size_bytes"))
+	require.Equal(t, "http_response_time_hist_seconds", c.MetricName("http_response_time_seconds_hist"))
+	require.Equal(t, "http_response_count_total", c.MetricName("http_response_count_total"))
+
+	c.MetricNameStyle = "prometheus"
+	require.NotNil(t, c.Compile())
+}
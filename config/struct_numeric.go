@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// NumericMetric describes a custom metric that is populated from a numeric
+// field of the access log (like "$app_processing_time")
+type NumericMetric struct {
+	Name        string `hcl:",key" yaml:"name"`
+	SourceValue string `hcl:"from" yaml:"from"`
+	Type        string `hcl:"type" yaml:"type"`
+	Help        string `hcl:"help" yaml:"help"`
+}
+
+const (
+	// NumericMetricCounter adds up the field's values
+	NumericMetricCounter = "counter"
+
+	// NumericMetricGauge exports the most recent value of the field
+	NumericMetricGauge = "gauge"
+
+	// NumericMetricHistogram observes the field's values in a histogram,
+	// using the namespace's histogram buckets
+	NumericMetricHistogram = "histogram"
+)
+
+var metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Validate checks if the metric has a valid name, a source field and a
+// supported type
+func (m *NumericMetric) Validate() error {
+	if !metricNameRegexp.MatchString(m.Name) {
+		return fmt.Errorf("invalid numeric metric name '%s'", m.Name)
+	}
+
+	if m.SourceValue == "" {
+		return fmt.Errorf("numeric metric '%s' has no source field ('from')", m.Name)
+	}
+
+	switch m.Type {
+	case NumericMetricCounter, NumericMetricGauge, NumericMetricHistogram:
+	default:
+		return fmt.Errorf("numeric metric '%s' has unsupported type '%s' (must be '%s', '%s' or '%s')", m.Name, m.Type, NumericMetricCounter, NumericMetricGauge, NumericMetricHistogram)
+	}
+
+	return nil
+}
+
+// HelpOrDefault returns the configured help text of the metric, or a generic
+// help text if none was configured
+func (m *NumericMetric) HelpOrDefault() string {
+	if m.Help == "" {
+		return fmt.Sprintf("Values of the log field '%s'", m.SourceValue)
+	}
+
+	return m.Help
+}
@@ -2,7 +2,10 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"regexp"
+	"strconv"
+	"strings"
 )
 
 // RelabelConfig is a struct describing a single re-labeling configuration for taking
@@ -13,11 +16,268 @@ type RelabelConfig struct {
 	Whitelist   []string            `hcl:"whitelist"`
 	Matches     []RelabelValueMatch `hcl:"match"`
 	Split       int                 `hcl:"split"`
+	Action      string              `hcl:"action" yaml:"action"`
+	MaxValues   int                 `hcl:"max_values" yaml:"max_values"`
+	AnonymizeIP bool                `hcl:"anonymize_ip" yaml:"anonymize_ip"`
+	Modulus     int                 `hcl:"modulus" yaml:"modulus"`
+	CIDRs       []RelabelCIDR       `hcl:"cidr" yaml:"cidrs"`
+
+	// Buckets map numeric values to named ranges; each value is mapped to
+	// the first bucket whose threshold is greater than or equal to it
+	Buckets []RelabelBucket `hcl:"bucket" yaml:"buckets"`
+
+	// Extensions are the file extensions (like ".js") of the request paths
+	// that an is_static_asset relabeling maps to "true"; common static
+	// types if not set
+	Extensions   []string `hcl:"extensions" yaml:"extensions"`
+	ExtensionSet map[string]struct{}
+
+	// Routes normalize request paths into canonical routes; the first route
+	// whose regular expression matches the path (without query string)
+	// determines the value
+	Routes []RelabelRoute `hcl:"route" yaml:"routes"`
+
+	// Template builds the source value from several fields, which are
+	// referenced as "{field}" (like "{request_method} {uri}"); it replaces
+	// the single source field given by SourceValue
+	Template      string `hcl:"template" yaml:"template"`
+	TemplateParts []RelabelTemplatePart
+
+	// DefaultValue replaces values that match none of the match statements
+	// or routes, or are not whitelisted; if not set, unmatched values become
+	// empty, and non-whitelisted values and unmatched routes "other"
+	DefaultValue string `hcl:"default_value" yaml:"default_value"`
+
+	// OverflowValue is the value that all values beyond MaxValues are
+	// subsumed under; "other" if not set
+	OverflowValue string `hcl:"overflow_value" yaml:"overflow_value"`
+
+	// LabelValuePattern is a regular expression that mapped values need to
+	// match completely; values that do not are replaced with "invalid"
+	LabelValuePattern string `hcl:"label_value_pattern" yaml:"label_value_pattern"`
+	LabelValueRegexp  *regexp.Regexp
 
 	WhitelistExists bool
 	WhitelistMap    map[string]interface{}
 }
 
+const (
+	// RelabelActionFirstIP extracts the first address from a comma-separated
+	// list of IP addresses (like in the X-Forwarded-For header)
+	RelabelActionFirstIP = "first_ip"
+
+	// RelabelActionContentType normalizes a Content-Type header value into
+	// one of a small set of content types
+	RelabelActionContentType = "content_type"
+
+	// RelabelActionNonEmpty maps empty values (and "-", which NGINX logs for
+	// empty variables) to "unknown"
+	RelabelActionNonEmpty = "non_empty"
+
+	// RelabelActionUpstreamAddr extracts the host of the upstream server that
+	// finally handled a request from NGINX' $upstream_addr variable
+	RelabelActionUpstreamAddr = "upstream_addr"
+
+	// RelabelActionHour extracts the hour of the day (00-23) from a
+	// timestamp like $time_local or $time_iso8601
+	RelabelActionHour = "hour"
+
+	// RelabelActionHashMod hashes the value and maps it to one of "modulus"
+	// buckets (from 0 to modulus-1)
+	RelabelActionHashMod = "hash_mod"
+
+	// RelabelActionCacheHitBool maps NGINX' $upstream_cache_status to "true"
+	// if the response was served from the cache, and "false" otherwise
+	RelabelActionCacheHitBool = "cache_hit_bool"
+
+	// RelabelActionCIDRMap maps IP addresses to the label of the first of
+	// the configured networks that contains them
+	RelabelActionCIDRMap = "cidr_map"
+
+	// RelabelActionNumericBucket maps numeric values to the label of the
+	// first of the configured buckets whose threshold is not exceeded
+	RelabelActionNumericBucket = "numeric_bucket"
+
+	// RelabelActionPathPrefix extracts the first segment of a request path
+	// (like "api" for "/api/v1/users")
+	RelabelActionPathPrefix = "path_prefix"
+
+	// RelabelActionCommonName extracts the common name (CN) from a
+	// distinguished name like NGINX' $ssl_client_s_dn
+	RelabelActionCommonName = "common_name"
+
+	// RelabelActionURLHost extracts the host from a URL like NGINX'
+	// $http_referer
+	RelabelActionURLHost = "url_host"
+
+	// RelabelActionIsStaticAsset maps request paths to "true" if their file
+	// extension is one of the configured extensions, and "false" otherwise
+	RelabelActionIsStaticAsset = "is_static_asset"
+)
+
+// DefaultStaticAssetExtensions are the extensions of the paths that an
+// is_static_asset relabeling considers static, unless extensions are
+// configured
+var DefaultStaticAssetExtensions = []string{
+	".js", ".mjs", ".css", ".map",
+	".png", ".jpg", ".jpeg", ".gif", ".svg", ".ico", ".webp", ".avif",
+	".woff", ".woff2", ".ttf", ".otf", ".eot",
+	".mp4", ".webm", ".mp3",
+	".txt", ".xml", ".pdf",
+}
+
+// DefaultURLHostMaxValues is the number of distinct hosts of a url_host
+// relabeling, unless max_values is configured
+const DefaultURLHostMaxValues = 50
+
+// DefaultNumericBucketValue is the value of numbers that exceed the thresholds
+// of all buckets of a numeric_bucket relabeling
+const DefaultNumericBucketValue = "other"
+
+// DefaultCIDRMapValue is the value of IP addresses that are not contained in
+// any of the networks of a cidr_map relabeling
+const DefaultCIDRMapValue = "external"
+
+var relabelActions = map[string]struct{}{
+	RelabelActionFirstIP:       {},
+	RelabelActionContentType:   {},
+	RelabelActionNonEmpty:      {},
+	RelabelActionUpstreamAddr:  {},
+	RelabelActionHour:          {},
+	RelabelActionHashMod:       {},
+	RelabelActionCacheHitBool:  {},
+	RelabelActionCIDRMap:       {},
+	RelabelActionNumericBucket: {},
+	RelabelActionPathPrefix:    {},
+	RelabelActionCommonName:    {},
+	RelabelActionURLHost:       {},
+	RelabelActionIsStaticAsset: {},
+}
+
+// InvalidLabelValue is the label value that replaces values not matching a
+// relabeling's label_value_pattern
+const InvalidLabelValue = "invalid"
+
+// DefaultOverflowValue is the label value that values beyond a relabeling's
+// max_values are subsumed under, unless overridden by overflow_value
+const DefaultOverflowValue = "other"
+
+// MaxValuesOrDefault returns the configured number of distinct values of the
+// relabeling, the default number of its action, or 0 if it is not limited
+func (c *RelabelConfig) MaxValuesOrDefault() int {
+	if c.MaxValues == 0 && c.Action == RelabelActionURLHost {
+		return DefaultURLHostMaxValues
+	}
+
+	return c.MaxValues
+}
+
+// OverflowValueOrDefault returns the overflow value of the relabeling, or the
+// default if none is configured
+func (c *RelabelConfig) OverflowValueOrDefault() string {
+	if c.OverflowValue != "" {
+		return c.OverflowValue
+	}
+
+	return DefaultOverflowValue
+}
+
+// RelabelTemplatePart is either a literal string or a reference to a field of
+// a relabeling's template
+type RelabelTemplatePart struct {
+	Literal string
+	Field   string
+}
+
+// SourceFields returns the names of all fields that the relabeling reads from
+func (c *RelabelConfig) SourceFields() []string {
+	if c.Template == "" {
+		return []string{c.SourceValue}
+	}
+
+	fields := make([]string, 0, len(c.TemplateParts))
+	for _, p := range c.TemplateParts {
+		if p.Field != "" {
+			fields = append(fields, p.Field)
+		}
+	}
+
+	return fields
+}
+
+// compileTemplate splits the template into literal strings and references to
+// fields
+func (c *RelabelConfig) compileTemplate() error {
+	c.TemplateParts = nil
+
+	if c.Template == "" {
+		return nil
+	}
+
+	if c.SourceValue != "" {
+		return fmt.Errorf("relabeling '%s' must not have both 'from' and 'template'", c.TargetLabel)
+	}
+
+	rest := c.Template
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			c.TemplateParts = append(c.TemplateParts, RelabelTemplatePart{Literal: rest})
+			break
+		}
+
+		if start > 0 {
+			c.TemplateParts = append(c.TemplateParts, RelabelTemplatePart{Literal: rest[:start]})
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return fmt.Errorf("template '%s' of relabeling '%s' contains an unclosed '{'", c.Template, c.TargetLabel)
+		}
+
+		field := rest[start+1 : start+end]
+		if field == "" {
+			return fmt.Errorf("template '%s' of relabeling '%s' contains an empty field reference", c.Template, c.TargetLabel)
+		}
+
+		c.TemplateParts = append(c.TemplateParts, RelabelTemplatePart{Field: field})
+		rest = rest[start+end+1:]
+	}
+
+	return nil
+}
+
+// RelabelCIDR maps the IP addresses of a network to a label value
+type RelabelCIDR struct {
+	CIDR  string `hcl:",key" yaml:"cidr"`
+	Label string `hcl:"label" yaml:"label"`
+
+	Network *net.IPNet
+}
+
+// RelabelBucket maps the numeric values up to (and including) a threshold to a
+// label value
+type RelabelBucket struct {
+	Threshold string `hcl:",key" yaml:"threshold"`
+	Label     string `hcl:"label" yaml:"label"`
+
+	UpperBound float64
+}
+
+// DefaultRouteValue is the value of request paths that match none of the
+// routes of a relabeling
+const DefaultRouteValue = "other"
+
+// RelabelRoute maps request paths matching a regular expression to a route.
+// The replacement may reference capture groups of the expression by number
+// ("$1") or by name ("${version}").
+type RelabelRoute struct {
+	RegexpString string `hcl:",key" yaml:"regexp"`
+	Replacement  string `hcl:"replacement" yaml:"replacement"`
+
+	CompiledRegexp *regexp.Regexp
+}
+
 // RelabelValueMatch describes a single label match statement
 type RelabelValueMatch struct {
 	RegexpString string `hcl:",key" yaml:"regexp"`
@@ -28,6 +288,68 @@ type RelabelValueMatch struct {
 
 // Compile compiles expressions and lookup tables for efficient later use
 func (c *RelabelConfig) Compile() error {
+	if c.MaxValues < 0 {
+		return fmt.Errorf("max_values of relabeling '%s' must not be negative", c.TargetLabel)
+	}
+
+	if c.Action != "" {
+		if _, ok := relabelActions[c.Action]; !ok {
+			return fmt.Errorf("unknown relabel action '%s'", c.Action)
+		}
+	}
+
+	if c.Action == RelabelActionHashMod && c.Modulus <= 0 {
+		return fmt.Errorf("relabeling '%s' with action '%s' requires a positive modulus", c.TargetLabel, c.Action)
+	}
+
+	if err := c.compileTemplate(); err != nil {
+		return err
+	}
+
+	if c.Action == RelabelActionCIDRMap && len(c.CIDRs) == 0 {
+		return fmt.Errorf("relabeling '%s' with action '%s' requires at least one cidr", c.TargetLabel, c.Action)
+	}
+
+	for i := range c.CIDRs {
+		_, network, err := net.ParseCIDR(c.CIDRs[i].CIDR)
+		if err != nil {
+			return fmt.Errorf("invalid cidr '%s' of relabeling '%s': %s", c.CIDRs[i].CIDR, c.TargetLabel, err.Error())
+		}
+
+		c.CIDRs[i].Network = network
+	}
+
+	if err := c.compileBuckets(); err != nil {
+		return err
+	}
+
+	c.LabelValueRegexp = nil
+	if c.LabelValuePattern != "" {
+		r, err := regexp.Compile("^(?:" + c.LabelValuePattern + ")$")
+		if err != nil {
+			return fmt.Errorf("could not compile label_value_pattern '%s' of relabeling '%s': %s", c.LabelValuePattern, c.TargetLabel, err.Error())
+		}
+
+		c.LabelValueRegexp = r
+	}
+
+	if err := c.compileExtensions(); err != nil {
+		return err
+	}
+
+	for i := range c.Routes {
+		if c.Routes[i].Replacement == "" {
+			return fmt.Errorf("route '%s' of relabeling '%s' requires a replacement", c.Routes[i].RegexpString, c.TargetLabel)
+		}
+
+		r, err := regexp.Compile(c.Routes[i].RegexpString)
+		if err != nil {
+			return fmt.Errorf("could not compile route regexp '%s': %s", c.Routes[i].RegexpString, err.Error())
+		}
+
+		c.Routes[i].CompiledRegexp = r
+	}
+
 	c.WhitelistMap = make(map[string]interface{})
 	c.WhitelistExists = len(c.Whitelist) > 0
 
@@ -48,3 +370,64 @@ func (c *RelabelConfig) Compile() error {
 
 	return nil
 }
+
+// compileExtensions builds the set of static extensions of an
+// is_static_asset relabeling. Extensions are matched case-insensitively, and
+// may be given with or without leading dot.
+func (c *RelabelConfig) compileExtensions() error {
+	c.ExtensionSet = nil
+
+	if c.Action != RelabelActionIsStaticAsset {
+		if len(c.Extensions) > 0 {
+			return fmt.Errorf("extensions of relabeling '%s' require action '%s'", c.TargetLabel, RelabelActionIsStaticAsset)
+		}
+
+		return nil
+	}
+
+	extensions := c.Extensions
+	if len(extensions) == 0 {
+		extensions = DefaultStaticAssetExtensions
+	}
+
+	c.ExtensionSet = make(map[string]struct{}, len(extensions))
+	for _, ext := range extensions {
+		e := strings.ToLower(strings.TrimPrefix(ext, "."))
+		if e == "" || strings.ContainsAny(e, "./") {
+			return fmt.Errorf("invalid extension '%s' of relabeling '%s'", ext, c.TargetLabel)
+		}
+
+		c.ExtensionSet["."+e] = struct{}{}
+	}
+
+	return nil
+}
+
+// compileBuckets parses the thresholds of the buckets, which need to be given
+// in ascending order
+func (c *RelabelConfig) compileBuckets() error {
+	if c.Action == RelabelActionNumericBucket && len(c.Buckets) == 0 {
+		return fmt.Errorf("relabeling '%s' with action '%s' requires at least one bucket", c.TargetLabel, c.Action)
+	}
+
+	for i := range c.Buckets {
+		b := &c.Buckets[i]
+
+		bound, err := strconv.ParseFloat(b.Threshold, 64)
+		if err != nil {
+			return fmt.Errorf("invalid bucket threshold '%s' of relabeling '%s': %s", b.Threshold, c.TargetLabel, err.Error())
+		}
+
+		if i > 0 && bound <= c.Buckets[i-1].UpperBound {
+			return fmt.Errorf("bucket thresholds of relabeling '%s' must be in ascending order", c.TargetLabel)
+		}
+
+		if b.Label == "" {
+			return fmt.Errorf("bucket '%s' of relabeling '%s' requires a label", b.Threshold, c.TargetLabel)
+		}
+
+		b.UpperBound = bound
+	}
+
+	return nil
+}
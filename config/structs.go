@@ -1,5 +1,11 @@
 package config
 
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
 // StartupFlags is a struct containing options that can be passed via the
 // command line
 type StartupFlags struct {
@@ -10,7 +16,15 @@ type StartupFlags struct {
 	ListenPort                 int
 	EnableExperimentalFeatures bool
 	DatadogUrl                 string
+	DatadogBreakerThreshold    int
+	DatadogBreakerCooldown     time.Duration
 	MetricsEndpoint            string
+	Oneshot                    bool
+	StrictFDLimit              bool
+	RaiseFDLimit               bool
+	FollowerLeakMargin         int
+	Output                     string
+	GenerateConfig             bool
 
 	CPUProfile string
 	MemProfile string
@@ -19,9 +33,14 @@ type StartupFlags struct {
 // Config models the application's configuration
 type Config struct {
 	Listen                     ListenConfig
+	AdminListen                *AdminListenConfig `hcl:"admin_listen" yaml:"admin_listen"`
 	Consul                     ConsulConfig
-	Namespaces                 []NamespaceConfig `hcl:"namespace"`
-	EnableExperimentalFeatures bool              `hcl:"enable_experimental" yaml:"enable_experimental"`
+	Datadog                    DatadogConfig      `hcl:"datadog" yaml:"datadog"`
+	OTLP                       *OTLPConfig        `hcl:"otlp" yaml:"otlp"`
+	RemoteWrite                *RemoteWriteConfig `hcl:"remote_write" yaml:"remote_write"`
+	Pushgateway                *PushgatewayConfig `hcl:"pushgateway" yaml:"pushgateway"`
+	Namespaces                 []NamespaceConfig  `hcl:"namespace"`
+	EnableExperimentalFeatures bool               `hcl:"enable_experimental" yaml:"enable_experimental"`
 
 	// In YAML, the EnableExperimentalFeatures property was originally set by the
 	// "enableexperimentalfeatures" property (although documented as "enable_experimental").
@@ -34,8 +53,137 @@ type ListenConfig struct {
 	Port            int
 	Address         string
 	MetricsEndpoint string `hcl:"metrics_endpoint" yaml:"metrics_endpoint"`
+
+	// IsolateNamespaces causes each namespace to be gathered separately on
+	// scrape, so that a failing or blocking namespace does not fail the
+	// entire scrape
+	IsolateNamespaces bool   `hcl:"isolate_namespaces" yaml:"isolate_namespaces"`
+	ScrapeTimeout     string `hcl:"scrape_timeout" yaml:"scrape_timeout"`
+
+	// ReloadToken enables the "/-/reload-relabel" endpoint, which requires
+	// this token to be passed as bearer token
+	ReloadToken string `hcl:"reload_token" yaml:"reload_token"`
+
+	// ReusePort sets SO_REUSEPORT on the listening socket, so that two
+	// exporter instances can listen on the same port during restarts
+	ReusePort bool `hcl:"reuse_port" yaml:"reuse_port"`
+
+	// ReadTimeout, WriteTimeout and IdleTimeout are the timeouts of the HTTP
+	// servers (like "30s"); a value of "0s" disables the respective timeout
+	ReadTimeout  string `hcl:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout string `hcl:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout  string `hcl:"idle_timeout" yaml:"idle_timeout"`
+
+	// DisableKeepAlives causes the HTTP servers to close each connection
+	// after a single request
+	DisableKeepAlives bool `hcl:"disable_keep_alives" yaml:"disable_keep_alives"`
+}
+
+// AdminListenConfig describes a separate HTTP server for the administrative
+// endpoints (health check, profiling and relabel reload). If configured, the
+// server of the listen block serves only metrics.
+type AdminListenConfig struct {
+	Port    int
+	Address string
+
+	// StatusPage enables an HTML page at /status showing the current rates
+	// of each namespace
+	StatusPage bool `hcl:"status_page" yaml:"status_page"`
+}
+
+// AddressOrDefault returns the configured listen address, or "0.0.0.0" if no
+// address was configured
+func (l *AdminListenConfig) AddressOrDefault() string {
+	if l.Address == "" {
+		return "0.0.0.0"
+	}
+
+	return l.Address
+}
+
+const (
+	// DefaultReadTimeout is the default time within which a request must
+	// have been read completely
+	DefaultReadTimeout = 30 * time.Second
+
+	// DefaultWriteTimeout is the default time within which a response must
+	// have been written; this includes gathering the metrics
+	DefaultWriteTimeout = time.Minute
+
+	// DefaultIdleTimeout is the default time after which idle keep-alive
+	// connections are closed
+	DefaultIdleTimeout = 2 * time.Minute
+)
+
+// DatadogConfig describes the DogStatsD agents that metrics are sent to
+type DatadogConfig struct {
+	// URLs are the addresses of all agents that metrics are sent to; if
+	// empty, the agent given by the -datadog-url flag is used
+	URLs []string `hcl:"urls" yaml:"urls"`
+
+	// OriginDetection enables the origin detection of the DogStatsD client,
+	// which sends the ID of the exporter's container with each metric so
+	// that the agent can tag it with the pod or container. If the
+	// DD_ENTITY_ID environment variable is set, its value is sent instead
+	// (regardless of this option).
+	OriginDetection bool `hcl:"origin_detection" yaml:"origin_detection"`
+}
+
+// OTLPConfig describes an OpenTelemetry collector that the metrics are
+// periodically pushed to, using OTLP over gRPC
+type OTLPConfig struct {
+	// Endpoint is the address of the collector's gRPC receiver, like
+	// "localhost:4317", or its URL, like "https://localhost:4317"
+	Endpoint string            `hcl:"endpoint" yaml:"endpoint"`
+	Interval string            `hcl:"interval" yaml:"interval"`
+	Headers  map[string]string `hcl:"headers" yaml:"headers"`
+
+	// Insecure disables TLS for an endpoint that is given as address
+	Insecure bool `hcl:"insecure" yaml:"insecure"`
+}
+
+// RemoteWriteConfig describes an endpoint that the metrics are periodically
+// sent to using the Prometheus remote write protocol
+type RemoteWriteConfig struct {
+	URL      string `hcl:"url" yaml:"url"`
+	Interval string `hcl:"interval" yaml:"interval"`
+
+	// Username and Password are sent using basic authentication, and
+	// BearerToken as bearer token; at most one of them should be set
+	Username    string `hcl:"username" yaml:"username"`
+	Password    string `hcl:"password" yaml:"password"`
+	BearerToken string `hcl:"bearer_token" yaml:"bearer_token"`
+}
+
+// PushgatewayConfig describes a Prometheus Pushgateway that the metrics of each
+// namespace are pushed to, periodically and once at shutdown
+type PushgatewayConfig struct {
+	URL      string            `hcl:"url" yaml:"url"`
+	Job      string            `hcl:"job" yaml:"job"`
+	Grouping map[string]string `hcl:"grouping" yaml:"grouping"`
+	Interval string            `hcl:"interval" yaml:"interval"`
+
+	// Username and Password are sent using basic authentication
+	Username string `hcl:"username" yaml:"username"`
+	Password string `hcl:"password" yaml:"password"`
+
+	TLS *PushgatewayTLSConfig `hcl:"tls" yaml:"tls"`
 }
 
+// PushgatewayTLSConfig describes how the TLS connection to the Pushgateway is
+// established. CAFile replaces the system's root certificates, and CertFile
+// and KeyFile configure a client certificate.
+type PushgatewayTLSConfig struct {
+	CAFile             string `hcl:"ca_file" yaml:"ca_file"`
+	CertFile           string `hcl:"cert_file" yaml:"cert_file"`
+	KeyFile            string `hcl:"key_file" yaml:"key_file"`
+	InsecureSkipVerify bool   `hcl:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+}
+
+// DefaultPushgatewayJob is the job name used for pushing to the Pushgateway if
+// none is configured
+const DefaultPushgatewayJob = "nginxlog_exporter"
+
 // ConsulConfig describes the connection to a Consul server that the exporter should
 // register itself at
 type ConsulConfig struct {
@@ -71,6 +219,70 @@ func (c *Config) StabilityWarnings() error {
 	return nil
 }
 
+// CheckNamespaceConflicts tests if two namespaces that are served together
+// would export the same series, because they have the same metrics prefix,
+// namespace label and static labels, or would export metrics of the same name
+// with different help texts. Since the metrics of each namespace are
+// registered separately, this would otherwise only fail on scrape.
+func (c *Config) CheckNamespaceConflicts() error {
+	seen := make(map[string]string, len(c.Namespaces))
+	byPrefix := make(map[string]*NamespaceConfig, len(c.Namespaces))
+
+	for i := range c.Namespaces {
+		ns := &c.Namespaces[i]
+
+		// Namespaces that are only served by their own listener are not
+		// gathered together with the others
+		if ns.Listen != nil && ns.Listen.ExcludeFromGlobal {
+			continue
+		}
+
+		identity := ns.metricsIdentity()
+		if other, ok := seen[identity]; ok {
+			return fmt.Errorf("namespaces '%s' and '%s' export conflicting metrics; use different metrics_override prefixes, a namespace_label or different labels", other, ns.Name)
+		}
+
+		seen[identity] = ns.Name
+
+		// Metric families of the same name need the same help text, which
+		// is otherwise only found out on scrape as well
+		prefix := ns.metricsPrefix()
+		if other, ok := byPrefix[prefix]; ok {
+			if metric, differs := differingHelpOverride(other, ns); differs {
+				return fmt.Errorf("namespaces '%s' and '%s' share the metrics prefix '%s', but override the help text of '%s' differently", other.Name, ns.Name, prefix, metric)
+			}
+		} else {
+			byPrefix[prefix] = ns
+		}
+	}
+
+	return nil
+}
+
+// differingHelpOverride returns a built-in metric whose help text differs
+// between two namespaces because of their help_overrides, if any
+func differingHelpOverride(a *NamespaceConfig, b *NamespaceConfig) (string, bool) {
+	metrics := make([]string, 0, len(a.HelpOverrides)+len(b.HelpOverrides))
+	for metric := range a.HelpOverrides {
+		metrics = append(metrics, metric)
+	}
+	for metric := range b.HelpOverrides {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	for _, metric := range metrics {
+		overrideA, okA := a.HelpOverrides[metric]
+		overrideB, okB := b.HelpOverrides[metric]
+
+		if okA != okB || overrideA != overrideB {
+			return metric, true
+		}
+	}
+
+	return "", false
+}
+
 // MetricsEndpointOrDefault returns the configured metrics endpoint or the
 // default value if no configuration was provided.
 func (l *ListenConfig) MetricsEndpointOrDefault() string {
@@ -80,3 +292,127 @@ func (l *ListenConfig) MetricsEndpointOrDefault() string {
 
 	return l.MetricsEndpoint
 }
+
+// ScrapeTimeoutOrDefault returns the configured time after which a single
+// namespace's metrics are skipped when scraping with isolated namespaces, or a
+// default value if no configuration was provided.
+func (l *ListenConfig) ScrapeTimeoutOrDefault() (time.Duration, error) {
+	if l.ScrapeTimeout == "" {
+		return 10 * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(l.ScrapeTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid scrape_timeout '%s': %s", l.ScrapeTimeout, err.Error())
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("scrape_timeout must be positive")
+	}
+
+	return d, nil
+}
+
+// ReadTimeoutOrDefault returns the configured read timeout of the HTTP
+// servers, or a default value if no configuration was provided.
+func (l *ListenConfig) ReadTimeoutOrDefault() (time.Duration, error) {
+	return serverTimeoutOrDefault("read_timeout", l.ReadTimeout, DefaultReadTimeout)
+}
+
+// WriteTimeoutOrDefault returns the configured write timeout of the HTTP
+// servers, or a default value if no configuration was provided.
+func (l *ListenConfig) WriteTimeoutOrDefault() (time.Duration, error) {
+	return serverTimeoutOrDefault("write_timeout", l.WriteTimeout, DefaultWriteTimeout)
+}
+
+// IdleTimeoutOrDefault returns the configured idle timeout of the HTTP
+// servers, or a default value if no configuration was provided.
+func (l *ListenConfig) IdleTimeoutOrDefault() (time.Duration, error) {
+	return serverTimeoutOrDefault("idle_timeout", l.IdleTimeout, DefaultIdleTimeout)
+}
+
+func serverTimeoutOrDefault(name string, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s': %s", name, value, err.Error())
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("%s must not be negative", name)
+	}
+
+	return d, nil
+}
+
+// IntervalOrDefault returns the configured interval at which metrics are pushed
+// to the OpenTelemetry collector, or a default value if no configuration was
+// provided.
+func (o *OTLPConfig) IntervalOrDefault() (time.Duration, error) {
+	if o.Interval == "" {
+		return time.Minute, nil
+	}
+
+	d, err := time.ParseDuration(o.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid otlp interval '%s': %s", o.Interval, err.Error())
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("otlp interval must be positive")
+	}
+
+	return d, nil
+}
+
+// IntervalOrDefault returns the configured interval at which metrics are sent
+// to the remote write endpoint, or a default value if no configuration was
+// provided.
+func (r *RemoteWriteConfig) IntervalOrDefault() (time.Duration, error) {
+	if r.Interval == "" {
+		return time.Minute, nil
+	}
+
+	d, err := time.ParseDuration(r.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid remote_write interval '%s': %s", r.Interval, err.Error())
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("remote_write interval must be positive")
+	}
+
+	return d, nil
+}
+
+// IntervalOrDefault returns the configured interval at which metrics are pushed
+// to the Pushgateway, or a default value if no configuration was provided.
+func (p *PushgatewayConfig) IntervalOrDefault() (time.Duration, error) {
+	if p.Interval == "" {
+		return time.Minute, nil
+	}
+
+	d, err := time.ParseDuration(p.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pushgateway interval '%s': %s", p.Interval, err.Error())
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("pushgateway interval must be positive")
+	}
+
+	return d, nil
+}
+
+// JobOrDefault returns the configured job name, or a default value if none is
+// configured
+func (p *PushgatewayConfig) JobOrDefault() string {
+	if p.Job != "" {
+		return p.Job
+	}
+
+	return DefaultPushgatewayJob
+}
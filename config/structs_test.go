@@ -0,0 +1,130 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeTimeoutDefaultsToTenSeconds(t *testing.T) {
+	l := ListenConfig{}
+
+	d, err := l.ScrapeTimeoutOrDefault()
+	require.Nil(t, err)
+	assert.Equal(t, 10*time.Second, d)
+}
+
+func TestScrapeTimeoutIsParsed(t *testing.T) {
+	l := ListenConfig{ScrapeTimeout: "2s"}
+
+	d, err := l.ScrapeTimeoutOrDefault()
+	require.Nil(t, err)
+	assert.Equal(t, 2*time.Second, d)
+}
+
+func TestInvalidScrapeTimeoutIsRejected(t *testing.T) {
+	for _, v := range []string{"soon", "0s", "-1s"} {
+		l := ListenConfig{ScrapeTimeout: v}
+
+		_, err := l.ScrapeTimeoutOrDefault()
+		assert.NotNil(t, err, v)
+	}
+}
+
+func TestServerTimeoutsHaveDefaults(t *testing.T) {
+	l := ListenConfig{}
+
+	read, err := l.ReadTimeoutOrDefault()
+	require.Nil(t, err)
+	assert.Equal(t, DefaultReadTimeout, read)
+
+	write, err := l.WriteTimeoutOrDefault()
+	require.Nil(t, err)
+	assert.Equal(t, DefaultWriteTimeout, write)
+
+	idle, err := l.IdleTimeoutOrDefault()
+	require.Nil(t, err)
+	assert.Equal(t, DefaultIdleTimeout, idle)
+}
+
+func TestServerTimeoutsAreParsed(t *testing.T) {
+	l := ListenConfig{ReadTimeout: "5s", WriteTimeout: "0s", IdleTimeout: "-1s"}
+
+	read, err := l.ReadTimeoutOrDefault()
+	require.Nil(t, err)
+	assert.Equal(t, 5*time.Second, read)
+
+	// zero disables the timeout
+	write, err := l.WriteTimeoutOrDefault()
+	require.Nil(t, err)
+	assert.Equal(t, time.Duration(0), write)
+
+	_, err = l.IdleTimeoutOrDefault()
+	assert.NotNil(t, err)
+}
+
+func TestNamespacesWithSamePrefixAndLabelsConflict(t *testing.T) {
+	override := &struct {
+		Prefix string `hcl:"prefix" yaml:"prefix"`
+	}{Prefix: "nginx"}
+
+	c := Config{Namespaces: []NamespaceConfig{
+		{Name: "app1", MetricsOverride: override},
+		{Name: "app2", MetricsOverride: override},
+	}}
+	assert.NotNil(t, c.CheckNamespaceConflicts())
+
+	// A namespace label distinguishes the namespaces' series
+	for i := range c.Namespaces {
+		c.Namespaces[i].NamespaceLabelName = "vhost"
+	}
+	assert.Nil(t, c.CheckNamespaceConflicts())
+
+	// So do different static labels
+	c.Namespaces[0].NamespaceLabelName = ""
+	c.Namespaces[1].NamespaceLabelName = ""
+	c.Namespaces[0].Labels = map[string]string{"app": "app1"}
+	c.Namespaces[1].Labels = map[string]string{"app": "app2"}
+	assert.Nil(t, c.CheckNamespaceConflicts())
+
+	// Namespaces served only by their own listener do not conflict
+	c.Namespaces[1].Labels = map[string]string{"app": "app1"}
+	assert.NotNil(t, c.CheckNamespaceConflicts())
+
+	c.Namespaces[1].Listen = &NamespaceListenConfig{Port: 4041, ExcludeFromGlobal: true}
+	assert.Nil(t, c.CheckNamespaceConflicts())
+}
+
+func TestNamespacesPrefixedWithTheirNameDoNotConflict(t *testing.T) {
+	override := &struct {
+		Prefix string `hcl:"prefix" yaml:"prefix"`
+	}{Prefix: "nginx"}
+
+	c := Config{Namespaces: []NamespaceConfig{
+		{Name: "app1", MetricsOverride: override, PrefixWithNamespace: true},
+		{Name: "app2", MetricsOverride: override, PrefixWithNamespace: true},
+	}}
+	assert.Nil(t, c.CheckNamespaceConflicts())
+}
+
+func TestNamespacesWithSamePrefixNeedSameHelpOverrides(t *testing.T) {
+	override := &struct {
+		Prefix string `hcl:"prefix" yaml:"prefix"`
+	}{Prefix: "nginx"}
+
+	c := Config{Namespaces: []NamespaceConfig{
+		{Name: "app1", MetricsOverride: override, NamespaceLabelName: "vhost"},
+		{Name: "app2", MetricsOverride: override, NamespaceLabelName: "vhost", HelpOverrides: map[string]string{"parse_errors_total": "Broken lines"}},
+	}}
+	assert.NotNil(t, c.CheckNamespaceConflicts())
+
+	c.Namespaces[0].HelpOverrides = map[string]string{"parse_errors_total": "Broken lines"}
+	assert.Nil(t, c.CheckNamespaceConflicts())
+
+	// Namespaces with different prefixes may override help texts freely
+	c.Namespaces[0].MetricsOverride = nil
+	c.Namespaces[0].HelpOverrides = nil
+	assert.Nil(t, c.CheckNamespaceConflicts())
+}
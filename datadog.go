@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/v5/statsd"
+)
+
+// datadogProbeInterval is the interval in which the DogStatsD agents are
+// probed for the circuit breakers
+const datadogProbeInterval = time.Second
+
+// datadogProbeTimeout bounds connecting to and writing to an agent when
+// probing it
+const datadogProbeTimeout = time.Second
+
+// datadogProbeReplyTimeout is the time for which a probe waits for the agent's
+// host to reject it
+const datadogProbeReplyTimeout = 100 * time.Millisecond
+
+// datadogEndpoint is a single DogStatsD agent that metrics are sent to
+type datadogEndpoint struct {
+	url     string
+	client  *statsd.Client
+	breaker *circuitBreaker
+	probe   *datadogProbe
+}
+
+// datadogClients sends metrics to one or more DogStatsD agents. Each agent has
+// its own circuit breaker, so that an unavailable agent does not prevent
+// sending to the others.
+type datadogClients []*datadogEndpoint
+
+func newDatadogClients(urls []string, originDetection bool, breakerThreshold int, breakerCooldown time.Duration) (datadogClients, error) {
+	clients := make(datadogClients, 0, len(urls))
+
+	for _, url := range urls {
+		client, err := statsd.New(url, datadogClientOptions(originDetection)...)
+		if err != nil {
+			return nil, fmt.Errorf("could not create Datadog client for %s: %s", url, err.Error())
+		}
+
+		clients = append(clients, &datadogEndpoint{
+			url:     url,
+			client:  client,
+			breaker: newCircuitBreaker("Datadog agent "+url, breakerThreshold, breakerCooldown, datadogSendFailuresTotal),
+			probe:   newDatadogProbe(url),
+		})
+	}
+
+	return clients, nil
+}
+
+// datadogClientOptions returns the options of the DogStatsD clients. With
+// origin detection, the client sends the ID of its container with each
+// metric, unless DD_ENTITY_ID is set, whose value the client always sends as
+// tag instead. The client's own aggregation is disabled, since counts are
+// aggregated by the datadogAggregator if configured.
+func datadogClientOptions(originDetection bool) []statsd.Option {
+	options := []statsd.Option{statsd.WithoutClientSideAggregation()}
+
+	if originDetection {
+		return append(options, statsd.WithOriginDetection())
+	}
+
+	return append(options, statsd.WithoutOriginDetection())
+}
+
+// datadogProbe tests if a DogStatsD agent can be reached. The DogStatsD
+// client sends asynchronously and never reports network errors, so the probe
+// writes empty datagrams over a connection of its own instead. An agent that
+// does not listen is detected by the error (like an ICMP port unreachable)
+// that is reported when reading from the connected socket afterwards.
+type datadogProbe struct {
+	network string
+	addr    string
+	conn    net.Conn
+}
+
+func newDatadogProbe(url string) *datadogProbe {
+	if strings.HasPrefix(url, statsd.UnixAddressPrefix) {
+		return &datadogProbe{network: "unixgram", addr: url[len(statsd.UnixAddressPrefix):]}
+	}
+
+	return &datadogProbe{network: "udp", addr: url}
+}
+
+// check writes an empty datagram to the agent, and waits for an error in
+// reply. The connection is re-established after an error, so that a changed
+// address of the agent is picked up.
+func (p *datadogProbe) check() error {
+	if p.conn == nil {
+		conn, err := net.DialTimeout(p.network, p.addr, datadogProbeTimeout)
+		if err != nil {
+			return err
+		}
+
+		p.conn = conn
+	}
+
+	err := p.write()
+	if err != nil {
+		_ = p.conn.Close()
+		p.conn = nil
+	}
+
+	return err
+}
+
+func (p *datadogProbe) write() error {
+	if err := p.conn.SetWriteDeadline(time.Now().Add(datadogProbeTimeout)); err != nil {
+		return err
+	}
+
+	if _, err := p.conn.Write(nil); err != nil {
+		return err
+	}
+
+	// The agent never replies, so the read either times out or fails
+	// because the datagram was rejected
+	if err := p.conn.SetReadDeadline(time.Now().Add(datadogProbeReplyTimeout)); err != nil {
+		return err
+	}
+
+	if _, err := p.conn.Read(make([]byte, 1)); err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// probe checks each agent once, and records the result with its breaker
+func (c datadogClients) probe() {
+	for _, e := range c {
+		e.breaker.record(e.probe.check())
+	}
+}
+
+// runProbes probes the agents in the given interval
+func (c datadogClients) runProbes(interval time.Duration) {
+	for range time.Tick(interval) {
+		c.probe()
+	}
+}
+
+// send calls fn for the client of each agent whose breaker is closed. The
+// breakers are opened by the probes, since fn does not fail on network errors.
+func (c datadogClients) send(fn func(client *statsd.Client) error) {
+	for _, e := range c {
+		if !e.breaker.allow() {
+			continue
+		}
+
+		if err := fn(e.client); err != nil {
+			datadogSendFailuresTotal.Inc()
+		}
+	}
+}
+
+// datadogCount is the sum of the counts of one metric and tag combination
+// within a flush interval
+type datadogCount struct {
+	name  string
+	tags  []string
+	value int64
+}
+
+// datadogSummary is the sum, count and maximum of the values of one histogram
+// and tag combination within a flush interval
+type datadogSummary struct {
+	name  string
+	tags  []string
+	sum   float64
+	count int64
+	max   float64
+}
+
+// datadogAggregator sums up the counts sent to Datadog in memory, and sends
+// one count per metric and tag combination once per flush interval, instead
+// of one per log line. Histogram values are summarized as their sum, count
+// and maximum, since the DogStatsD client cannot send pre-sampled values.
+type datadogAggregator struct {
+	clients datadogClients
+
+	lock       sync.Mutex
+	counts     map[string]*datadogCount
+	histograms map[string]*datadogSummary
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newDatadogAggregator(clients datadogClients, interval time.Duration) *datadogAggregator {
+	a := &datadogAggregator{
+		clients:    clients,
+		counts:     make(map[string]*datadogCount),
+		histograms: make(map[string]*datadogSummary),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	go a.run(interval)
+	return a
+}
+
+func (a *datadogAggregator) run(interval time.Duration) {
+	defer close(a.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.flush()
+		case <-a.stop:
+			a.flush()
+			return
+		}
+	}
+}
+
+func datadogKey(name string, tags []string) string {
+	return name + "\xff" + strings.Join(tags, "\xff")
+}
+
+func (a *datadogAggregator) count(name string, value int64, tags []string) {
+	key := datadogKey(name, tags)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if c, ok := a.counts[key]; ok {
+		c.value += value
+		return
+	}
+
+	a.counts[key] = &datadogCount{name: name, tags: tags, value: value}
+}
+
+// histogram adds a value to the summary of its histogram and tag combination
+func (a *datadogAggregator) histogram(name string, value float64, tags []string) {
+	key := datadogKey(name, tags)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	h, ok := a.histograms[key]
+	if !ok {
+		a.histograms[key] = &datadogSummary{name: name, tags: tags, sum: value, count: 1, max: value}
+		return
+	}
+
+	h.sum += value
+	h.count++
+	if value > h.max {
+		h.max = value
+	}
+}
+
+// flush sends all counts and histogram summaries of the current interval, and
+// starts a new interval. A summary is sent as a "<name>.count" count, and as
+// "<name>.sum" and "<name>.max" gauges of the interval (the sum is a float,
+// which counts cannot carry).
+func (a *datadogAggregator) flush() {
+	a.lock.Lock()
+	counts := a.counts
+	histograms := a.histograms
+	a.counts = make(map[string]*datadogCount, len(counts))
+	a.histograms = make(map[string]*datadogSummary, len(histograms))
+	a.lock.Unlock()
+
+	for _, c := range counts {
+		c := c
+		a.clients.send(func(client *statsd.Client) error {
+			return client.Count(c.name, c.value, c.tags, 1)
+		})
+	}
+
+	for _, h := range histograms {
+		h := h
+		a.clients.send(func(client *statsd.Client) error {
+			if err := client.Gauge(h.name+".sum", h.sum, h.tags, 1); err != nil {
+				return err
+			}
+
+			if err := client.Count(h.name+".count", h.count, h.tags, 1); err != nil {
+				return err
+			}
+
+			return client.Gauge(h.name+".max", h.max, h.tags, 1)
+		})
+	}
+}
+
+// close stops the aggregator after sending the remaining counts and summaries
+func (a *datadogAggregator) close() {
+	close(a.stop)
+	<-a.done
+}
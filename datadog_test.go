@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/v5/statsd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatadogAggregatorSumsCounts(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	clients, err := newDatadogClients([]string{conn.LocalAddr().String()}, false, 10, time.Second)
+	require.Nil(t, err)
+
+	a := newDatadogAggregator(clients, time.Hour)
+	a.count("app.nginx.response.count_total", 1, []string{"status:200"})
+	a.count("app.nginx.response.count_total", 1, []string{"status:200"})
+	a.count("app.nginx.response.count_total", 1, []string{"status:500"})
+	a.close()
+
+	var received []string
+	buf := make([]byte, 1024)
+
+	for len(received) < 2 {
+		require.Nil(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+
+		n, _, err := conn.ReadFrom(buf)
+		require.Nil(t, err)
+
+		received = append(received, strings.Split(strings.TrimSpace(string(buf[:n])), "\n")...)
+	}
+
+	assert.ElementsMatch(t, []string{
+		"app.nginx.response.count_total:2|c|#status:200",
+		"app.nginx.response.count_total:1|c|#status:500",
+	}, received)
+}
+
+func TestDatadogAggregatorSummarizesHistograms(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	clients, err := newDatadogClients([]string{conn.LocalAddr().String()}, false, 10, time.Second)
+	require.Nil(t, err)
+
+	a := newDatadogAggregator(clients, time.Hour)
+	a.histogram("app.nginx.response.time_seconds", 0.5, []string{"status:200"})
+	a.histogram("app.nginx.response.time_seconds", 1.5, []string{"status:200"})
+	a.histogram("app.nginx.response.time_seconds", 0.25, []string{"status:500"})
+	a.close()
+
+	var received []string
+	buf := make([]byte, 1024)
+
+	for len(received) < 6 {
+		require.Nil(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+
+		n, _, err := conn.ReadFrom(buf)
+		require.Nil(t, err)
+
+		received = append(received, strings.Split(strings.TrimSpace(string(buf[:n])), "\n")...)
+	}
+
+	assert.ElementsMatch(t, []string{
+		"app.nginx.response.time_seconds.sum:2|g|#status:200",
+		"app.nginx.response.time_seconds.count:2|c|#status:200",
+		"app.nginx.response.time_seconds.max:1.5|g|#status:200",
+		"app.nginx.response.time_seconds.sum:0.25|g|#status:500",
+		"app.nginx.response.time_seconds.count:1|c|#status:500",
+		"app.nginx.response.time_seconds.max:0.25|g|#status:500",
+	}, received)
+}
+
+func TestDatadogEntityIDIsSentRegardlessOfOriginDetection(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	require.Nil(t, os.Setenv("DD_ENTITY_ID", "pod-uid"))
+	defer os.Unsetenv("DD_ENTITY_ID")
+
+	for _, originDetection := range []bool{false, true} {
+		clients, err := newDatadogClients([]string{conn.LocalAddr().String()}, originDetection, 10, time.Second)
+		require.Nil(t, err)
+
+		clients.send(func(client *statsd.Client) error {
+			return client.Count("app.nginx.response.count_total", 1, []string{"status:200"}, 1)
+		})
+		require.Nil(t, clients[0].client.Close())
+
+		buf := make([]byte, 1024)
+		require.Nil(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+
+		n, _, err := conn.ReadFrom(buf)
+		require.Nil(t, err)
+
+		assert.Contains(t, string(buf[:n]), "dd.internal.entity_id:pod-uid")
+	}
+}
+
+func TestDatadogClientOptionsSetOriginDetection(t *testing.T) {
+	for _, originDetection := range []bool{false, true} {
+		var options statsd.Options
+		for _, o := range datadogClientOptions(originDetection) {
+			require.Nil(t, o(&options))
+		}
+
+		// The options are not exported by the client
+		assert.Equal(t, originDetection, reflect.ValueOf(options).FieldByName("originDetection").Bool())
+		assert.False(t, reflect.ValueOf(options).FieldByName("aggregation").Bool())
+	}
+}
+
+func TestDatadogBreakerOpensForUnreachableAgent(t *testing.T) {
+	// Take a free port, and close it again so that nothing listens on it
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := conn.LocalAddr().String()
+	require.Nil(t, conn.Close())
+
+	clients, err := newDatadogClients([]string{addr}, false, 2, time.Hour)
+	require.Nil(t, err)
+
+	clients.probe()
+	assert.True(t, clients[0].breaker.allow())
+
+	clients.probe()
+	assert.False(t, clients[0].breaker.allow())
+}
+
+func TestDatadogBreakerStaysClosedForReachableAgent(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	clients, err := newDatadogClients([]string{conn.LocalAddr().String()}, false, 2, time.Hour)
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		clients.probe()
+	}
+
+	assert.True(t, clients[0].breaker.allow())
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// dedupCache remembers keys for a limited time (and up to a maximum number of
+// keys) to detect duplicate messages
+type dedupCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	lock sync.Mutex
+	// order contains the keys' entries, oldest first
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type dedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+func newDedupCache(ttl time.Duration, maxEntries int) *dedupCache {
+	return &dedupCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// seen tests if the key has already been seen within the TTL, and remembers it
+// otherwise. When the cache is full, the oldest key is forgotten.
+func (c *dedupCache) seen(key string) bool {
+	now := time.Now()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expire(now)
+
+	if _, ok := c.entries[key]; ok {
+		return true
+	}
+
+	if c.order.Len() >= c.maxEntries {
+		c.remove(c.order.Front())
+	}
+
+	c.entries[key] = c.order.PushBack(&dedupEntry{key: key, expires: now.Add(c.ttl)})
+	return false
+}
+
+// expire removes all expired keys; since all keys have the same TTL, these are
+// always at the front of the list
+func (c *dedupCache) expire(now time.Time) {
+	for e := c.order.Front(); e != nil && !now.Before(e.Value.(*dedupEntry).expires); e = c.order.Front() {
+		c.remove(e)
+	}
+}
+
+func (c *dedupCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*dedupEntry).key)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/logparser"
+)
+
+// detectFormats sets the format of all namespaces with auto_detect_format
+// enabled to the built-in format that matches the first lines of their log
+// files best
+func detectFormats(cfg *config.Config) error {
+	for i := range cfg.Namespaces {
+		ns := &cfg.Namespaces[i]
+		if !ns.AutoDetectFormat {
+			continue
+		}
+
+		if ns.Format != "" || ns.FormatName != "" || ns.NginxConfig != "" {
+			return fmt.Errorf("namespace '%s' must not set 'format', 'format_name' or 'nginx_config' together with 'auto_detect_format'", ns.Name)
+		}
+
+		lines, err := sampleLines(ns.SourceData.Files, ns.AutoDetectSampleLinesOrDefault())
+		if err != nil {
+			return fmt.Errorf("namespace '%s': %s", ns.Name, err.Error())
+		}
+
+		name, err := logparser.DetectFormat(lines, ns.Escape, config.AutoDetectMinMatchRatio)
+		if err != nil {
+			return fmt.Errorf("namespace '%s': %s", ns.Name, err.Error())
+		}
+
+		fmt.Printf("detected log format %s for namespace %s\n", name, ns.Name)
+		ns.FormatName = name
+	}
+
+	return nil
+}
+
+// sampleLines reads up to n non-empty lines from the beginning of the log
+// files, in the order of the files. Files that do not exist (yet) are skipped.
+func sampleLines(files []string, n int) ([]string, error) {
+	lines := make([]string, 0, n)
+
+	for _, f := range files {
+		file, err := os.Open(f)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		for len(lines) < n && scanner.Scan() {
+			if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+				lines = append(lines, line)
+			}
+		}
+
+		err = scanner.Err()
+		file.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("could not read sample lines from %s: %s", f, err.Error())
+		}
+
+		if len(lines) >= n {
+			break
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no sample lines to detect the log format from; the log files are missing or empty")
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/satyrius/gonx"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+// hllPrecision is the number of hash bits that select a HyperLogLog register;
+// 2^14 registers need 16KB of memory and give a standard error of about 0.8%
+const hllPrecision = 14
+
+// hyperLogLog estimates the number of distinct values added to it with a fixed
+// amount of memory
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+func (h *hyperLogLog) add(value string) {
+	x := hashValue(value)
+
+	index := x >> (64 - hllPrecision)
+	// the set bit bounds the rank if all remaining bits are zero
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() float64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	// Linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		return m * math.Log(m/float64(zeros))
+	}
+
+	return estimate
+}
+
+func (h *hyperLogLog) reset() {
+	for i := range h.registers {
+		h.registers[i] = 0
+	}
+}
+
+// hashValue hashes a value with FNV-1a, followed by MurmurHash3's finalizer
+// to spread FNV's weak high bits over the whole hash
+func hashValue(value string) uint64 {
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(value))
+	x := f.Sum64()
+
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
+
+// distinctValues estimates the number of distinct values of a namespace's
+// distinct_fields, and exports the estimates as gauge. If a window is set, the
+// estimates are reset at the start of each window.
+type distinctValues struct {
+	desc   *prometheus.Desc
+	fields []string
+	window time.Duration
+
+	lock       sync.Mutex
+	estimators []*hyperLogLog
+	resetAt    time.Time
+}
+
+func newDistinctValues(cfg *config.NamespaceConfig) *distinctValues {
+	d := &distinctValues{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(cfg.NamespacePrefix, "", "distinct_values_estimate"),
+			cfg.HelpOrDefault("distinct_values_estimate", "Estimated number of distinct values of a log field"),
+			[]string{"field"},
+			cfg.NamespaceLabels,
+		),
+		fields:     cfg.DistinctFields,
+		window:     cfg.DistinctWindowDuration,
+		estimators: make([]*hyperLogLog, len(cfg.DistinctFields)),
+	}
+
+	for i := range d.estimators {
+		d.estimators[i] = newHyperLogLog()
+	}
+
+	d.resetAt = d.nextReset(time.Now())
+	return d
+}
+
+func (d *distinctValues) nextReset(now time.Time) time.Time {
+	if d.window <= 0 {
+		return time.Time{}
+	}
+
+	return now.Add(d.window)
+}
+
+// expire resets all estimates when the current window is over; the lock must
+// be held
+func (d *distinctValues) expire(now time.Time) {
+	if d.resetAt.IsZero() || now.Before(d.resetAt) {
+		return
+	}
+
+	for _, e := range d.estimators {
+		e.reset()
+	}
+
+	d.resetAt = d.nextReset(now)
+}
+
+func (d *distinctValues) observe(fields gonx.Fields) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.expire(time.Now())
+
+	for i, name := range d.fields {
+		if value, ok := fields[name]; ok && value != "" && value != "-" {
+			d.estimators[i].add(value)
+		}
+	}
+}
+
+// Describe implements the prometheus.Collector interface
+func (d *distinctValues) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.desc
+}
+
+// Collect implements the prometheus.Collector interface
+func (d *distinctValues) Collect(ch chan<- prometheus.Metric) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.expire(time.Now())
+
+	for i, name := range d.fields {
+		ch <- prometheus.MustNewConstMetric(d.desc, prometheus.GaugeValue, math.Round(d.estimators[i].estimate()), name)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// scrapeErrorsTotal counts errors returned by namespace gatherers on scrape
+var scrapeErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nginxlog_exporter_scrape_errors_total",
+	Help: "Total number of errors while gathering a namespace's metrics on scrape",
+}, []string{"namespace"})
+
+// namespaceGatherer wraps a namespace's gatherer, counting and logging its
+// errors
+type namespaceGatherer struct {
+	namespace string
+	gatherer  prometheus.Gatherer
+}
+
+func (g *namespaceGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	if err != nil {
+		scrapeErrorsTotal.WithLabelValues(g.namespace).Inc()
+		fmt.Printf("error while gathering metrics of namespace %s: %s\n", g.namespace, err.Error())
+	}
+
+	return families, err
+}
+
+// isolatedGatherers gathers each of its gatherers concurrently and merges the
+// results. In contrast to prometheus.Gatherers, a gatherer that fails, panics
+// or does not respond within the timeout is skipped (and reported as error)
+// without affecting the results of the others.
+type isolatedGatherers struct {
+	gatherers []prometheus.Gatherer
+	timeout   time.Duration
+}
+
+type gatherResult struct {
+	index    int
+	families []*dto.MetricFamily
+	err      error
+}
+
+func (g *isolatedGatherers) Gather() ([]*dto.MetricFamily, error) {
+	// Buffered, so that gatherers that time out do not block forever
+	results := make(chan gatherResult, len(g.gatherers))
+
+	for i := range g.gatherers {
+		go func(i int) {
+			defer func() {
+				if r := recover(); r != nil {
+					results <- gatherResult{index: i, err: fmt.Errorf("gatherer %d panicked: %v", i, r)}
+				}
+			}()
+
+			families, err := g.gatherers[i].Gather()
+			results <- gatherResult{index: i, families: families, err: err}
+		}(i)
+	}
+
+	timeout := time.NewTimer(g.timeout)
+	defer timeout.Stop()
+
+	collected := make([][]*dto.MetricFamily, len(g.gatherers))
+	pending := len(g.gatherers)
+	errs := prometheus.MultiError{}
+
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err != nil {
+				errs.Append(r.err)
+			}
+
+			collected[r.index] = r.families
+		case <-timeout.C:
+			errs.Append(fmt.Errorf("%d gatherer(s) did not respond within %s", pending, g.timeout))
+			pending = 0
+		}
+	}
+
+	// Merge (and check for consistency) in the gatherers' original order
+	merged := make(prometheus.Gatherers, 0, len(collected))
+	for i := range collected {
+		families := collected[i]
+		merged = append(merged, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return families, nil
+		}))
+	}
+
+	families, err := merged.Gather()
+	if err != nil {
+		errs.Append(err)
+	}
+
+	if len(errs) > 0 {
+		fmt.Printf("warning: serving partial metrics: %s\n", errs.Error())
+	}
+
+	return families, errs.MaybeUnwrap()
+}
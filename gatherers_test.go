@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingCollector is a collector whose metrics cannot be gathered
+type failingCollector struct {
+	desc *prometheus.Desc
+}
+
+func (c *failingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *failingCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.NewInvalidMetric(c.desc, errors.New("broken"))
+}
+
+func TestScrapeErrorsAreCountedPerNamespace(t *testing.T) {
+	broken := prometheus.NewRegistry()
+	broken.MustRegister(&failingCollector{desc: prometheus.NewDesc("broken_total", "Broken", nil, nil)})
+
+	healthy := prometheus.NewRegistry()
+	requests := prometheus.NewCounter(prometheus.CounterOpts{Name: "healthy_total", Help: "Healthy"})
+	requests.Inc()
+	healthy.MustRegister(requests)
+
+	before := testutil.ToFloat64(scrapeErrorsTotal.WithLabelValues("broken"))
+
+	g := &isolatedGatherers{
+		gatherers: []prometheus.Gatherer{
+			&namespaceGatherer{namespace: "broken", gatherer: broken},
+			&namespaceGatherer{namespace: "healthy", gatherer: healthy},
+		},
+		timeout: time.Second,
+	}
+
+	families, err := g.Gather()
+	require.NotNil(t, err)
+
+	var names []string
+	for _, f := range families {
+		names = append(names, f.GetName())
+	}
+
+	assert.Equal(t, []string{"healthy_total"}, names)
+	assert.Equal(t, before+1, testutil.ToFloat64(scrapeErrorsTotal.WithLabelValues("broken")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(scrapeErrorsTotal.WithLabelValues("healthy")))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+// parseErrorMinLines is the number of lines a log source needs to have within
+// the parse error window before its parse error ratio is judged, so that a
+// single broken line of an otherwise quiet source does not mark it as down
+const parseErrorMinLines = 10
+
+// sourceHealth holds the reasons why log sources are currently considered
+// unhealthy, which fail the /healthz endpoint
+type sourceHealth struct {
+	lock    sync.Mutex
+	reasons map[string]string
+}
+
+var unhealthySources = &sourceHealth{reasons: make(map[string]string)}
+
+func sourceHealthKey(namespace string, source string) string {
+	return namespace + "\xff" + source
+}
+
+func (h *sourceHealth) markUnhealthy(namespace string, source string, reason string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.reasons[sourceHealthKey(namespace, source)] = fmt.Sprintf("source %s in namespace %s: %s", source, namespace, reason)
+}
+
+func (h *sourceHealth) markHealthy(namespace string, source string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.reasons, sourceHealthKey(namespace, source))
+}
+
+// problems returns the reasons of all unhealthy sources, sorted
+func (h *sourceHealth) problems() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	problems := make([]string, 0, len(h.reasons))
+	for _, r := range h.reasons {
+		problems = append(problems, r)
+	}
+	sort.Strings(problems)
+
+	return problems
+}
+
+// parseErrorRatio tracks the share of lines of a log source that could not be
+// parsed within a sliding window, and reports whether it exceeds the
+// namespace's max_parse_error_ratio
+type parseErrorRatio struct {
+	maxRatio float64
+	lines    *secondRing
+	errors   *secondRing
+
+	// checkedAt is the Unix second of the last check; the ratio is checked
+	// at most once per second, since summing up the window is not free
+	checkedAt int64
+	exceeded  bool
+}
+
+func newParseErrorRatio(cfg *config.NamespaceConfig) *parseErrorRatio {
+	seconds := int(cfg.ParseErrorWindowDuration / time.Second)
+
+	return &parseErrorRatio{
+		maxRatio: cfg.MaxParseErrorRatio,
+		lines:    newSecondRing(seconds),
+		errors:   newSecondRing(seconds),
+	}
+}
+
+// observe counts a line, and returns whether the ratio is exceeded (as of the
+// last check) and whether this changed with this line
+func (r *parseErrorRatio) observe(failed bool, now time.Time) (bool, bool) {
+	r.lines.add(now)
+	if failed {
+		r.errors.add(now)
+	}
+
+	if now.Unix() == r.checkedAt {
+		return r.exceeded, false
+	}
+	r.checkedAt = now.Unix()
+
+	lines := r.lines.sum(now)
+	exceeded := lines >= parseErrorMinLines && float64(r.errors.sum(now))/float64(lines) > r.maxRatio
+
+	changed := exceeded != r.exceeded
+	r.exceeded = exceeded
+
+	return exceeded, changed
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+func TestParseErrorRatioIsExceeded(t *testing.T) {
+	r := newParseErrorRatio(&config.NamespaceConfig{
+		MaxParseErrorRatio:       0.5,
+		ParseErrorWindowDuration: 10 * time.Second,
+	})
+
+	now := time.Unix(1000, 0)
+
+	// Too few lines to judge
+	for i := 0; i < parseErrorMinLines-1; i++ {
+		exceeded, _ := r.observe(true, now)
+		assert.False(t, exceeded)
+		now = now.Add(time.Second)
+	}
+
+	exceeded, changed := r.observe(true, now)
+	assert.True(t, exceeded)
+	assert.True(t, changed)
+
+	// Within the same second, the ratio is not checked again
+	exceeded, changed = r.observe(false, now)
+	assert.True(t, exceeded)
+	assert.False(t, changed)
+
+	// Once the failed lines have left the window, the source is fine again
+	exceeded, changed = r.observe(false, now.Add(10*time.Second))
+	assert.False(t, exceeded)
+	assert.True(t, changed)
+}
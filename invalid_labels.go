@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/relabeling"
+)
+
+// invalidLabelValues exports the number of values that were replaced because
+// they did not match the label_value_pattern of their relabeling, by target
+// label
+type invalidLabelValues struct {
+	desc *prometheus.Desc
+
+	lock        sync.Mutex
+	relabelings []*relabeling.Relabeling
+
+	// carried are the counts of the relabelings that were replaced by a
+	// relabel reload, by target label, so that the counters do not reset
+	carried map[string]uint64
+}
+
+// newInvalidLabelValues returns a collector for the relabelings that have a
+// label_value_pattern. It exports nothing as long as none has.
+func newInvalidLabelValues(cfg *config.NamespaceConfig, relabelings []*relabeling.Relabeling) *invalidLabelValues {
+	return &invalidLabelValues{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(cfg.NamespacePrefix, "", "invalid_label_values_total"),
+			cfg.HelpOrDefault("invalid_label_values_total", "Total number of label values that were replaced because they did not match the label_value_pattern"),
+			[]string{"target_label"},
+			cfg.NamespaceLabels,
+		),
+		relabelings: validatedRelabelings(relabelings),
+		carried:     make(map[string]uint64),
+	}
+}
+
+func validatedRelabelings(relabelings []*relabeling.Relabeling) []*relabeling.Relabeling {
+	var validated []*relabeling.Relabeling
+	for _, r := range relabelings {
+		if r.LabelValueRegexp != nil {
+			validated = append(validated, r)
+		}
+	}
+
+	return validated
+}
+
+// replace switches to the relabelings of a relabel reload, keeping the counts
+// of the previous ones
+func (v *invalidLabelValues) replace(relabelings []*relabeling.Relabeling) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for _, r := range v.relabelings {
+		v.carried[r.TargetLabel] += r.InvalidValues()
+	}
+
+	v.relabelings = validatedRelabelings(relabelings)
+}
+
+// Describe implements the prometheus.Collector interface
+func (v *invalidLabelValues) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+// Collect implements the prometheus.Collector interface
+func (v *invalidLabelValues) Collect(ch chan<- prometheus.Metric) {
+	v.lock.Lock()
+	counts := make(map[string]uint64, len(v.carried)+len(v.relabelings))
+	for label, n := range v.carried {
+		counts[label] = n
+	}
+
+	for _, r := range v.relabelings {
+		counts[r.TargetLabel] += r.InvalidValues()
+	}
+	v.lock.Unlock()
+
+	for label, n := range counts {
+		ch <- prometheus.MustNewConstMetric(v.desc, prometheus.CounterValue, float64(n), label)
+	}
+}
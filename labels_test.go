@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/require"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+const labelOrderConfig = `
+namespace "test" {
+  format = "$remote_addr \"$request\" $status $http_user_agent"
+
+  labels {
+    zone = "a"
+    app = "shop"
+    env = "prod"
+    team = "web"
+  }
+
+  relabel "user_agent" {
+    from = "http_user_agent"
+  }
+
+  relabel "client" {
+    from = "remote_addr"
+  }
+}
+`
+
+// exposition processes a few lines with a freshly loaded configuration, and
+// returns the resulting metrics in the Prometheus text format
+func exposition(t *testing.T) (string, []string) {
+	var cfg config.Config
+	require.Nil(t, config.LoadConfigFromStream(&cfg, strings.NewReader(labelOrderConfig), config.TypeHCL))
+
+	nsMetrics := NewNSMetrics(&cfg.Namespaces[0], nil)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	require.True(t, p.process(`10.0.0.1 "GET / HTTP/1.1" 200 curl`))
+	require.True(t, p.process(`10.0.0.2 "POST /cart HTTP/1.1" 500 firefox`))
+
+	families, err := nsMetrics.Gather()
+	require.Nil(t, err)
+
+	var buf bytes.Buffer
+	for _, f := range families {
+		_, err := expfmt.MetricFamilyToText(&buf, f)
+		require.Nil(t, err)
+	}
+
+	return buf.String(), labelNames(nsMetrics.cfg, nsMetrics.relabelings)
+}
+
+func TestLabelOrderIsDeterministic(t *testing.T) {
+	expected, expectedNames := exposition(t)
+	require.Equal(t, []string{"app", "env", "team", "zone", "user_agent", "client", "method", "status"}, expectedNames)
+
+	// Label maps are iterated in random order, so a few runs are needed to
+	// catch order dependencies
+	for i := 0; i < 20; i++ {
+		actual, names := exposition(t)
+
+		require.Equal(t, expectedNames, names)
+		require.Equal(t, expected, actual)
+	}
+}
+
+const invalidLabelValuesConfig = `
+namespace "test" {
+  format = "$status $http_user_agent"
+
+  relabel "user_agent" {
+    from = "http_user_agent"
+    label_value_pattern = "[a-z]+"
+  }
+}
+`
+
+func TestInvalidLabelValuesAreCountedAcrossRelabelReloads(t *testing.T) {
+	var cfg config.Config
+	require.Nil(t, config.LoadConfigFromStream(&cfg, strings.NewReader(invalidLabelValuesConfig), config.TypeHCL))
+
+	nsMetrics := NewNSMetrics(&cfg.Namespaces[0], nil)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+	require.True(t, p.process(`200 Curl/7`))
+
+	reloaded, relabelings, err := nsMetrics.prepareRelabelings(nsMetrics.cfg.RelabelConfigs)
+	require.Nil(t, err)
+	nsMetrics.replaceRelabelings(reloaded, relabelings)
+
+	p = newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+	require.True(t, p.process(`200 Wget/1`))
+	require.True(t, p.process(`200 curl`))
+
+	families, err := nsMetrics.Gather()
+	require.Nil(t, err)
+
+	var count float64
+	for _, f := range families {
+		if f.GetName() == "test_invalid_label_values_total" {
+			require.Len(t, f.GetMetric(), 1)
+			count = f.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+
+	require.Equal(t, float64(2), count)
+}
+
+const labelConflictConfig = `
+namespace "test" {
+  format = "$status $http_user_agent"
+  label_conflict = "override"
+
+  labels {
+    app = "shop"
+  }
+
+  relabel "user_agent" {
+    from = "http_user_agent"
+  }
+}
+`
+
+func TestFailedRelabelReloadKeepsStaticLabels(t *testing.T) {
+	nsMetrics := loadNamespace(t, labelConflictConfig)
+
+	_, _, err := nsMetrics.prepareRelabelings([]config.RelabelConfig{
+		{TargetLabel: "user_agent", SourceValue: "http_user_agent"},
+		{TargetLabel: "app", SourceValue: "http_user_agent"},
+	})
+	require.NotNil(t, err)
+
+	require.Equal(t, map[string]string{"app": "shop"}, nsMetrics.cfg.Labels)
+	require.Equal(t, []string{"app"}, nsMetrics.cfg.OrderedLabelNames)
+}
+
+const staticAssetConfig = `
+namespace "test" {
+  format = "$status"
+
+  relabel "static" {
+    from = "request_uri"
+    action = "is_static_asset"
+  }
+}
+`
+
+func TestIsStaticAssetLabelsLinesWithoutPathAsFalse(t *testing.T) {
+	nsMetrics := loadNamespace(t, staticAssetConfig)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	require.True(t, p.process(`200`))
+
+	require.Equal(t, map[string]float64{
+		"false": 1,
+	}, metricValues(t, nsMetrics, "test_http_response_count_total", "static"))
+}
+
+const customCounterMaskConfig = `
+namespace "test" {
+  format = "$status $request_uri"
+  mask_query_params = ["token"]
+
+  custom_counter "requests_by_uri_total" {
+    from = "request_uri"
+    label = "uri"
+  }
+}
+`
+
+func TestCustomCounterValuesAreMasked(t *testing.T) {
+	nsMetrics := loadNamespace(t, customCounterMaskConfig)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	require.True(t, p.process(`200 /login?user=foo&token=secret`))
+	require.True(t, p.process(`200 /login?user=foo&token=other`))
+
+	require.Equal(t, map[string]float64{
+		"/login?user=foo&token=***": 2,
+	}, metricValues(t, nsMetrics, "test_requests_by_uri_total", "uri"))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+// listen creates the TCP listener for an HTTP server. With reusePort, the
+// SO_REUSEPORT option is set on the socket, so that a second exporter instance
+// can bind to the same port during restarts.
+func listen(address string, reusePort bool) (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if reusePort {
+		lc.Control = setReusePort
+	}
+
+	return lc.Listen(context.Background(), "tcp", address)
+}
+
+// newHTTPServer creates an HTTP server with the timeouts and keep-alive
+// setting of the listen configuration. Unlike the zero value of http.Server,
+// it has timeouts by default, so that slow or stalled clients cannot hold on
+// to connections forever.
+func newHTTPServer(cfg *config.ListenConfig, handler http.Handler) (*http.Server, error) {
+	read, err := cfg.ReadTimeoutOrDefault()
+	if err != nil {
+		return nil, err
+	}
+
+	write, err := cfg.WriteTimeoutOrDefault()
+	if err != nil {
+		return nil, err
+	}
+
+	idle, err := cfg.IdleTimeoutOrDefault()
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Handler:      handler,
+		ReadTimeout:  read,
+		WriteTimeout: write,
+		IdleTimeout:  idle,
+	}
+	server.SetKeepAlivesEnabled(!cfg.DisableKeepAlives)
+
+	return server, nil
+}
+
+// serveInBackground serves HTTP requests on the listener until stopChan is
+// closed. name describes the server in log messages (like "HTTP server").
+func serveInBackground(name string, server *http.Server, listener net.Listener, stopChan <-chan bool, stopHandlers *sync.WaitGroup) {
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("error in %s: %s\n", name, err.Error())
+		}
+	}()
+
+	shutdownOnStop(name, server, stopChan, stopHandlers)
+}
+
+// shutdownOnStop gracefully shuts down the server when stopChan is closed,
+// waiting at most 5 seconds for active requests to complete
+func shutdownOnStop(name string, server *http.Server, stopChan <-chan bool, stopHandlers *sync.WaitGroup) {
+	stopHandlers.Add(1)
+
+	go func() {
+		defer stopHandlers.Done()
+
+		<-stopChan
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			fmt.Printf("error while shutting down %s: %s\n", name, err.Error())
+		}
+	}()
+}
@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func reusePortOption(t *testing.T, l net.Listener) int {
+	conn, err := l.(*net.TCPListener).SyscallConn()
+	require.Nil(t, err)
+
+	var value int
+	var sockErr error
+
+	require.Nil(t, conn.Control(func(fd uintptr) {
+		value, sockErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT)
+	}))
+	require.Nil(t, sockErr)
+
+	return value
+}
+
+func TestListenSetsReusePort(t *testing.T) {
+	l, err := listen("127.0.0.1:0", true)
+	require.Nil(t, err)
+	defer l.Close()
+
+	assert.Equal(t, 1, reusePortOption(t, l))
+
+	// A second listener can bind to the same port
+	second, err := listen(l.Addr().String(), true)
+	require.Nil(t, err)
+	second.Close()
+}
+
+func TestListenWithoutReusePort(t *testing.T) {
+	l, err := listen("127.0.0.1:0", false)
+	require.Nil(t, err)
+	defer l.Close()
+
+	assert.Equal(t, 0, reusePortOption(t, l))
+}
@@ -0,0 +1,49 @@
+package logparser
+
+import (
+	"fmt"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+// DetectFormat tries each built-in log format on the sample lines and returns
+// the name of the format that parses the most of them. Ties are resolved in
+// favor of the format with fewer fields, which makes fewer assumptions about
+// the lines. An error is returned if no format parses at least minRatio of the
+// lines.
+func DetectFormat(lines []string, escape string, minRatio float64) (string, error) {
+	if len(lines) == 0 {
+		return "", fmt.Errorf("no sample lines to detect the log format from")
+	}
+
+	best := ""
+	bestMatches := 0
+	bestFields := 0
+
+	for _, name := range config.BuiltinFormatNames() {
+		format := config.BuiltinFormats[name]
+		fields := len(config.FormatFields(format))
+		p := NewParser(format, escape)
+
+		matches := 0
+		for _, line := range lines {
+			if _, err := p.ParseString(line); err == nil {
+				matches++
+			}
+		}
+
+		if matches == 0 || matches < bestMatches || (matches == bestMatches && fields >= bestFields) {
+			continue
+		}
+
+		best = name
+		bestMatches = matches
+		bestFields = fields
+	}
+
+	if best == "" || float64(bestMatches)/float64(len(lines)) < minRatio {
+		return "", fmt.Errorf("none of the built-in log formats matches at least %.0f%% of %d sample lines (best: %d)", minRatio*100, len(lines), bestMatches)
+	}
+
+	return best, nil
+}
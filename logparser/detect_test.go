@@ -0,0 +1,49 @@
+package logparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+func TestCombinedFormatIsDetected(t *testing.T) {
+	t.Parallel()
+
+	lines := []string{
+		`10.0.0.1 - - [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200 512 "-" "curl/7.68.0"`,
+		`10.0.0.2 - frank [10/Oct/2020:13:55:37 +0000] "POST /login HTTP/1.1" 302 0 "-" "Mozilla/5.0"`,
+		`garbage`,
+	}
+
+	// Both combined formats match; NGINX' format has fewer fields
+	name, err := DetectFormat(lines, config.EscapeNone, 0.5)
+	require.Nil(t, err)
+	assert.Equal(t, "nginx-combined", name)
+
+	_, err = DetectFormat(lines, config.EscapeNone, 0.9)
+	assert.NotNil(t, err)
+}
+
+func TestApacheFormatIsDetected(t *testing.T) {
+	t.Parallel()
+
+	lines := []string{
+		`10.0.0.1 ident frank [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200 512 "-" "curl/7.68.0"`,
+	}
+
+	name, err := DetectFormat(lines, config.EscapeNone, 1)
+	require.Nil(t, err)
+	assert.Equal(t, "apache-combined", name)
+}
+
+func TestDetectionFailsWithoutMatches(t *testing.T) {
+	t.Parallel()
+
+	_, err := DetectFormat([]string{"foo", "bar"}, config.EscapeNone, 0.5)
+	assert.NotNil(t, err)
+
+	_, err = DetectFormat(nil, config.EscapeNone, 0.5)
+	assert.NotNil(t, err)
+}
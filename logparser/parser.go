@@ -0,0 +1,127 @@
+// Package logparser parses access log lines that may contain escaped
+// characters, as written by NGINX' "escape" parameter of the log_format
+// directive.
+package logparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/satyrius/gonx"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+// Placeholders for escaped quotes and backslashes while the line is parsed.
+// NGINX escapes all control characters in both escaping modes, so these never
+// occur in log lines themselves.
+const (
+	quotePlaceholder     = "\x00"
+	backslashPlaceholder = "\x01"
+)
+
+// Parser parses log lines according to a log format. Unlike a plain gonx
+// parser, escaped quotes within quoted fields do not end the field, and the
+// escape sequences in field values are decoded.
+type Parser struct {
+	parser *gonx.Parser
+	escape string
+}
+
+// NewParser creates a parser for a log format, with escape being one of the
+// config.Escape* constants
+func NewParser(format string, escape string) *Parser {
+	return &Parser{
+		parser: gonx.NewParser(format),
+		escape: escape,
+	}
+}
+
+// ParseString parses a single log line
+func (p *Parser) ParseString(line string) (*gonx.Entry, error) {
+	if p.escape == "" || p.escape == config.EscapeNone {
+		return p.parser.ParseString(line)
+	}
+
+	// Escaped backslashes are replaced first, so that a field value ending
+	// in a backslash (`\\"`) still ends at the quote
+	protected := strings.ReplaceAll(line, `\\`, backslashPlaceholder)
+	protected = strings.ReplaceAll(protected, `\"`, quotePlaceholder)
+
+	entry, err := p.parser.ParseString(protected)
+	if err != nil {
+		return nil, fmt.Errorf("access log line '%s' does not match the log format", line)
+	}
+
+	fields := entry.Fields()
+	for name, value := range fields {
+		fields[name] = p.unescape(value)
+	}
+
+	return entry, nil
+}
+
+func (p *Parser) unescape(value string) string {
+	if !strings.Contains(value, `\`) {
+		return restorePlaceholders(value)
+	}
+
+	var b strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 >= len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		if decoded, n, ok := p.decodeSequence(value[i:]); ok {
+			b.WriteString(decoded)
+			i += n - 1
+			continue
+		}
+
+		b.WriteByte(value[i])
+	}
+
+	return restorePlaceholders(b.String())
+}
+
+// decodeSequence decodes the escape sequence at the beginning of s, returning
+// the decoded string and the length of the sequence
+func (p *Parser) decodeSequence(s string) (string, int, bool) {
+	switch p.escape {
+	case config.EscapeDefault:
+		// NGINX escapes as "\xHH"
+		if len(s) >= 4 && s[1] == 'x' {
+			if c, err := strconv.ParseUint(s[2:4], 16, 8); err == nil {
+				return string([]byte{byte(c)}), 4, true
+			}
+		}
+	case config.EscapeJSON:
+		switch s[1] {
+		case 'n':
+			return "\n", 2, true
+		case 'r':
+			return "\r", 2, true
+		case 't':
+			return "\t", 2, true
+		case 'b':
+			return "\b", 2, true
+		case 'f':
+			return "\f", 2, true
+		case 'u':
+			if len(s) >= 6 {
+				if c, err := strconv.ParseUint(s[2:6], 16, 16); err == nil {
+					return string(rune(c)), 6, true
+				}
+			}
+		}
+	}
+
+	return "", 0, false
+}
+
+func restorePlaceholders(value string) string {
+	value = strings.ReplaceAll(value, quotePlaceholder, `"`)
+	return strings.ReplaceAll(value, backslashPlaceholder, `\`)
+}
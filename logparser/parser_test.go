@@ -0,0 +1,63 @@
+package logparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+const format = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+
+func TestEscapedQuotesAreParsedWithJSONEscaping(t *testing.T) {
+	t.Parallel()
+
+	p := NewParser(format, config.EscapeJSON)
+	line := `10.0.0.1 - - [10/Oct/2020:13:55:36 +0000] "GET /search?q=\"foo\" HTTP/1.1" 200 512 "-" "Mozilla/5.0 \"quoted\" agent\\"`
+
+	entry, err := p.ParseString(line)
+	require.Nil(t, err)
+
+	fields := entry.Fields()
+	assert.Equal(t, `GET /search?q="foo" HTTP/1.1`, fields["request"])
+	assert.Equal(t, "200", fields["status"])
+	assert.Equal(t, "512", fields["body_bytes_sent"])
+	assert.Equal(t, `Mozilla/5.0 "quoted" agent\`, fields["http_user_agent"])
+}
+
+func TestJSONEscapeSequencesAreDecoded(t *testing.T) {
+	t.Parallel()
+
+	p := NewParser(format, config.EscapeJSON)
+	line := `10.0.0.1 - - [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200 0 "-" "tab\there \u00e4"`
+
+	entry, err := p.ParseString(line)
+	require.Nil(t, err)
+	assert.Equal(t, "tab\there ä", entry.Fields()["http_user_agent"])
+}
+
+func TestEscapedQuotesAreParsedWithDefaultEscaping(t *testing.T) {
+	t.Parallel()
+
+	p := NewParser(format, config.EscapeDefault)
+	line := `10.0.0.1 - - [10/Oct/2020:13:55:36 +0000] "GET /search?q=\x22foo\x22 HTTP/1.1" 200 512 "-" "Mozilla/5.0 \"quoted\""`
+
+	entry, err := p.ParseString(line)
+	require.Nil(t, err)
+
+	fields := entry.Fields()
+	assert.Equal(t, `GET /search?q="foo" HTTP/1.1`, fields["request"])
+	assert.Equal(t, "200", fields["status"])
+	assert.Equal(t, `Mozilla/5.0 "quoted"`, fields["http_user_agent"])
+}
+
+func TestEscapedQuotesFailWithoutEscaping(t *testing.T) {
+	t.Parallel()
+
+	p := NewParser(format, config.EscapeNone)
+	line := `10.0.0.1 - - [10/Oct/2020:13:55:36 +0000] "GET /search?q=\"foo\" HTTP/1.1" 200 512 "-" "curl"`
+
+	_, err := p.ParseString(line)
+	assert.NotNil(t, err)
+}
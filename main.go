@@ -17,53 +17,234 @@
 package main
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
-	"github.com/DataDog/datadog-go/statsd"
+	"github.com/DataDog/datadog-go/v5/statsd"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/satyrius/gonx"
 	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
 	"github.com/tokopedia/prometheus-nginxlog-exporter/discovery"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/logparser"
 	"github.com/tokopedia/prometheus-nginxlog-exporter/prof"
 	"github.com/tokopedia/prometheus-nginxlog-exporter/relabeling"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/s3"
 	"github.com/tokopedia/prometheus-nginxlog-exporter/syslog"
 	"github.com/tokopedia/prometheus-nginxlog-exporter/tail"
 )
 
+// NSMetrics bundles a namespace's configuration with its metrics and the
+// registry they are registered at
 type NSMetrics struct {
 	cfg      *config.NamespaceConfig
 	registry *prometheus.Registry
 	Metrics
+
+	// lock guards cfg, registry and Metrics, which are replaced when the
+	// namespace is reset on a configuration reload
+	lock sync.RWMutex
 }
 
-func NewNSMetrics(cfg *config.NamespaceConfig, ddog *statsd.Client) *NSMetrics {
+func NewNSMetrics(cfg *config.NamespaceConfig, ddog datadogClients) *NSMetrics {
 	m := &NSMetrics{
 		cfg:      cfg,
 		registry: prometheus.NewRegistry(),
 	}
 	m.Init(cfg)
 
-	m.registry.MustRegister(m.countTotal)
-	m.registry.MustRegister(m.bytesTotal)
-	m.registry.MustRegister(m.upstreamSeconds)
-	m.registry.MustRegister(m.upstreamSecondsHist)
-	m.registry.MustRegister(m.responseSeconds)
-	m.registry.MustRegister(m.responseSecondsHist)
-	m.registry.MustRegister(m.parseErrorsTotal)
+	m.registry.MustRegister(m.namespaceInfo)
+
+	if cfg.Metrics.CountEnabled() {
+		m.registry.MustRegister(m.countTotal)
+	}
+
+	if cfg.MetricsProfile != config.MetricsProfileMinimal {
+		// Without byte counts in the log format, the metric would always be
+		// zero and misleadingly imply data
+		if cfg.Metrics.BytesEnabled() && config.FormatContainsField(cfg.Format, "body_bytes_sent") {
+			m.registry.MustRegister(m.bytesTotal)
+		}
+
+		if cfg.Metrics.UpstreamSummaryEnabled() {
+			m.registry.MustRegister(m.upstreamSeconds)
+		}
+
+		if cfg.Metrics.UpstreamHistogramEnabled() {
+			m.registry.MustRegister(m.upstreamSecondsHist)
+		}
+
+		if cfg.Metrics.ResponseSummaryEnabled() {
+			m.registry.MustRegister(m.responseSeconds)
+		}
+
+		if cfg.Metrics.ResponseHistogramEnabled() {
+			m.registry.MustRegister(m.responseSecondsHist)
+		}
+
+		for _, n := range m.numericMetrics {
+			m.registry.MustRegister(n.collector)
+		}
+
+		m.registry.MustRegister(m.interarrivalSeconds)
+
+		if cfg.ResponseSizeHistogram {
+			m.registry.MustRegister(m.responseSizeHist)
+		}
+	}
+
+	if cfg.Metrics.ParseErrorsEnabled() {
+		m.registry.MustRegister(m.parseErrorsTotal)
+
+		if cfg.StartupGraceDuration > 0 {
+			m.registry.MustRegister(m.startupParseErrorsTotal)
+		}
+	}
+
+	for _, c := range m.customCounters {
+		m.registry.MustRegister(c.counter)
+	}
+
+	if m.upstreamErrors != nil {
+		m.registry.MustRegister(m.upstreamErrors.counter)
+	}
+
+	m.registry.MustRegister(m.invalidLabelValues)
+
+	if m.distinctValues != nil {
+		m.registry.MustRegister(m.distinctValues)
+	}
+
+	if m.requestWindow != nil {
+		m.registry.MustRegister(m.requestWindow)
+	}
+
+	if m.series != nil {
+		m.registry.MustRegister(m.seriesEvictedTotal)
+	}
+	m.registry.MustRegister(m.parseTimeoutsTotal)
+
+	if cfg.OnNegativeTiming == config.NegativeTimingClamp || cfg.OnNegativeTiming == config.NegativeTimingDrop {
+		m.registry.MustRegister(m.invalidTimingTotal)
+	}
+	m.registry.MustRegister(m.distinctStatusCodes)
+
+	if m.syslogMessagesReceivedTotal != nil {
+		m.registry.MustRegister(m.syslogMessagesReceivedTotal)
+	}
+
+	if m.syslogDedup != nil {
+		m.registry.MustRegister(m.syslogDuplicatesDroppedTotal)
+	}
+
+	if cfg.DebugMetrics {
+		m.registry.MustRegister(m.bytesReadTotal)
+		m.registry.MustRegister(m.relabelUnmatchedTotal)
+		m.registry.MustRegister(m.timingFieldMissingTotal)
+		m.registry.MustRegister(m.lineProcessingSeconds)
+	}
 	m.datadogClient = ddog
+	if cfg.Datadog.FlushIntervalDuration > 0 {
+		m.datadogAggregator = newDatadogAggregator(ddog, cfg.Datadog.FlushIntervalDuration)
+	}
+
 	return m
 }
 
+// Reset replaces the namespace's configuration and re-creates its registry and
+// metrics from scratch. All previously collected series are dropped.
+func (m *NSMetrics) Reset(cfg *config.NamespaceConfig) {
+	fresh := NewNSMetrics(cfg, m.datadogClient)
+
+	m.lock.Lock()
+	previous := m.datadogAggregator
+
+	m.cfg = fresh.cfg
+	m.registry = fresh.registry
+	fresh.status = m.status
+	m.Metrics = fresh.Metrics
+	m.lock.Unlock()
+
+	// No line is counted with the previous configuration anymore
+	if previous != nil {
+		previous.close()
+	}
+}
+
+// CloseDatadog sends the counts that are still aggregated for Datadog, and
+// stops aggregating them
+func (m *NSMetrics) CloseDatadog() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.datadogAggregator != nil {
+		m.datadogAggregator.close()
+		m.datadogAggregator = nil
+	}
+}
+
+// prepareRelabelings builds a copy of the namespace's configuration with the
+// given relabel configurations, and the relabelings for it. Since the label
+// names of the existing metrics cannot be changed, this fails if the new
+// configuration results in different labels.
+func (m *NSMetrics) prepareRelabelings(relabelConfigs []config.RelabelConfig) (*config.NamespaceConfig, []*relabeling.Relabeling, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	cfg := m.cfg.Copy()
+	cfg.RelabelConfigs = relabelConfigs
+
+	if err := cfg.Compile(); err != nil {
+		return nil, nil, err
+	}
+
+	relabelings := relabeling.NewNamespaceRelabelings(cfg)
+
+	if !equalLabelNames(labelNames(m.cfg, m.relabelings), labelNames(cfg, relabelings)) {
+		return nil, nil, fmt.Errorf("namespace %s: changing the set of target labels requires a restart", cfg.Name)
+	}
+
+	return cfg, relabelings, nil
+}
+
+// replaceRelabelings replaces the namespace's configuration and relabelings
+// (as built by prepareRelabelings) without re-creating its metrics
+func (m *NSMetrics) replaceRelabelings(cfg *config.NamespaceConfig, relabelings []*relabeling.Relabeling) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.cfg = cfg
+	m.relabelings = relabelings
+	m.invalidLabelValues.replace(relabelings)
+}
+
+// Gather implements the prometheus.Gatherer interface, always gathering from
+// the namespace's current registry
+func (m *NSMetrics) Gather() ([]*dto.MetricFamily, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.registry.Gather()
+}
+
 // Metrics is a struct containing pointers to all metrics that should be
 // exposed to Prometheus
 type Metrics struct {
@@ -73,115 +254,510 @@ type Metrics struct {
 	upstreamSecondsHist *prometheus.HistogramVec
 	responseSeconds     *prometheus.SummaryVec
 	responseSecondsHist *prometheus.HistogramVec
+	responseSizeHist    *prometheus.HistogramVec
 	parseErrorsTotal    prometheus.Counter
-	datadogClient       *statsd.Client
-}
-
-func inLabels(label string, labels []string) bool {
-	for _, l := range labels {
-		if label == l {
-			return true
-		}
-	}
-	return false
+	parseTimeoutsTotal  prometheus.Counter
+	invalidTimingTotal  *prometheus.CounterVec
+	bytesReadTotal      *prometheus.CounterVec
+	interarrivalSeconds *prometheus.HistogramVec
+	distinctStatusCodes *prometheus.GaugeVec
+	namespaceInfo       prometheus.Gauge
+	datadogClient       datadogClients
+
+	// datadogAggregator sums up the counts sent to Datadog; nil if counts
+	// are sent for each line
+	datadogAggregator *datadogAggregator
+
+	// startupParseErrorsTotal counts the parse errors during the startup
+	// grace period, which are not logged
+	startupParseErrorsTotal prometheus.Counter
+
+	// distinctValues estimates the number of distinct values of log fields;
+	// nil if no distinct fields are configured
+	distinctValues *distinctValues
+
+	// requestWindow counts the lines of each source within a sliding window;
+	// nil if no request window is configured
+	requestWindow *requestWindow
+
+	// series limits the number of label value combinations; nil if unlimited
+	series             *seriesLimiter
+	seriesEvictedTotal prometheus.Counter
+
+	// syslogDedup detects duplicate syslog messages; nil if disabled
+	syslogDedup                  *dedupCache
+	syslogDuplicatesDroppedTotal prometheus.Counter
+
+	// syslogMessagesReceivedTotal counts the syslog messages by transport;
+	// nil if the namespace has no syslog source
+	syslogMessagesReceivedTotal *prometheus.CounterVec
+
+	// numericMetrics are the custom metrics populated from numeric log fields
+	numericMetrics []numericMetric
+
+	// customCounters count the log lines by the value of a log field
+	customCounters []customCounter
+
+	// upstreamErrors counts the failed requests by upstream server; nil if
+	// disabled
+	upstreamErrors *upstreamErrors
+
+	// status counts the lines for the status page
+	status *lineStatus
+
+	// relabelings determine the (non-static) labels of all metrics
+	relabelings []*relabeling.Relabeling
+
+	// invalidLabelValues counts the values replaced by a relabeling's
+	// label_value_pattern. It is always set (and exports nothing without
+	// patterns), since a relabel reload may add patterns.
+	invalidLabelValues *invalidLabelValues
+
+	// debug metrics; only registered when enabled in the namespace config
+	relabelUnmatchedTotal   *prometheus.CounterVec
+	timingFieldMissingTotal *prometheus.CounterVec
+	lineProcessingSeconds   prometheus.Histogram
 }
 
 // Init initializes a metrics struct
 func (m *Metrics) Init(cfg *config.NamespaceConfig) {
 	cfg.MustCompile()
 
-	labels := cfg.OrderedLabelNames
-
-	for i := range cfg.RelabelConfigs {
-		labels = append(labels, cfg.RelabelConfigs[i].TargetLabel)
-	}
+	m.status = newLineStatus()
+	m.relabelings = relabeling.NewNamespaceRelabelings(cfg)
+	m.invalidLabelValues = newInvalidLabelValues(cfg, m.relabelings)
 
-	for _, r := range relabeling.DefaultRelabelings {
-		if !inLabels(r.TargetLabel, labels) {
-			labels = append(labels, r.TargetLabel)
-		}
-	}
+	labels := labelNames(cfg, m.relabelings)
 
 	m.countTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace:   cfg.NamespacePrefix,
 		ConstLabels: cfg.NamespaceLabels,
-		Name:        "http_response_count_total",
-		Help:        "Amount of processed HTTP requests",
+		Name:        metricResponseCount,
+		Help:        cfg.HelpOrDefault(metricResponseCount, "Amount of processed HTTP requests"),
 	}, labels)
 
 	m.bytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace:   cfg.NamespacePrefix,
 		ConstLabels: cfg.NamespaceLabels,
-		Name:        "http_response_size_bytes",
-		Help:        "Total amount of transferred bytes",
+		Name:        cfg.MetricName(metricResponseSize),
+		Help:        cfg.HelpOrDefault(metricResponseSize, "Total amount of transferred bytes"),
 	}, labels)
 
 	m.upstreamSeconds = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Namespace:   cfg.NamespacePrefix,
 		ConstLabels: cfg.NamespaceLabels,
-		Name:        "http_upstream_time_seconds",
-		Help:        "Time needed by upstream servers to handle requests",
+		Name:        metricUpstreamTime,
+		Help:        cfg.HelpOrDefault(metricUpstreamTime, "Time needed by upstream servers to handle requests"),
 		Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		MaxAge:      cfg.SummaryMaxAgeDuration,
+		AgeBuckets:  uint32(cfg.SummaryAgeBuckets),
 	}, labels)
 
 	m.upstreamSecondsHist = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace:   cfg.NamespacePrefix,
 		ConstLabels: cfg.NamespaceLabels,
-		Name:        "http_upstream_time_seconds_hist",
-		Help:        "Time needed by upstream servers to handle requests",
-		Buckets:     cfg.HistogramBuckets,
+		Name:        cfg.MetricName(metricUpstreamTime + "_hist"),
+		Help:        cfg.HelpOrDefault(metricUpstreamTime+"_hist", "Time needed by upstream servers to handle requests"),
+		Buckets:     cfg.UpstreamHistogramBucketsOrDefault(),
 	}, labels)
 
 	m.responseSeconds = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Namespace:   cfg.NamespacePrefix,
 		ConstLabels: cfg.NamespaceLabels,
-		Name:        "http_response_time_seconds",
-		Help:        "Time needed by NGINX to handle requests",
+		Name:        metricResponseTime,
+		Help:        cfg.HelpOrDefault(metricResponseTime, "Time needed by NGINX to handle requests"),
 		Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		MaxAge:      cfg.SummaryMaxAgeDuration,
+		AgeBuckets:  uint32(cfg.SummaryAgeBuckets),
 	}, labels)
 
 	m.responseSecondsHist = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace:   cfg.NamespacePrefix,
 		ConstLabels: cfg.NamespaceLabels,
-		Name:        "http_response_time_seconds_hist",
-		Help:        "Time needed by NGINX to handle requests",
-		Buckets:     cfg.HistogramBuckets,
+		Name:        cfg.MetricName(metricResponseTime + "_hist"),
+		Help:        cfg.HelpOrDefault(metricResponseTime+"_hist", "Time needed by NGINX to handle requests"),
+		Buckets:     cfg.ResponseHistogramBucketsOrDefault(),
 	}, labels)
 
+	m.numericMetrics = make([]numericMetric, len(cfg.NumericMetrics))
+	for i := range cfg.NumericMetrics {
+		m.numericMetrics[i] = newNumericMetric(cfg, &cfg.NumericMetrics[i], labels)
+	}
+
+	m.customCounters = make([]customCounter, len(cfg.CustomCounters))
+	for i := range cfg.CustomCounters {
+		m.customCounters[i] = newCustomCounter(cfg, &cfg.CustomCounters[i])
+	}
+
+	if cfg.UpstreamErrors {
+		m.upstreamErrors = newUpstreamErrors(cfg)
+	}
+
 	m.parseErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace:   cfg.NamespacePrefix,
 		ConstLabels: cfg.NamespaceLabels,
 		Name:        "parse_errors_total",
-		Help:        "Total number of log file lines that could not be parsed",
+		Help:        cfg.HelpOrDefault("parse_errors_total", "Total number of log file lines that could not be parsed"),
+	})
+
+	m.startupParseErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   cfg.NamespacePrefix,
+		ConstLabels: cfg.NamespaceLabels,
+		Name:        "startup_parse_errors_total",
+		Help:        cfg.HelpOrDefault("startup_parse_errors_total", "Total number of log file lines that could not be parsed during the startup grace period (also counted in parse_errors_total)"),
+	})
+
+	if len(cfg.DistinctFields) > 0 {
+		m.distinctValues = newDistinctValues(cfg)
+	}
+
+	if cfg.RequestWindowDuration > 0 {
+		m.requestWindow = newRequestWindow(cfg)
+	}
+
+	if cfg.MaxSeries > 0 {
+		m.series = newSeriesLimiter(cfg.MaxSeries)
+		m.seriesEvictedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   cfg.NamespacePrefix,
+			ConstLabels: cfg.NamespaceLabels,
+			Name:        "series_evicted_total",
+			Help:        cfg.HelpOrDefault("series_evicted_total", "Total number of label value combinations that were removed from all metrics because max_series was exceeded"),
+		})
+	}
+
+	m.parseTimeoutsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   cfg.NamespacePrefix,
+		ConstLabels: cfg.NamespaceLabels,
+		Name:        "parse_timeouts_total",
+		Help:        cfg.HelpOrDefault("parse_timeouts_total", "Total number of log file lines that were dropped because parsing exceeded the parse timeout"),
+	})
+
+	if cfg.SourceData.Syslog != nil {
+		m.syslogMessagesReceivedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.NamespacePrefix,
+			ConstLabels: cfg.NamespaceLabels,
+			Name:        "syslog_messages_received_total",
+			Help:        cfg.HelpOrDefault("syslog_messages_received_total", "Total number of syslog messages with one of the configured tags, by the transport they were received with"),
+		}, []string{"transport"})
+	}
+
+	if cfg.SourceData.Syslog != nil && cfg.SourceData.Syslog.Dedup != nil {
+		dedup := cfg.SourceData.Syslog.Dedup
+
+		m.syslogDedup = newDedupCache(dedup.WindowDuration, dedup.MaxEntries)
+		m.syslogDuplicatesDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   cfg.NamespacePrefix,
+			ConstLabels: cfg.NamespaceLabels,
+			Name:        "syslog_duplicates_dropped_total",
+			Help:        cfg.HelpOrDefault("syslog_duplicates_dropped_total", "Total number of syslog messages that were dropped as duplicates"),
+		})
+	}
+
+	m.invalidTimingTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   cfg.NamespacePrefix,
+		ConstLabels: cfg.NamespaceLabels,
+		Name:        "invalid_timing_total",
+		Help:        cfg.HelpOrDefault("invalid_timing_total", "Total number of negative timing values that were clamped to zero or dropped"),
+	}, []string{"field"})
+
+	m.bytesReadTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   cfg.NamespacePrefix,
+		ConstLabels: cfg.NamespaceLabels,
+		Name:        "log_bytes_read_total",
+		Help:        cfg.HelpOrDefault("log_bytes_read_total", "Total amount of bytes read from the log source (regardless of whether they could be parsed)"),
+	}, []string{"source"})
+
+	m.distinctStatusCodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   cfg.NamespacePrefix,
+		ConstLabels: cfg.NamespaceLabels,
+		Name:        "distinct_status_codes",
+		Help:        cfg.HelpOrDefault("distinct_status_codes", "Number of distinct HTTP status codes seen in a log source"),
+	}, []string{"source"})
+
+	m.responseSizeHist = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   cfg.NamespacePrefix,
+		ConstLabels: cfg.NamespaceLabels,
+		Name:        cfg.MetricName(metricResponseSizeHist),
+		Help:        cfg.HelpOrDefault(metricResponseSizeHist, "Distribution of the response body sizes in bytes"),
+		Buckets:     cfg.ResponseSizeBucketsOrDefault(),
+	}, labels)
+
+	m.interarrivalSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   cfg.NamespacePrefix,
+		ConstLabels: cfg.NamespaceLabels,
+		Name:        "log_line_interarrival_seconds",
+		Help:        cfg.HelpOrDefault("log_line_interarrival_seconds", "Time between two consecutive parsed lines of a log source"),
+		Buckets:     cfg.InterarrivalBucketsOrDefault(),
+	}, []string{"source"})
+
+	m.relabelUnmatchedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   cfg.NamespacePrefix,
+		ConstLabels: cfg.NamespaceLabels,
+		Name:        "relabel_unmatched_total",
+		Help:        cfg.HelpOrDefault("relabel_unmatched_total", "Total number of log lines in which the source field of a relabeling was missing"),
+	}, []string{"target_label"})
+
+	m.timingFieldMissingTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   cfg.NamespacePrefix,
+		ConstLabels: cfg.NamespaceLabels,
+		Name:        "timing_field_missing_total",
+		Help:        cfg.HelpOrDefault("timing_field_missing_total", "Total number of log lines in which a timing field was missing or not a number"),
+	}, []string{"field"})
+
+	m.lineProcessingSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   cfg.NamespacePrefix,
+		ConstLabels: cfg.NamespaceLabels,
+		Name:        "line_processing_seconds",
+		Help:        cfg.HelpOrDefault("line_processing_seconds", "Time needed to parse, relabel and emit a single log line"),
+		Buckets:     config.LineProcessingBuckets,
+	})
+
+	m.namespaceInfo = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nginxlog_exporter_namespace_info",
+		Help: "Information about the configuration of a namespace; always 1",
+		ConstLabels: prometheus.Labels{
+			"namespace":     cfg.Name,
+			"format_hash":   formatHash(cfg.Format),
+			"relabel_count": strconv.Itoa(len(cfg.RelabelConfigs)),
+		},
 	})
+	m.namespaceInfo.Set(1)
+}
+
+// formatHash returns a short hash of a log format, which identifies the format
+// without exposing it in a label
+func formatHash(format string) string {
+	sum := sha256.Sum256([]byte(format))
+	return hex.EncodeToString(sum[:8])
+}
+
+// labelNames returns the names of all labels of a namespace's metrics: its
+// static labels (ordered by name) followed by the target labels of its
+// relabelings (in the order of the relabelings). Neither depends on map
+// iteration, so that the label names are the same on every run.
+func labelNames(cfg *config.NamespaceConfig, relabelings []*relabeling.Relabeling) []string {
+	labels := make([]string, 0, len(cfg.OrderedLabelNames)+len(relabelings))
+	labels = append(labels, cfg.OrderedLabelNames...)
+
+	for _, r := range relabelings {
+		labels = append(labels, r.TargetLabel)
+	}
+
+	return labels
+}
+
+func equalLabelNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// numericMetric is a custom metric that is populated from a numeric log field
+type numericMetric struct {
+	name      string
+	typ       string
+	source    string
+	collector prometheus.Collector
+	observe   func(labelValues []string, value float64)
+	delete    func(labelValues []string)
+}
+
+func newNumericMetric(cfg *config.NamespaceConfig, n *config.NumericMetric, labels []string) numericMetric {
+	m := numericMetric{name: n.Name, typ: n.Type, source: n.SourceValue}
+
+	switch n.Type {
+	case config.NumericMetricCounter:
+		v := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.NamespacePrefix,
+			ConstLabels: cfg.NamespaceLabels,
+			Name:        n.Name,
+			Help:        n.HelpOrDefault(),
+		}, labels)
+
+		m.collector = v
+		m.delete = func(labelValues []string) { v.DeleteLabelValues(labelValues...) }
+		m.observe = func(labelValues []string, value float64) {
+			// counters must not decrease
+			if value >= 0 {
+				v.WithLabelValues(labelValues...).Add(value)
+			}
+		}
+	case config.NumericMetricGauge:
+		v := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   cfg.NamespacePrefix,
+			ConstLabels: cfg.NamespaceLabels,
+			Name:        n.Name,
+			Help:        n.HelpOrDefault(),
+		}, labels)
+
+		m.collector = v
+		m.delete = func(labelValues []string) { v.DeleteLabelValues(labelValues...) }
+		m.observe = func(labelValues []string, value float64) {
+			v.WithLabelValues(labelValues...).Set(value)
+		}
+	case config.NumericMetricHistogram:
+		v := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   cfg.NamespacePrefix,
+			ConstLabels: cfg.NamespaceLabels,
+			Name:        n.Name,
+			Help:        n.HelpOrDefault(),
+			Buckets:     cfg.HistogramBuckets,
+		}, labels)
+
+		m.collector = v
+		m.delete = func(labelValues []string) { v.DeleteLabelValues(labelValues...) }
+		m.observe = func(labelValues []string, value float64) {
+			v.WithLabelValues(labelValues...).Observe(value)
+		}
+	}
+
+	return m
+}
+
+// customCounter counts log lines by the value of a log field, with the number
+// of distinct values capped by a relabeling
+type customCounter struct {
+	counter    *prometheus.CounterVec
+	relabeling *relabeling.Relabeling
+}
+
+func newCustomCounter(cfg *config.NamespaceConfig, c *config.CustomCounter) customCounter {
+	label := c.LabelOrDefault()
+
+	return customCounter{
+		counter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.NamespacePrefix,
+			ConstLabels: cfg.NamespaceLabels,
+			Name:        c.Name,
+			Help:        c.HelpOrDefault(),
+		}, []string{label}),
+		relabeling: relabeling.NewCustomCounterRelabeling(cfg, c),
+	}
+}
+
+// count increments the counter for the field's value, if the line has one
+func (c *customCounter) count(fields gonx.Fields) {
+	value, ok := c.relabeling.Source(fields)
+	if !ok {
+		return
+	}
+
+	if mapped, err := c.relabeling.Map(value); err == nil {
+		c.counter.WithLabelValues(mapped).Inc()
+	}
+}
+
+// defaultFollowerGoroutineMargin is the default number of follower
+// goroutines that may be running in excess of the number of configured
+// sources before a possible goroutine leak is reported. Each source starts a
+// single follower goroutine, so any excess is suspicious; the margin keeps a
+// single follower that is still shutting down from being reported by itself.
+const defaultFollowerGoroutineMargin = 2
+
+// sourceUp reports for each log source whether it is currently being followed
+var sourceUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "nginxlog_source_up",
+	Help: "Whether a log source is currently being followed (1) or has failed or ended (0)",
+}, []string{"namespace", "source"})
+
+// NewExporterRegistry creates a registry for metrics describing the exporter
+// itself (as opposed to the metrics of the processed log files)
+func NewExporterRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+
+	r.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "nginxlog_exporter_follower_goroutines",
+		Help: "Number of currently running follower goroutines",
+	}, func() float64 {
+		return float64(tail.Tracker.Count())
+	}))
+	r.MustRegister(sourceUp)
+	r.MustRegister(datadogSendFailuresTotal)
+	r.MustRegister(scrapeErrorsTotal)
+
+	return r
+}
+
+func countSources(cfg *config.Config) int {
+	count := 0
+
+	for _, ns := range cfg.Namespaces {
+		count += len(ns.SourceData.Files)
+		if ns.SourceData.Syslog != nil {
+			count += len(ns.SourceData.Syslog.Tags)
+		}
+		if ns.SourceData.Journald != nil {
+			count++
+		}
+		if ns.SourceData.S3 != nil {
+			count++
+		}
+	}
+
+	return count
+}
+
+func watchFollowerGoroutines(sourceCount int, margin int, stopChan <-chan bool) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			if count := tail.Tracker.Count(); count > int64(sourceCount+margin) {
+				fmt.Printf("warning: %d follower goroutines are running for %d configured sources; followers might be leaking\n", count, sourceCount)
+			}
+		}
+	}
 }
 
 //For Datadog START
 var datadogTags map[string]bool
 
 func (m *Metrics) IncrDD(name string, tags []string) {
-	if m.datadogClient == nil {
+	if m.datadogAggregator != nil {
+		m.datadogAggregator.count(name, 1, tags)
 		return
 	}
-	m.datadogClient.Incr(name, tags, 1)
+
+	m.datadogClient.send(func(c *statsd.Client) error {
+		return c.Incr(name, tags, 1)
+	})
 }
 func (m *Metrics) CountDD(name string, value int64, tags []string) {
-	if m.datadogClient == nil {
+	if m.datadogAggregator != nil {
+		m.datadogAggregator.count(name, value, tags)
 		return
 	}
-	m.datadogClient.Count(name, value, tags, 1)
+
+	m.datadogClient.send(func(c *statsd.Client) error {
+		return c.Count(name, value, tags, 1)
+	})
 }
 func (m *Metrics) HistogramDD(name string, value float64, tags []string) {
-	if m.datadogClient == nil {
+	if m.datadogAggregator != nil {
+		m.datadogAggregator.histogram(name, value, tags)
 		return
 	}
-	m.datadogClient.Histogram(name, value, tags, 1)
+
+	m.datadogClient.send(func(c *statsd.Client) error {
+		return c.Histogram(name, value, tags, 1)
+	})
 }
 func (m *Metrics) GaugeDD(name string, value float64, tags []string) {
-	if m.datadogClient == nil {
-		return
-	}
-	m.datadogClient.Gauge(name, value, tags, 1)
+	m.datadogClient.send(func(c *statsd.Client) error {
+		return c.Gauge(name, value, tags, 1)
+	})
 }
 
 //For Datadog END
@@ -196,6 +772,8 @@ func main() {
 		},
 	}
 	nsGatherers := make(prometheus.Gatherers, 0)
+	federateGatherers := make(prometheus.Gatherers, 0)
+	exporterRegistry := NewExporterRegistry()
 
 	flag.IntVar(&opts.ListenPort, "listen-port", 4040, "HTTP port to listen on")
 	flag.StringVar(&opts.Format, "format", `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" "$http_x_forwarded_for"`, "NGINX access log format")
@@ -205,11 +783,28 @@ func main() {
 	flag.StringVar(&opts.CPUProfile, "cpuprofile", "", "write cpu profile to `file`")
 	flag.StringVar(&opts.MemProfile, "memprofile", "", "write memory profile to `file`")
 	flag.StringVar(&opts.DatadogUrl, "datadog-url", "datadog.tokopedia.local:8125", "Datadog URL")
+	flag.IntVar(&opts.DatadogBreakerThreshold, "datadog-breaker-threshold", 10, "Number of consecutive failed probes of a Datadog agent after which sending to it is paused (0 to never pause)")
+	flag.DurationVar(&opts.DatadogBreakerCooldown, "datadog-breaker-cooldown", 30*time.Second, "Time for which sending to Datadog is paused after too many errors")
 	flag.StringVar(&opts.MetricsEndpoint, "metrics-endpoint", cfg.Listen.MetricsEndpoint, "URL path at which to serve metrics")
+	flag.BoolVar(&opts.StrictFDLimit, "strict-fd-limit", false, "Exit if the open file limit is too low for the configured log sources, instead of logging a warning")
+	flag.BoolVar(&opts.RaiseFDLimit, "raise-fd-limit", false, "Raise the open file limit towards the hard limit if it is too low for the configured log sources")
+	flag.IntVar(&opts.FollowerLeakMargin, "follower-leak-margin", defaultFollowerGoroutineMargin, "Number of follower goroutines that may be running in excess of the configured sources before a possible leak is logged")
+	flag.BoolVar(&opts.Oneshot, "oneshot", false, "Read all log files once up to their end, write the metrics to the -output file and exit")
+	flag.StringVar(&opts.Output, "output", "", "File to write the metrics to in -oneshot mode")
+	flag.BoolVar(&opts.GenerateConfig, "generate-config", false, "Print a starter configuration for the -format (and a sample line read from stdin, if any) and exit")
 	flag.Parse()
 
 	opts.Filenames = flag.Args()
 
+	if opts.GenerateConfig {
+		if err := generateConfig(os.Stdout, os.Stdin, opts.Namespace, opts.Format); err != nil {
+			fmt.Fprintf(os.Stderr, "error while generating configuration: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	stopChan := make(chan bool)
 	stopHandlers := sync.WaitGroup{}
@@ -233,11 +828,6 @@ func main() {
 		stopHandlers.Wait()
 	}()
 
-	dd, err := statsd.New(opts.DatadogUrl)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to connect to datadog.")
-		os.Exit(1)
-	}
 	datadogTags = make(map[string]bool)
 
 	prof.SetupCPUProfiling(opts.CPUProfile, stopChan, &stopHandlers)
@@ -245,6 +835,16 @@ func main() {
 
 	loadConfig(&opts, &cfg)
 
+	if err := detectFormats(&cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "could not detect log format: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if err := cfg.CheckNamespaceConflicts(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %s\n", err.Error())
+		os.Exit(1)
+	}
+
 	fmt.Printf("using configuration %+v\n", cfg)
 
 	if stabilityError := cfg.StabilityWarnings(); stabilityError != nil && !opts.EnableExperimentalFeatures {
@@ -254,84 +854,546 @@ func main() {
 		os.Exit(1)
 	}
 
-	if cfg.Consul.Enable {
-		setupConsul(&cfg, stopChan, &stopHandlers)
+	datadogURLs := cfg.Datadog.URLs
+	if len(datadogURLs) == 0 {
+		datadogURLs = []string{opts.DatadogUrl}
 	}
 
-	for _, ns := range cfg.Namespaces {
-		nsMetrics := NewNSMetrics(&ns, dd)
-		nsGatherers = append(nsGatherers, nsMetrics.registry)
-
-		fmt.Printf("starting listener for namespace %s\n", ns.Name)
-		go processNamespace(ns, &(nsMetrics.Metrics))
+	dd, err := newDatadogClients(datadogURLs, cfg.Datadog.OriginDetection, opts.DatadogBreakerThreshold, opts.DatadogBreakerCooldown)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to datadog: %s\n", err.Error())
+		os.Exit(1)
 	}
 
-	listenAddr := fmt.Sprintf("%s:%d", cfg.Listen.Address, cfg.Listen.Port)
-	endpoint := cfg.Listen.MetricsEndpointOrDefault()
-
-	fmt.Printf("running HTTP server on address %s, serving metrics at %s\n", listenAddr, endpoint)
+	if err := checkFDLimit(countSources(&cfg), opts.RaiseFDLimit); err != nil {
+		if opts.StrictFDLimit {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
 
-	nsHandler := promhttp.InstrumentMetricHandler(
-		prometheus.DefaultRegisterer, promhttp.HandlerFor(nsGatherers, promhttp.HandlerOpts{}),
-	)
+		fmt.Printf("warning: %s\n", err.Error())
+	}
 
-	http.Handle(endpoint, nsHandler)
+	if opts.Oneshot {
+		if err := runOneshot(&cfg, opts.Output, dd, exporterRegistry); err != nil {
+			fmt.Fprintf(os.Stderr, "error in oneshot mode: %s\n", err.Error())
+			os.Exit(1)
+		}
 
-	if err := http.ListenAndServe(listenAddr, nil); err != nil {
-		fmt.Printf("error while starting HTTP server: %s", err.Error())
+		return
 	}
-}
 
-func loadConfig(opts *config.StartupFlags, cfg *config.Config) {
-	if opts.ConfigFile != "" {
-		fmt.Printf("loading configuration file %s\n", opts.ConfigFile)
-		if err := config.LoadConfigFromFile(cfg, opts.ConfigFile); err != nil {
-			panic(err)
+	go dd.runProbes(datadogProbeInterval)
+
+	for i := range cfg.Namespaces {
+		if cfg.Namespaces[i].SourceData.S3 != nil {
+			fmt.Fprintf(os.Stderr, "namespace %s: s3 sources are only supported in oneshot mode\n", cfg.Namespaces[i].Name)
+			os.Exit(1)
 		}
-	} else if err := config.LoadConfigFromFlags(cfg, opts); err != nil {
-		panic(err)
 	}
-}
 
-func setupConsul(cfg *config.Config, stopChan <-chan bool, stopHandlers *sync.WaitGroup) {
-	registrator, err := discovery.NewConsulRegistrator(cfg)
+	// The listener is created before registering in Consul, so that the
+	// actual port is registered when the OS chooses one (listen port 0)
+	listener, err := listen(fmt.Sprintf("%s:%d", cfg.Listen.Address, cfg.Listen.Port), cfg.Listen.ReusePort)
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Printf("registering service in Consul\n")
-	if err := registrator.RegisterConsul(); err != nil {
-		panic(err)
+	cfg.Listen.Port = listener.Addr().(*net.TCPAddr).Port
+
+	// The global listener has its own mux, so that handlers registered on
+	// http.DefaultServeMux by imported packages (like the profiling endpoints
+	// of net/http/pprof) are not exposed
+	mux := http.NewServeMux()
+
+	server, err := newHTTPServer(&cfg.Listen, mux)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid listen configuration: %s\n", err.Error())
+		os.Exit(1)
 	}
 
-	go func() {
-		<-stopChan
-		fmt.Printf("unregistering service in Consul\n")
+	if cfg.AdminListen != nil && cfg.AdminListen.Port <= 0 {
+		fmt.Fprintln(os.Stderr, "invalid admin_listen configuration: a port is required")
+		os.Exit(1)
+	}
 
-		if err := registrator.UnregisterConsul(); err != nil {
-			fmt.Printf("error while unregistering from consul: %s\n", err.Error())
+	if cfg.Consul.Enable {
+		setupConsul(&cfg, stopChan, &stopHandlers)
+	}
+
+	nsMetricsByName := make(map[string]*NSMetrics)
+	pushGatherers := make([]*namespaceGatherer, 0, len(cfg.Namespaces))
+
+	for i := range cfg.Namespaces {
+		ns := &cfg.Namespaces[i]
+		nsMetrics := NewNSMetrics(ns, dd)
+		nsMetricsByName[ns.Name] = nsMetrics
+
+		if ns.Datadog.FlushIntervalDuration > 0 {
+			stopHandlers.Add(1)
+			go func() {
+				<-stopChan
+				nsMetrics.CloseDatadog()
+				stopHandlers.Done()
+			}()
 		}
 
-		stopHandlers.Done()
+		gatherer := &namespaceGatherer{namespace: ns.Name, gatherer: nsMetrics}
+		pushGatherers = append(pushGatherers, gatherer)
+
+		if ns.Listen == nil || !ns.Listen.ExcludeFromGlobal {
+			nsGatherers = append(nsGatherers, gatherer)
+		}
+
+		if ns.Federate {
+			federateGatherers = append(federateGatherers, gatherer)
+		}
+
+		if ns.Listen != nil {
+			serveNamespace(ns, &cfg.Listen, gatherer, stopChan, &stopHandlers)
+		}
+
+		fmt.Printf("starting listener for namespace %s\n", ns.Name)
+		go processNamespace(*ns, nsMetrics, tail.FileFollowerOptions{}, nil)
+	}
+
+	go handleReloads(&opts, nsMetricsByName, stopChan)
+
+	nsGatherers = append(nsGatherers, exporterRegistry)
+	go watchFollowerGoroutines(countSources(&cfg), opts.FollowerLeakMargin, stopChan)
+
+	if cfg.OTLP != nil {
+		// Like pushes to the pushgateway, exports contain all namespaces:
+		// exclude_from_global only concerns the global metrics endpoint
+		otlpGatherers := make(prometheus.Gatherers, 0, len(pushGatherers)+1)
+		for _, g := range pushGatherers {
+			otlpGatherers = append(otlpGatherers, g)
+		}
+		otlpGatherers = append(otlpGatherers, exporterRegistry)
+
+		exporter, err := newOTLPExporter(cfg.OTLP, otlpGatherers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid OTLP configuration: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Printf("exporting metrics via OTLP to %s every %s\n", exporter.endpoint, exporter.interval)
+
+		// The metrics are exported a last time on shutdown
+		stopHandlers.Add(1)
+		go func() {
+			defer stopHandlers.Done()
+			exporter.run(stopChan)
+		}()
+	}
+
+	if cfg.RemoteWrite != nil {
+		writer, err := newRemoteWriter(cfg.RemoteWrite, nsGatherers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid remote_write configuration: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Printf("sending metrics via remote write to %s every %s\n", writer.url, writer.interval)
+		go writer.run(stopChan)
+	}
+
+	if cfg.Pushgateway != nil {
+		pusher, err := newPushgatewayPusher(cfg.Pushgateway, pushGatherers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid pushgateway configuration: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Printf("pushing metrics to the pushgateway at %s every %s\n", pusher.url, pusher.interval)
+
+		// The metrics are pushed a last time on shutdown
+		stopHandlers.Add(1)
+		go func() {
+			defer stopHandlers.Done()
+			pusher.run(stopChan)
+		}()
+	}
+
+	endpoint := cfg.Listen.MetricsEndpointOrDefault()
+
+	fmt.Printf("running HTTP server on address %s, serving metrics at %s\n", listener.Addr().String(), endpoint)
+
+	registerMainHandlers(mux, &cfg, &opts, nsMetricsByName, nsGatherers, federateGatherers)
+
+	if cfg.AdminListen != nil {
+		serveAdmin(cfg.AdminListen, &cfg.Listen, adminHandler(&opts, nsMetricsByName, cfg.Listen.ReloadToken, cfg.AdminListen.StatusPage), stopChan, &stopHandlers)
+	}
+
+	shutdownOnStop("HTTP server", server, stopChan, &stopHandlers)
+
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("error while starting HTTP server: %s", err.Error())
+		return
+	}
+
+	// The server was shut down by a signal; the signal handler exits once all
+	// stop handlers are done
+	select {}
+}
+
+// registerMainHandlers registers the handlers of the global listener: the
+// metrics, the federation endpoint and, without admin listener, the endpoints
+// protected by the reload token
+func registerMainHandlers(mux *http.ServeMux, cfg *config.Config, opts *config.StartupFlags, nsMetricsByName map[string]*NSMetrics, nsGatherers prometheus.Gatherers, federateGatherers prometheus.Gatherers) {
+	nsHandler := promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer, scrapeHandler(&cfg.Listen, nsGatherers),
+	)
+
+	mux.Handle(cfg.Listen.MetricsEndpointOrDefault(), nsHandler)
+
+	if len(federateGatherers) > 0 {
+		fmt.Printf("serving metrics of %d namespace(s) for federation at /federate\n", len(federateGatherers))
+		mux.Handle("/federate", scrapeHandler(&cfg.Listen, federateGatherers))
+	}
+
+	if cfg.AdminListen == nil && cfg.Listen.ReloadToken != "" {
+		mux.Handle("/-/reload-relabel", reloadRelabelHandler(opts, nsMetricsByName, cfg.Listen.ReloadToken))
+		mux.Handle("/-/pause", pauseHandler(pausableSources, cfg.Listen.ReloadToken, true))
+		mux.Handle("/-/resume", pauseHandler(pausableSources, cfg.Listen.ReloadToken, false))
+	}
+}
+
+// scrapeHandler returns a handler that serves the metrics of the gatherers,
+// gathering them separately if the namespaces are to be isolated
+func scrapeHandler(listenCfg *config.ListenConfig, gatherers prometheus.Gatherers) http.Handler {
+	var gatherer prometheus.Gatherer = gatherers
+	handlerOpts := promhttp.HandlerOpts{}
+
+	if listenCfg.IsolateNamespaces {
+		timeout, err := listenCfg.ScrapeTimeoutOrDefault()
+		if err != nil {
+			panic(err)
+		}
+
+		gatherer = &isolatedGatherers{gatherers: gatherers, timeout: timeout}
+		handlerOpts.ErrorHandling = promhttp.ContinueOnError
+	}
+
+	return promhttp.HandlerFor(gatherer, handlerOpts)
+}
+
+// serveNamespace starts a dedicated HTTP server that serves only the metrics
+// of a single namespace, using the timeouts of the global listen configuration.
+// The server is shut down when stopChan is closed.
+func serveNamespace(ns *config.NamespaceConfig, listenCfg *config.ListenConfig, gatherer prometheus.Gatherer, stopChan <-chan bool, stopHandlers *sync.WaitGroup) {
+	listenAddr := fmt.Sprintf("%s:%d", ns.Listen.AddressOrDefault(), ns.Listen.Port)
+	endpoint := ns.Listen.MetricsEndpointOrDefault()
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(endpoint, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+
+	server, err := newHTTPServer(listenCfg, mux)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("running HTTP server for namespace %s on address %s, serving metrics at %s\n", ns.Name, listener.Addr().String(), endpoint)
+
+	serveInBackground("HTTP server for namespace "+ns.Name, server, listener, stopChan, stopHandlers)
+}
+
+func loadConfig(opts *config.StartupFlags, cfg *config.Config) {
+	if opts.ConfigFile != "" {
+		fmt.Printf("loading configuration file %s\n", opts.ConfigFile)
+		if err := config.LoadConfigFromFile(cfg, opts.ConfigFile); err != nil {
+			panic(err)
+		}
+	} else if err := config.LoadConfigFromFlags(cfg, opts); err != nil {
+		panic(err)
+	}
+}
+
+// handleReloads re-reads the configuration file whenever the exporter receives
+// a SIGHUP. Since followers and metrics cannot be changed while running, only
+// namespaces that have "reset_on_reload" enabled are re-created (dropping all
+// of their previous series); changes to other namespaces require a restart.
+func handleReloads(opts *config.StartupFlags, nsMetricsByName map[string]*NSMetrics, stopChan <-chan bool) {
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	defer signal.Stop(hupChan)
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-hupChan:
+			reloadConfig(opts, nsMetricsByName)
+		}
+	}
+}
+
+// reloadLock serializes configuration reloads
+var reloadLock sync.Mutex
+
+func reloadConfig(opts *config.StartupFlags, nsMetricsByName map[string]*NSMetrics) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	if opts.ConfigFile == "" {
+		fmt.Printf("not reloading configuration, since no configuration file is used\n")
+		return
+	}
+
+	fmt.Printf("reloading configuration file %s\n", opts.ConfigFile)
+
+	var cfg config.Config
+	if err := config.LoadConfigFromFile(&cfg, opts.ConfigFile); err != nil {
+		fmt.Printf("error while reloading configuration: %s\n", err.Error())
+		return
+	}
+
+	if err := cfg.CheckNamespaceConflicts(); err != nil {
+		fmt.Printf("error while reloading configuration: %s\n", err.Error())
+		return
+	}
+
+	for i := range cfg.Namespaces {
+		ns := &cfg.Namespaces[i]
+
+		nsMetrics, ok := nsMetricsByName[ns.Name]
+		if !ok {
+			fmt.Printf("ignoring new namespace %s; adding namespaces requires a restart\n", ns.Name)
+			continue
+		}
+
+		if !ns.ResetOnReload {
+			continue
+		}
+
+		// The detected format is kept, since the metrics' labels depend on it
+		if ns.AutoDetectFormat && ns.Format == "" && ns.FormatName == "" {
+			nsMetrics.lock.RLock()
+			ns.FormatName = nsMetrics.cfg.FormatName
+			nsMetrics.lock.RUnlock()
+		}
+
+		if err := ns.Compile(); err != nil {
+			fmt.Printf("error while reloading namespace %s: %s\n", ns.Name, err.Error())
+			continue
+		}
+
+		nsMetrics.lock.RLock()
+		sourcesChanged := !reflect.DeepEqual(nsMetrics.cfg.SourceData.Files, ns.SourceData.Files)
+		nsMetrics.lock.RUnlock()
+
+		if sourcesChanged {
+			fmt.Printf("namespace %s: ignoring changed log files; changing sources requires a restart\n", ns.Name)
+		}
+
+		fmt.Printf("resetting metrics for namespace %s\n", ns.Name)
+		nsMetrics.Reset(ns)
+	}
+}
+
+// runOneshot reads all configured log files up to their current end and then
+// writes the collected metrics in the Prometheus text format to the output
+// file (for example, for use with node_exporter's textfile collector)
+func runOneshot(cfg *config.Config, output string, ddog datadogClients, exporterRegistry *prometheus.Registry) error {
+	if output == "" && cfg.Pushgateway == nil {
+		return fmt.Errorf("the -output flag (or a pushgateway) is required in oneshot mode")
+	}
+
+	gatherers := make(prometheus.Gatherers, 0, len(cfg.Namespaces)+1)
+	pushGatherers := make([]*namespaceGatherer, 0, len(cfg.Namespaces))
+	allNSMetrics := make([]*NSMetrics, 0, len(cfg.Namespaces))
+	done := sync.WaitGroup{}
+
+	for i := range cfg.Namespaces {
+		ns := &cfg.Namespaces[i]
+		if ns.SourceData.Syslog != nil {
+			return fmt.Errorf("namespace %s: syslog sources are not supported in oneshot mode", ns.Name)
+		}
+		if ns.SourceData.Journald != nil {
+			return fmt.Errorf("namespace %s: journald sources are not supported in oneshot mode", ns.Name)
+		}
+
+		nsMetrics := NewNSMetrics(ns, ddog)
+		allNSMetrics = append(allNSMetrics, nsMetrics)
+		gatherers = append(gatherers, nsMetrics)
+		pushGatherers = append(pushGatherers, &namespaceGatherer{namespace: ns.Name, gatherer: nsMetrics})
+
+		fmt.Printf("reading log files for namespace %s\n", ns.Name)
+		processNamespace(*ns, nsMetrics, tail.FileFollowerOptions{Oneshot: true}, &done)
+	}
+
+	done.Wait()
+
+	for _, m := range allNSMetrics {
+		m.CloseDatadog()
+	}
+
+	if cfg.Pushgateway != nil {
+		pusher, err := newPushgatewayPusher(cfg.Pushgateway, pushGatherers)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("pushing metrics to the pushgateway at %s\n", pusher.url)
+		if err := pusher.pushAll(); err != nil {
+			return err
+		}
+	}
+
+	if output == "" {
+		return nil
+	}
+
+	gatherers = append(gatherers, exporterRegistry)
+
+	fmt.Printf("writing metrics to %s\n", output)
+	return prometheus.WriteToTextfile(output, gatherers)
+}
+
+// generateConfig prints a starter configuration for a log format. If a sample
+// line is piped to stdin, it is parsed with the format and its field values are
+// included in the output.
+func generateConfig(out io.Writer, stdin *os.File, namespace string, format string) error {
+	var sample map[string]string
+
+	if stat, err := stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice == 0 {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			entry, err := gonx.NewParser(format).ParseString(line)
+			if err != nil {
+				return fmt.Errorf("sample line does not match the format: %s", err.Error())
+			}
+
+			sample = entry.Fields()
+		}
+	}
+
+	_, err := io.WriteString(out, config.GenerateConfig(namespace, format, sample))
+	return err
+}
+
+// reloadRelabelHandler returns a handler that reloads only the relabel
+// configurations from the configuration file. Requests need to pass the
+// configured token as bearer token.
+func reloadRelabelHandler(opts *config.StartupFlags, nsMetricsByName map[string]*NSMetrics, token string) http.Handler {
+	return authorizedPost(token, func(w http.ResponseWriter, r *http.Request) {
+		if err := reloadRelabelConfigs(opts, nsMetricsByName); err != nil {
+			fmt.Printf("error while reloading relabel configuration: %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprintln(w, "relabel configuration reloaded")
+	})
+}
+
+// reloadRelabelConfigs re-reads the configuration file and replaces the
+// relabel configurations of all running namespaces. All namespaces are
+// validated before any of them is changed.
+func reloadRelabelConfigs(opts *config.StartupFlags, nsMetricsByName map[string]*NSMetrics) error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	if opts.ConfigFile == "" {
+		return fmt.Errorf("no configuration file is used")
+	}
+
+	var cfg config.Config
+	if err := config.LoadConfigFromFile(&cfg, opts.ConfigFile); err != nil {
+		return err
+	}
+
+	relabelConfigs := make(map[string][]config.RelabelConfig, len(cfg.Namespaces))
+
+	for i := range cfg.Namespaces {
+		ns := &cfg.Namespaces[i]
+		if _, ok := nsMetricsByName[ns.Name]; !ok {
+			fmt.Printf("ignoring new namespace %s; adding namespaces requires a restart\n", ns.Name)
+			continue
+		}
+
+		relabelConfigs[ns.Name] = ns.RelabelConfigs
+	}
+
+	type preparedNamespace struct {
+		cfg         *config.NamespaceConfig
+		relabelings []*relabeling.Relabeling
+	}
+
+	prepared := make(map[string]preparedNamespace, len(nsMetricsByName))
+
+	for name, nsMetrics := range nsMetricsByName {
+		cfg, relabelings, err := nsMetrics.prepareRelabelings(relabelConfigs[name])
+		if err != nil {
+			return err
+		}
+
+		prepared[name] = preparedNamespace{cfg, relabelings}
+	}
+
+	for name, p := range prepared {
+		nsMetricsByName[name].replaceRelabelings(p.cfg, p.relabelings)
+		fmt.Printf("reloaded relabel configuration of namespace %s\n", name)
+	}
+
+	return nil
+}
+
+func setupConsul(cfg *config.Config, stopChan <-chan bool, stopHandlers *sync.WaitGroup) {
+	registrator, err := discovery.NewConsulRegistrator(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("registering service in Consul\n")
+	if err := registrator.RegisterConsul(); err != nil {
+		panic(err)
+	}
+
+	go func() {
+		<-stopChan
+		fmt.Printf("unregistering service in Consul\n")
+
+		if err := registrator.UnregisterConsul(); err != nil {
+			fmt.Printf("error while unregistering from consul: %s\n", err.Error())
+		}
+
+		stopHandlers.Done()
 	}()
 
 	stopHandlers.Add(1)
 }
 
-func processNamespace(nsCfg config.NamespaceConfig, metrics *Metrics) {
+// processNamespace starts processing all sources of a namespace. If done is
+// not nil, it is notified as soon as a source has been read completely (which
+// only happens for files in oneshot mode).
+func processNamespace(nsCfg config.NamespaceConfig, nsMetrics *NSMetrics, fileOpts tail.FileFollowerOptions, done *sync.WaitGroup) {
 	var followers []tail.Follower
+	fromSyslog := make(map[tail.Follower]bool)
 
-	parser := gonx.NewParser(nsCfg.Format)
+	fileOpts.ReopenBackoff = nsCfg.SourceData.ReopenBackoffDuration
+	fileOpts.ReadFromStart = nsCfg.SourceData.ReadFromStart
+	fileOpts.ReadBufferBytes = nsCfg.SourceData.ReadBufferBytes
+	fileOpts.MaxLineBytes = nsCfg.SourceData.MaxLineBytesOrDefault()
+	fileOpts.EOFGrace = nsCfg.SourceData.EOFGraceDuration
 
 	for _, f := range nsCfg.SourceData.Files {
-		t, err := tail.NewFileFollower(f)
+		t, err := tail.NewFileFollower(f, fileOpts)
 		if err != nil {
 			panic(err)
 		}
 
-		t.OnError(func(err error) {
-			panic(err)
-		})
+		t.OnError(sourceErrorHandler(nsCfg.Name, t))
 
 		followers = append(followers, t)
 	}
@@ -346,23 +1408,76 @@ func processNamespace(nsCfg config.NamespaceConfig, metrics *Metrics) {
 		}
 
 		for _, f := range slCfg.Tags {
-			t, err := tail.NewSyslogFollower(f, server, channel)
+			t, err := tail.NewSyslogFollower(f, slCfg.Transport(), server, channel)
 			if err != nil {
 				panic(err)
 			}
 
-			t.OnError(func(err error) {
-				panic(err)
-			})
+			t.OnError(sourceErrorHandler(nsCfg.Name, t))
 
 			followers = append(followers, t)
+			fromSyslog[t] = true
+		}
+	}
+
+	if jdCfg := nsCfg.SourceData.Journald; jdCfg != nil {
+		t, err := tail.NewJournaldFollower(jdCfg.Units, jdCfg.CursorFile, nsCfg.SourceData.ReadBufferBytes)
+		if err != nil {
+			panic(err)
+		}
+
+		t.OnError(sourceErrorHandler(nsCfg.Name, t))
+
+		followers = append(followers, t)
+	}
+
+	// Objects in S3 are only read once, so they cannot be followed
+	if s3Cfg := nsCfg.SourceData.S3; s3Cfg != nil && fileOpts.Oneshot {
+		t, err := tail.NewS3Follower(newS3Client(s3Cfg), s3Cfg.Bucket, s3Cfg.Prefix)
+		if err != nil {
+			panic(err)
 		}
+
+		t.OnError(sourceErrorHandler(nsCfg.Name, t))
+
+		followers = append(followers, t)
 	}
 
 	for _, f := range followers {
-		go processSource(nsCfg, f, parser, metrics)
+		if done == nil {
+			go processSource(f, nsMetrics, fromSyslog[f])
+			continue
+		}
+
+		done.Add(1)
+		go func(f tail.Follower) {
+			defer done.Done()
+			processSource(f, nsMetrics, fromSyslog[f])
+		}(f)
 	}
+}
+
+// sourceErrorHandler returns the error handler of a follower, which reports the
+// source as down. The follower ends after an error, while the namespace's
+// other sources keep being processed.
+func sourceErrorHandler(namespace string, t tail.Follower) func(error) {
+	return func(err error) {
+		fmt.Printf("namespace %s: error while reading %s, no longer following it: %s\n", namespace, t.Source(), err)
+		sourceUp.WithLabelValues(namespace, t.Source()).Set(0)
+	}
+}
+
+// newS3Client creates a client for the object store of an S3 source
+func newS3Client(cfg *config.S3Source) *s3.Client {
+	accessKeyID, secretAccessKey, sessionToken := cfg.Credentials()
 
+	return &s3.Client{
+		Endpoint:        cfg.Endpoint,
+		Region:          cfg.RegionOrDefault(),
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		SessionToken:    sessionToken,
+	}
 }
 
 func getServerIP() (string, error) {
@@ -384,95 +1499,579 @@ func getServerIP() (string, error) {
 	return result, nil
 }
 
-func processSource(nsCfg config.NamespaceConfig, t tail.Follower, parser *gonx.Parser, metrics *Metrics) {
-	relabelings := relabeling.NewRelabelings(nsCfg.RelabelConfigs)
-	relabelings = append(relabelings, relabeling.DefaultRelabelings...)
-	relabelings = relabeling.UniqueRelabelings(relabelings)
+// lineParser parses log lines into their fields
+type lineParser interface {
+	ParseString(line string) (*gonx.Entry, error)
+}
+
+// sourceProcessor holds everything that is needed to process the lines of a
+// single log source according to its namespace's configuration
+type sourceProcessor struct {
+	cfg     *config.NamespaceConfig
+	parser  lineParser
+	metrics *Metrics
+	outputs []Output
+
+	// sampleRate is the namespace's Prometheus sample rate; when sampling,
+	// all but every sampleRate-th line only go to unsampledOutputs
+	sampleRate       uint64
+	sampleCounter    uint64
+	unsampledOutputs []Output
+
+	relabelings        []*relabeling.Relabeling
+	relabelLabelOffset int
+	labelValues        []string
+	bytesRead          prometheus.Counter
+
+	// statusCodes are the distinct status codes seen in the source, whose
+	// number is exported as distinctStatusCodes
+	statusCodes         map[string]struct{}
+	distinctStatusCodes prometheus.Gauge
+
+	// parseErrors tracks the parse error ratio of the source; nil if no
+	// max_parse_error_ratio is configured
+	parseErrors *parseErrorRatio
+	source      string
+
+	// syslogReceived counts the lines received by a syslog source; nil for
+	// other sources
+	syslogReceived prometheus.Counter
+
+	// dedup detects duplicate lines by the value of dedupField, if set
+	dedup      *dedupCache
+	dedupField string
+
+	// graceUntil is the end of the source's startup grace period
+	graceUntil time.Time
+
+	// parseSlots holds a value for each parse that is running under the
+	// parse timeout, including abandoned ones
+	parseSlots chan struct{}
+}
+
+func newSourceProcessor(nsCfg *config.NamespaceConfig, metrics *Metrics, source string, hostname string, serverIP string) *sourceProcessor {
+	relabelings := metrics.relabelings
 
 	staticLabelValues := nsCfg.OrderedLabelValues
-	staticLabels := nsCfg.Labels //For Datadog
-	staticName := nsCfg.Name     //For Datadog
+	staticName := nsCfg.Name //For Datadog
 
 	totalLabelCount := len(staticLabelValues) + len(relabelings)
-	relabelLabelOffset := len(staticLabelValues)
 	labelValues := make([]string, totalLabelCount)
 	datadogLabels := []string{} //For Datadog
+	datadogExcluded := make(map[string]struct{}, len(nsCfg.Datadog.ExcludeLabels))
 
 	for i := range staticLabelValues {
 		labelValues[i] = staticLabelValues[i]
 	}
 	//For Datadog START
-	for k, v := range staticLabels {
-		datadogLabels = append(datadogLabels, fmt.Sprintf("%s:%s", k, v))
+	for _, l := range nsCfg.Datadog.ExcludeLabels {
+		datadogExcluded[l] = struct{}{}
+	}
+
+	// Ordered by label name, so that the tags are the same for all sources
+	for i, k := range nsCfg.OrderedLabelNames {
+		if _, ok := datadogExcluded[k]; ok {
+			continue
+		}
+
+		datadogLabels = append(datadogLabels, fmt.Sprintf("%s:%s", k, staticLabelValues[i]))
 	}
 
-	hostname, _ := os.Hostname()
-	serverIP, _ := getServerIP()
 	datadogLabels = append(datadogLabels, fmt.Sprintf("%s_hostname:%s", staticName, hostname))
 	datadogLabels = append(datadogLabels, fmt.Sprintf("%s_ip:%s", staticName, serverIP))
 	//For Datadog END
 
-	for line := range t.Lines() {
-		if nsCfg.PrintLog {
-			fmt.Println(line)
+	sampleRate := uint64(1)
+	if nsCfg.PrometheusSampleRate > 1 {
+		sampleRate = uint64(nsCfg.PrometheusSampleRate)
+	}
+
+	ddOutput := &datadogOutput{metrics: metrics, prefix: nsCfg.DatadogMetricPrefixOrDefault(), baseTags: datadogLabels, excluded: datadogExcluded}
+
+	var parseErrors *parseErrorRatio
+	if nsCfg.MaxParseErrorRatio > 0 {
+		parseErrors = newParseErrorRatio(nsCfg)
+	}
+
+	return &sourceProcessor{
+		cfg:     nsCfg,
+		parser:  logparser.NewParser(nsCfg.Format, nsCfg.Escape),
+		metrics: metrics,
+		outputs: []Output{
+			&prometheusOutput{metrics: metrics, scale: float64(sampleRate)},
+			ddOutput,
+		},
+
+		sampleRate:       sampleRate,
+		unsampledOutputs: []Output{ddOutput},
+
+		relabelings:        relabelings,
+		relabelLabelOffset: len(staticLabelValues),
+		labelValues:        labelValues,
+		bytesRead:          metrics.bytesReadTotal.WithLabelValues(source),
+
+		statusCodes:         make(map[string]struct{}),
+		distinctStatusCodes: metrics.distinctStatusCodes.WithLabelValues(source),
+
+		parseErrors: parseErrors,
+		source:      source,
+		parseSlots:  make(chan struct{}, maxConcurrentParses),
+	}
+}
+
+// observeParseResult tracks the parse error ratio of the source, marking the
+// source as down (and unhealthy) while it exceeds max_parse_error_ratio
+func (p *sourceProcessor) observeParseResult(failed bool) {
+	if p.parseErrors == nil {
+		return
+	}
+
+	exceeded, changed := p.parseErrors.observe(failed, time.Now())
+	if !changed {
+		return
+	}
+
+	up := sourceUp.WithLabelValues(p.cfg.Name, p.source)
+
+	if exceeded {
+		fmt.Printf("more than %g of the lines of source %s in namespace %s within %s could not be parsed, marking it as down\n", p.cfg.MaxParseErrorRatio, p.source, p.cfg.Name, p.cfg.ParseErrorWindowDuration)
+		unhealthySources.markUnhealthy(p.cfg.Name, p.source, "too many parse errors")
+		up.Set(0)
+	} else {
+		fmt.Printf("parse error ratio of source %s in namespace %s is back to normal\n", p.source, p.cfg.Name)
+		unhealthySources.markHealthy(p.cfg.Name, p.source)
+		up.Set(1)
+	}
+}
+
+// observeStatus adds a status code to the set of distinct status codes of the
+// source. Only three-digit values are counted, so that the set stays small
+// even if the field contains garbage.
+func (p *sourceProcessor) observeStatus(fields gonx.Fields) {
+	status, ok := fields["status"]
+	if !ok || len(status) != 3 {
+		return
+	}
+
+	for i := 0; i < len(status); i++ {
+		if status[i] < '0' || status[i] > '9' {
+			return
 		}
+	}
 
-		entry, err := parser.ParseString(line)
-		if err != nil {
-			fmt.Printf("error while parsing line '%s': %s\n", line, err)
-			metrics.parseErrorsTotal.Inc()
+	if _, seen := p.statusCodes[status]; !seen {
+		p.statusCodes[status] = struct{}{}
+		p.distinctStatusCodes.Set(float64(len(p.statusCodes)))
+	}
+}
+
+func processSource(t tail.Follower, nsMetrics *NSMetrics, fromSyslog bool) {
+	var p *sourceProcessor
+
+	hostname, _ := os.Hostname()
+	serverIP, _ := getServerIP()
+
+	nsMetrics.lock.RLock()
+	namespace := nsMetrics.cfg.Name
+	up := sourceUp.WithLabelValues(namespace, t.Source())
+	nsMetrics.lock.RUnlock()
+
+	up.Set(1)
+	defer up.Set(0)
+	defer unhealthySources.markHealthy(namespace, t.Source())
+
+	gate := pausableSources.register(namespace, t.Source())
+	defer pausableSources.deregister(namespace, t.Source(), gate)
+
+	// lastParsed is the time at which the last line of this source was
+	// parsed successfully
+	var lastParsed time.Time
+
+	// lastProcessed is the time of the last parsed line (or of the start) in
+	// Unix nanoseconds, as read by the idle watchdog
+	lastProcessed := time.Now().UnixNano()
+
+	nsMetrics.lock.RLock()
+	graceUntil := time.Now().Add(nsMetrics.cfg.StartupGraceDuration)
+	idleWarning := nsMetrics.cfg.IdleWarningDuration
+	multiline := nsMetrics.cfg.SourceData.MultilineStartRegexp != nil
+	nsMetrics.lock.RUnlock()
+
+	if idleWarning > 0 {
+		done := make(chan struct{})
+		defer close(done)
+
+		go watchIdleSource(namespace, t.Source(), idleWarning, &lastProcessed, done)
+	}
+
+	// The follower is kept running across configuration reloads, so that no
+	// line is lost or read twice. Each line is processed entirely while
+	// holding the namespace's read lock, which a reload needs to acquire for
+	// writing; every line is therefore counted exactly once, either with the
+	// old or with the new configuration.
+	lines := t.Lines()
+	if multiline {
+		lines = joinMultilineEntries(lines, nsMetrics)
+	}
+
+	for line := range lines {
+		// While paused, the follower is not read from, so that reading
+		// continues without gap once the source is resumed
+		gate.wait()
+
+		nsMetrics.lock.RLock()
+
+		// The namespace might have been reset since the last line, in which
+		// case labels and metrics need to be rebuilt from the new configuration
+		if p == nil || p.cfg != nsMetrics.cfg {
+			// The parse error ratio starts over with the new configuration
+			if p != nil && p.parseErrors != nil && p.parseErrors.exceeded {
+				unhealthySources.markHealthy(p.cfg.Name, p.source)
+				up.Set(1)
+			}
+
+			// Abandoned parses keep running across reloads
+			var parseSlots chan struct{}
+			if p != nil {
+				parseSlots = p.parseSlots
+			}
+
+			p = newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, t.Source(), hostname, serverIP)
+
+			if parseSlots != nil {
+				p.parseSlots = parseSlots
+			}
+
+			if tf, ok := t.(tail.TransportFollower); ok && nsMetrics.syslogMessagesReceivedTotal != nil {
+				p.syslogReceived = nsMetrics.syslogMessagesReceivedTotal.WithLabelValues(tf.Transport())
+			}
+
+			if fromSyslog && nsMetrics.syslogDedup != nil {
+				p.dedup = nsMetrics.syslogDedup
+				p.dedupField = nsMetrics.cfg.SourceData.Syslog.Dedup.Field
+			}
+
+			p.graceUntil = graceUntil
+		}
+
+		// Measuring the time is only worth its overhead when debugging
+		var start time.Time
+		if p.cfg.DebugMetrics {
+			start = time.Now()
+		}
+
+		parsed := p.process(line)
+
+		if p.cfg.DebugMetrics {
+			p.metrics.lineProcessingSeconds.Observe(time.Since(start).Seconds())
+		}
+
+		if parsed {
+			now := time.Now()
+			if !lastParsed.IsZero() {
+				p.metrics.interarrivalSeconds.WithLabelValues(t.Source()).Observe(now.Sub(lastParsed).Seconds())
+			}
+
+			lastParsed = now
+			if p.metrics.requestWindow != nil {
+				p.metrics.requestWindow.observe(t.Source(), now)
+			}
+
+			atomic.StoreInt64(&lastProcessed, now.UnixNano())
+		}
+
+		nsMetrics.lock.RUnlock()
+	}
+}
+
+// errParseTimeout is returned when parsing a line exceeds the parse timeout
+var errParseTimeout = errors.New("parsing took too long")
+
+// errParseBacklog is returned when a line is not parsed because too many
+// parses of earlier lines that exceeded the parse timeout are still running
+var errParseBacklog = errors.New("too many lines are still being parsed")
+
+// maxConcurrentParses is the number of parses that may be running for a
+// source at once when a parse timeout is set: the parse of the current line,
+// plus parses that exceeded the timeout but cannot be interrupted
+const maxConcurrentParses = 4
+
+// parsedLine contains the fields of a parsed log line, and the values of all
+// relabelings applied to it
+type parsedLine struct {
+	fields gonx.Fields
+	labels []relabeledValue
+}
+
+type relabeledValue struct {
+	value string
+
+	// found is false if the relabeling's source field was missing
+	found bool
+	// mapped is false if the value could not be mapped
+	mapped bool
+	// admit is true if the value still needs to pass the relabeling's Admit
+	admit bool
+}
+
+// parse parses a log line and transforms the values of all relabelings. It
+// only reads the source processor and the relabelings, so that it can safely
+// be abandoned when it takes too long; the checks of the relabelings that
+// keep state (like the limit of distinct values) are applied by process.
+func (p *sourceProcessor) parse(line string) (*parsedLine, error) {
+	entry, err := p.parser.ParseString(line)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := parsedLine{
+		fields: entry.Fields(),
+		labels: make([]relabeledValue, len(p.relabelings)),
+	}
+
+	for i := range p.relabelings {
+		// A missing source field leaves the label unset, except for actions
+		// that have a value for it
+		str, ok := p.relabelings[i].Source(parsed.fields)
+		if ok {
+			parsed.labels[i].found = true
+		} else if !p.relabelings[i].MapsMissingSource() {
 			continue
 		}
 
-		fields := entry.Fields()
-		tags := []string{}
-		for _, v := range datadogLabels {
-			tags = append(tags, v)
+		parsed.labels[i].value, parsed.labels[i].admit = p.relabelings[i].Transform(str)
+		parsed.labels[i].mapped = true
+	}
+
+	return &parsed, nil
+}
+
+// parseWithTimeout calls parse, giving up after the namespace's parse timeout.
+// Since the parsing goroutine cannot be interrupted, it keeps running in the
+// background until it finishes on its own. At most maxConcurrentParses
+// goroutines are running at once; while they are, lines are not parsed at
+// all.
+func (p *sourceProcessor) parseWithTimeout(line string) (*parsedLine, error) {
+	if p.cfg.ParseTimeoutDuration == 0 {
+		return p.parse(line)
+	}
+
+	select {
+	case p.parseSlots <- struct{}{}:
+	default:
+		return nil, errParseBacklog
+	}
+
+	type result struct {
+		parsed *parsedLine
+		err    error
+	}
+
+	// Buffered, so that an abandoned goroutine does not block forever
+	results := make(chan result, 1)
+
+	go func() {
+		defer func() { <-p.parseSlots }()
+
+		parsed, err := p.parse(line)
+		results <- result{parsed, err}
+	}()
+
+	timeout := time.NewTimer(p.cfg.ParseTimeoutDuration)
+	defer timeout.Stop()
+
+	select {
+	case r := <-results:
+		return r.parsed, r.err
+	case <-timeout.C:
+		return nil, errParseTimeout
+	}
+}
+
+// process processes a single log line. It returns true if the line was parsed
+// and counted.
+func (p *sourceProcessor) process(line string) bool {
+	nsCfg := p.cfg
+	metrics := p.metrics
+	relabelings := p.relabelings
+	labelValues := p.labelValues
+
+	if nsCfg.DebugMetrics {
+		p.bytesRead.Add(float64(len(line)))
+	}
+
+	if p.syslogReceived != nil {
+		p.syslogReceived.Inc()
+	}
+
+	if nsCfg.PrintLog {
+		fmt.Println(line)
+	}
+
+	if nsCfg.SourceData.Skip(line) {
+		return false
+	}
+
+	parsed, err := p.parseWithTimeout(line)
+	if err == errParseTimeout || err == errParseBacklog {
+		if err == errParseTimeout {
+			fmt.Printf("dropping line '%s', since parsing took longer than %s\n", line, nsCfg.ParseTimeoutDuration)
+		} else {
+			fmt.Printf("dropping line '%s', since parsing earlier lines that took longer than %s is still in progress\n", line, nsCfg.ParseTimeoutDuration)
+		}
+
+		metrics.parseTimeoutsTotal.Inc()
+		return false
+	} else if err != nil {
+		metrics.parseErrorsTotal.Inc()
+		metrics.status.observe(true, time.Now())
+		p.observeParseResult(true)
+
+		if time.Now().Before(p.graceUntil) {
+			metrics.startupParseErrorsTotal.Inc()
+		} else {
+			fmt.Printf("error while parsing line '%s': %s\n", line, err)
 		}
 
-		for i := range relabelings {
-			if str, ok := fields[relabelings[i].SourceValue]; ok {
-				mapped, err := relabelings[i].Map(str)
-				if err == nil {
-					labelValues[i+relabelLabelOffset] = mapped
-					tags = append(tags, fmt.Sprintf("%s:%s", relabelings[i].TargetLabel, mapped))
+		return false
+	}
+
+	fields := parsed.fields
+	metrics.status.observe(false, time.Now())
+	p.observeParseResult(false)
+
+	if p.dedup != nil {
+		if key := fields[p.dedupField]; key != "" && key != "-" && p.dedup.seen(key) {
+			metrics.syslogDuplicatesDroppedTotal.Inc()
+			return false
+		}
+	}
+
+	if metrics.distinctValues != nil {
+		metrics.distinctValues.observe(fields)
+	}
+
+	p.observeStatus(fields)
 
-					if relabelings[i].TargetLabel == "status" {
-						tags = append(tags, fmt.Sprintf("status_group:%sxx", mapped[0:1]))
-					}
-				}
+	labels := OutputLabels{
+		Values: labelValues,
+		Mapped: make([]Label, 0, len(relabelings)),
+	}
+
+	for i := range relabelings {
+		if l := parsed.labels[i]; l.mapped {
+			if l.admit {
+				l.value = relabelings[i].Admit(l.value)
 			}
+
+			labelValues[i+p.relabelLabelOffset] = l.value
+			labels.Mapped = append(labels.Mapped, Label{Name: relabelings[i].TargetLabel, Value: l.value})
+		} else if !l.found && nsCfg.DebugMetrics {
+			metrics.relabelUnmatchedTotal.WithLabelValues(relabelings[i].TargetLabel).Inc()
 		}
+	}
 
-		metrics.countTotal.WithLabelValues(labelValues...).Inc()
-		metrics.IncrDD(staticName+".nginx.response.count_total", tags) //For Datadog
+	for i := range metrics.customCounters {
+		metrics.customCounters[i].count(fields)
+	}
 
-		// // check datadog tags length
-		// for _, t := range tags {
-		// 	datadogTags[t] = true
-		// }
-		// if len(datadogTags) >= 400 {
-		// 	log.Printf("too many datadog tags beign created, please check, datadogTags: %v", datadogTags)
-		// 	os.Exit(0)
-		// }
+	if metrics.upstreamErrors != nil {
+		metrics.upstreamErrors.count(fields)
+	}
 
-		if bytes, ok := floatFromFields(fields, "body_bytes_sent"); ok {
-			metrics.bytesTotal.WithLabelValues(labelValues...).Add(bytes)
-			metrics.CountDD(staticName+".nginx.response.size_bytes", int64(bytes), tags) //For Datadog
+	if metrics.series != nil {
+		if evicted := metrics.series.touch(labelValues); evicted != nil {
+			metrics.deleteSeries(evicted)
+			metrics.seriesEvictedTotal.Inc()
 		}
+	}
+
+	outputs := p.sampledOutputs()
+
+	for _, o := range outputs {
+		o.Count(metricResponseCount, 1, &labels)
+	}
+
+	if nsCfg.MetricsProfile == config.MetricsProfileMinimal {
+		return true
+	}
+
+	if bytes, ok := floatFromFields(fields, "body_bytes_sent"); ok {
+		for _, o := range outputs {
+			o.Count(metricResponseSize, bytes, &labels)
 
-		if upstreamTime, ok := floatFromFields(fields, "upstream_response_time"); ok {
-			metrics.upstreamSeconds.WithLabelValues(labelValues...).Observe(upstreamTime)
-			metrics.upstreamSecondsHist.WithLabelValues(labelValues...).Observe(upstreamTime)
-			metrics.HistogramDD(staticName+".nginx.upstream.time_seconds", upstreamTime, tags) //For Datadog
+			if nsCfg.ResponseSizeHistogram {
+				o.Observe(metricResponseSizeHist, bytes, &labels)
+			}
 		}
+	}
 
-		if responseTime, ok := floatFromFields(fields, "request_time"); ok {
-			metrics.responseSeconds.WithLabelValues(labelValues...).Observe(responseTime)
-			metrics.responseSecondsHist.WithLabelValues(labelValues...).Observe(responseTime)
-			metrics.HistogramDD(staticName+".nginx.response.time_seconds", responseTime, tags) //For Datadog
+	p.observeTiming(fields, "upstream_response_time", metricUpstreamTime, &labels, outputs)
+	p.observeTiming(fields, "request_time", metricResponseTime, &labels, outputs)
+
+	for _, n := range metrics.numericMetrics {
+		value, ok := floatFromFields(fields, n.source)
+		if !ok {
+			continue
+		}
+
+		for _, o := range outputs {
+			switch n.typ {
+			case config.NumericMetricCounter:
+				o.Count(n.name, value, &labels)
+			case config.NumericMetricGauge:
+				o.Gauge(n.name, value, &labels)
+			case config.NumericMetricHistogram:
+				o.Observe(n.name, value, &labels)
+			}
 		}
 	}
+
+	return true
+}
+
+// sampledOutputs returns the outputs that the current line is emitted to: all
+// outputs for every sampleRate-th line, and only those without sampling
+// otherwise
+func (p *sourceProcessor) sampledOutputs() []Output {
+	if p.sampleRate <= 1 {
+		return p.outputs
+	}
+
+	p.sampleCounter++
+	if p.sampleCounter%p.sampleRate == 0 {
+		return p.outputs
+	}
+
+	return p.unsampledOutputs
+}
+
+// observeTiming emits a timing value from the log fields to the outputs.
+// Negative values (which may result from clock glitches) are handled according
+// to the namespace's configuration.
+func (p *sourceProcessor) observeTiming(fields gonx.Fields, field string, metric string, labels *OutputLabels, outputs []Output) {
+	value, ok := floatFromFields(fields, field)
+	if !ok {
+		if p.cfg.DebugMetrics {
+			p.metrics.timingFieldMissingTotal.WithLabelValues(field).Inc()
+		}
+		return
+	}
+
+	if value < 0 {
+		switch p.cfg.OnNegativeTiming {
+		case config.NegativeTimingClamp:
+			p.metrics.invalidTimingTotal.WithLabelValues(field).Inc()
+			value = 0
+		case config.NegativeTimingDrop:
+			p.metrics.invalidTimingTotal.WithLabelValues(field).Inc()
+			return
+		}
+	}
+
+	for _, o := range outputs {
+		o.Observe(metric, value, labels)
+	}
 }
 
 func floatFromFields(fields gonx.Fields, name string) (float64, bool) {
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+// loadNamespace loads a configuration with a single namespace and creates its
+// metrics
+func loadNamespace(t *testing.T, hcl string) *NSMetrics {
+	var cfg config.Config
+	require.Nil(t, config.LoadConfigFromStream(&cfg, strings.NewReader(hcl), config.TypeHCL))
+
+	return NewNSMetrics(&cfg.Namespaces[0], nil)
+}
+
+// metricValues gathers the metrics of a namespace and returns the values of
+// the metric with the given name by the value of one of its labels. Counters
+// and gauges are returned with their value, histograms with their sample
+// count.
+func metricValues(t *testing.T, nsMetrics *NSMetrics, name string, label string) map[string]float64 {
+	families, err := nsMetrics.Gather()
+	require.Nil(t, err)
+
+	values := make(map[string]float64)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+
+		for _, m := range f.GetMetric() {
+			key := ""
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label {
+					key = l.GetValue()
+				}
+			}
+
+			switch {
+			case m.GetCounter() != nil:
+				values[key] = m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				values[key] = m.GetGauge().GetValue()
+			case m.GetHistogram() != nil:
+				values[key] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	return values
+}
+
+const bytesReadConfig = `
+namespace "test" {
+  format = "$status \"$request\""
+}
+`
+
+const bytesReadDebugConfig = `
+namespace "test" {
+  format = "$status \"$request\""
+  debug_metrics = true
+}
+`
+
+func TestBytesReadAreCountedForUnparseableLines(t *testing.T) {
+	nsMetrics := loadNamespace(t, bytesReadDebugConfig)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	require.True(t, p.process(`200 "GET / HTTP/1.1"`))
+	require.False(t, p.process(`garbage`))
+
+	assert.Equal(t, map[string]float64{
+		"test.log": float64(len(`200 "GET / HTTP/1.1"`) + len(`garbage`)),
+	}, metricValues(t, nsMetrics, "test_log_bytes_read_total", "source"))
+}
+
+func TestBytesReadRequireDebugMetrics(t *testing.T) {
+	nsMetrics := loadNamespace(t, bytesReadConfig)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	require.True(t, p.process(`200 "GET / HTTP/1.1"`))
+
+	assert.Empty(t, metricValues(t, nsMetrics, "test_log_bytes_read_total", "source"))
+}
+
+const relabelUnmatchedConfig = `
+namespace "test" {
+  format = "$status \"$request\" \"$http_user_agent\""
+  debug_metrics = true
+
+  relabel "user_agent" {
+    from = "http_user_agent"
+  }
+
+  relabel "upstream" {
+    from = "upstream_addr"
+  }
+}
+`
+
+func TestRelabelUnmatchedCountsMissingSourceFields(t *testing.T) {
+	nsMetrics := loadNamespace(t, relabelUnmatchedConfig)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	require.True(t, p.process(`200 "GET / HTTP/1.1" "curl"`))
+	require.True(t, p.process(`404 "GET /a HTTP/1.1" "curl"`))
+
+	assert.Equal(t, map[string]float64{
+		"upstream": 2,
+	}, metricValues(t, nsMetrics, "test_relabel_unmatched_total", "target_label"))
+}
+
+func TestRelabelUnmatchedRequiresDebugMetrics(t *testing.T) {
+	nsMetrics := loadNamespace(t, strings.Replace(relabelUnmatchedConfig, "debug_metrics = true", "", 1))
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	require.True(t, p.process(`200 "GET / HTTP/1.1" "curl"`))
+
+	assert.Empty(t, metricValues(t, nsMetrics, "test_relabel_unmatched_total", "target_label"))
+}
+
+const timingFieldMissingConfig = `
+namespace "test" {
+  format = "$status \"$request\" $request_time"
+  debug_metrics = true
+}
+`
+
+func TestTimingFieldMissingCountsMissingAndInvalidFields(t *testing.T) {
+	nsMetrics := loadNamespace(t, timingFieldMissingConfig)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	require.True(t, p.process(`200 "GET / HTTP/1.1" 0.005`))
+	require.True(t, p.process(`499 "GET / HTTP/1.1" -`))
+
+	assert.Equal(t, map[string]float64{
+		"request_time":           1,
+		"upstream_response_time": 2,
+	}, metricValues(t, nsMetrics, "test_timing_field_missing_total", "field"))
+}
+
+// processLines processes lines as a source of the namespace, like a follower
+// that ends after the lines have been read
+func processLines(t *testing.T, nsMetrics *NSMetrics, lines ...string) {
+	f := &channelFollower{source: "test.log", lines: make(chan string, len(lines))}
+	for _, line := range lines {
+		f.lines <- line
+	}
+	close(f.lines)
+
+	done := make(chan struct{})
+	go func() {
+		processSource(f, nsMetrics, false)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("source was not processed completely")
+	}
+}
+
+const lineProcessingConfig = `
+namespace "test" {
+  format = "$status \"$request\""
+  debug_metrics = true
+}
+`
+
+func TestLineProcessingSecondsObservesEveryLine(t *testing.T) {
+	nsMetrics := loadNamespace(t, lineProcessingConfig)
+
+	processLines(t, nsMetrics, `200 "GET / HTTP/1.1"`, `garbage`, `404 "GET /a HTTP/1.1"`)
+
+	assert.Equal(t, map[string]float64{
+		"": 3,
+	}, metricValues(t, nsMetrics, "test_line_processing_seconds", ""))
+}
+
+func TestDistinctStatusCodesCountsValidStatusCodes(t *testing.T) {
+	nsMetrics := loadNamespace(t, bytesReadConfig)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	require.True(t, p.process(`200 "GET / HTTP/1.1"`))
+	require.True(t, p.process(`200 "GET /a HTTP/1.1"`))
+	require.True(t, p.process(`404 "GET /b HTTP/1.1"`))
+	require.True(t, p.process(`2000 "GET /c HTTP/1.1"`))
+	require.True(t, p.process(`abc "GET /d HTTP/1.1"`))
+
+	assert.Equal(t, map[string]float64{
+		"test.log": 2,
+	}, metricValues(t, nsMetrics, "test_distinct_status_codes", "source"))
+}
+
+// namespaceInfo returns the labels of the namespace info metric of a
+// namespace
+func namespaceInfo(t *testing.T, nsMetrics *NSMetrics) map[string]string {
+	families, err := nsMetrics.Gather()
+	require.Nil(t, err)
+
+	for _, f := range families {
+		if f.GetName() != "nginxlog_exporter_namespace_info" {
+			continue
+		}
+
+		require.Len(t, f.GetMetric(), 1)
+		require.Equal(t, float64(1), f.GetMetric()[0].GetGauge().GetValue())
+
+		labels := make(map[string]string)
+		for _, l := range f.GetMetric()[0].GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+
+		return labels
+	}
+
+	t.Fatal("namespace info metric was not found")
+	return nil
+}
+
+func TestNamespaceInfoDescribesTheConfiguration(t *testing.T) {
+	info := namespaceInfo(t, loadNamespace(t, relabelUnmatchedConfig))
+
+	assert.Equal(t, "test", info["namespace"])
+	assert.Equal(t, "2", info["relabel_count"])
+	assert.Equal(t, formatHash(`$status "$request" "$http_user_agent"`), info["format_hash"])
+
+	other := namespaceInfo(t, loadNamespace(t, timingFieldMissingConfig))
+
+	assert.Equal(t, "0", other["relabel_count"])
+	assert.NotEqual(t, info["format_hash"], other["format_hash"])
+}
+
+const sampleRateConfig = `
+namespace "test" {
+  format = "$status $body_bytes_sent $request_time"
+  prometheus_sample_rate = 4
+}
+`
+
+func TestSampledCountersAreScaledBySampleRate(t *testing.T) {
+	nsMetrics := loadNamespace(t, sampleRateConfig)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	for i := 0; i < 8; i++ {
+		require.True(t, p.process(`200 100 0.1`))
+	}
+
+	// Every 4th line is sampled, and counts 4 times
+	assert.Equal(t, map[string]float64{"200": 8}, metricValues(t, nsMetrics, "test_http_response_count_total", "status"))
+	assert.Equal(t, map[string]float64{"200": 800}, metricValues(t, nsMetrics, "test_http_response_size_bytes", "status"))
+
+	// Histograms only observe the sampled lines
+	assert.Equal(t, map[string]float64{"200": 2}, metricValues(t, nsMetrics, "test_http_response_time_seconds_hist", "status"))
+}
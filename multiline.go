@@ -0,0 +1,104 @@
+package main
+
+import (
+	"regexp"
+	"time"
+)
+
+// multilineFlushTimeout is the time after which a multi-line entry is
+// processed if no further line was read, since its end is only known once the
+// next entry starts
+const multilineFlushTimeout = time.Second
+
+// multilineJoiner joins the continuation lines of multi-line entries (like
+// embedded stack traces) to the line that started the entry
+type multilineJoiner struct {
+	pending    string
+	hasPending bool
+}
+
+// add adds a line, and returns the previous entry if the line starts a new
+// one. Lines that do not match start are appended to the pending entry, unless
+// this would make it longer than maxBytes, in which case they are dropped.
+// Without start pattern, every line is an entry of its own.
+func (j *multilineJoiner) add(line string, start *regexp.Regexp, maxBytes int) (string, bool) {
+	if j.hasPending && start != nil && !start.MatchString(line) {
+		if len(j.pending)+1+len(line) <= maxBytes {
+			j.pending += "\n" + line
+		}
+
+		return "", false
+	}
+
+	entry, ok := j.flush()
+
+	j.pending = line
+	j.hasPending = true
+
+	return entry, ok
+}
+
+// flush returns the pending entry, if any
+func (j *multilineJoiner) flush() (string, bool) {
+	if !j.hasPending {
+		return "", false
+	}
+
+	entry := j.pending
+	j.pending = ""
+	j.hasPending = false
+
+	return entry, true
+}
+
+// joinMultilineEntries reads the lines of a log source and emits its entries,
+// joining continuation lines according to the namespace's current
+// multiline_start_regex. The returned channel is closed after the lines have
+// been read.
+func joinMultilineEntries(lines chan string, nsMetrics *NSMetrics) chan string {
+	entries := make(chan string)
+
+	go func() {
+		defer close(entries)
+
+		var joiner multilineJoiner
+
+		flushTimer := time.NewTimer(multilineFlushTimeout)
+		defer flushTimer.Stop()
+
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					if entry, ok := joiner.flush(); ok {
+						entries <- entry
+					}
+					return
+				}
+
+				nsMetrics.lock.RLock()
+				start := nsMetrics.cfg.SourceData.MultilineStartRegexp
+				maxBytes := nsMetrics.cfg.SourceData.MaxLineBytesOrDefault()
+				nsMetrics.lock.RUnlock()
+
+				if entry, ok := joiner.add(line, start, maxBytes); ok {
+					entries <- entry
+				}
+
+				if !flushTimer.Stop() {
+					select {
+					case <-flushTimer.C:
+					default:
+					}
+				}
+				flushTimer.Reset(multilineFlushTimeout)
+			case <-flushTimer.C:
+				if entry, ok := joiner.flush(); ok {
+					entries <- entry
+				}
+			}
+		}
+	}()
+
+	return entries
+}
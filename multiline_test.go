@@ -0,0 +1,52 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultilineJoinerJoinsContinuationLines(t *testing.T) {
+	start := regexp.MustCompile(`^\d+\.\d+\.\d+\.\d+ `)
+	var j multilineJoiner
+
+	_, ok := j.add("1.2.3.4 - - GET /a 500", start, 1024)
+	assert.False(t, ok)
+
+	_, ok = j.add("  at Foo.bar(Foo.java:1)", start, 1024)
+	assert.False(t, ok)
+
+	entry, ok := j.add("1.2.3.5 - - GET /b 200", start, 1024)
+	assert.True(t, ok)
+	assert.Equal(t, "1.2.3.4 - - GET /a 500\n  at Foo.bar(Foo.java:1)", entry)
+
+	entry, ok = j.flush()
+	assert.True(t, ok)
+	assert.Equal(t, "1.2.3.5 - - GET /b 200", entry)
+
+	_, ok = j.flush()
+	assert.False(t, ok)
+}
+
+func TestMultilineJoinerDropsLinesBeyondMaxBytes(t *testing.T) {
+	start := regexp.MustCompile(`^start`)
+	var j multilineJoiner
+
+	j.add("start", start, 12)
+	j.add("12345", start, 12)
+	j.add("67890", start, 12)
+
+	entry, _ := j.flush()
+	assert.Equal(t, "start\n12345", entry)
+}
+
+func TestMultilineJoinerWithoutStartPattern(t *testing.T) {
+	var j multilineJoiner
+
+	j.add("first", nil, 1024)
+	entry, ok := j.add("  second", nil, 1024)
+
+	assert.True(t, ok)
+	assert.Equal(t, "first", entry)
+}
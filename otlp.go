@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// otlpServiceName is reported as "service.name" resource attribute and as
+// instrumentation scope of all exported metrics
+const otlpServiceName = "prometheus-nginxlog-exporter"
+
+// otlpSeries is the state of a cumulative series between two exports
+type otlpSeries struct {
+	start int64
+	last  float64
+}
+
+// otlpStartTimes tracks the start times of the cumulative series. A series
+// starts when the exporter is started, or, if it appears later, with the
+// previous export. When its value decreases, the series was reset (like by a
+// configuration reload) after the previous export, and starts over then.
+type otlpStartTimes struct {
+	series   map[string]*otlpSeries
+	previous int64
+
+	// seen are the series of the current export; the others are dropped
+	seen map[string]*otlpSeries
+}
+
+func newOTLPStartTimes(start time.Time) *otlpStartTimes {
+	return &otlpStartTimes{
+		series:   make(map[string]*otlpSeries),
+		previous: start.UnixNano(),
+		seen:     make(map[string]*otlpSeries),
+	}
+}
+
+// observe returns the start time of a series with the given cumulative value
+func (t *otlpStartTimes) observe(name string, labels []*dto.LabelPair, value float64) time.Time {
+	key := name
+	for _, l := range labels {
+		key += "\xff" + l.GetName() + "\xff" + l.GetValue()
+	}
+
+	s, ok := t.series[key]
+	if !ok || value < s.last {
+		s = &otlpSeries{start: t.previous}
+	}
+
+	s.last = value
+	t.seen[key] = s
+
+	return time.Unix(0, s.start)
+}
+
+// done finishes an export at the given time, forgetting the series that were
+// not part of it
+func (t *otlpStartTimes) done(now time.Time) {
+	t.series = t.seen
+	t.seen = make(map[string]*otlpSeries, len(t.series))
+	t.previous = now.UnixNano()
+}
+
+// otlpExporter periodically pushes the gathered metrics to an OpenTelemetry
+// collector using OTLP over gRPC. All metrics are exported as cumulative
+// values, just like they are scraped by Prometheus.
+type otlpExporter struct {
+	endpoint string
+	interval time.Duration
+	gatherer prometheus.Gatherer
+	exporter *otlpmetricgrpc.Exporter
+	resource *resource.Resource
+	start    time.Time
+	starts   *otlpStartTimes
+}
+
+func newOTLPExporter(cfg *config.OTLPConfig, gatherer prometheus.Gatherer) (*otlpExporter, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("otlp endpoint must not be empty")
+	}
+
+	interval, err := cfg.IntervalOrDefault()
+	if err != nil {
+		return nil, err
+	}
+
+	options := []otlpmetricgrpc.Option{
+		otlpmetricgrpc.WithHeaders(cfg.Headers),
+		otlpmetricgrpc.WithTimeout(interval),
+	}
+
+	// The endpoint is either an address (like "collector:4317"), or a URL
+	// whose scheme selects whether TLS is used
+	if strings.Contains(cfg.Endpoint, "://") {
+		u, err := url.Parse(cfg.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("otlp endpoint must be an address like otel-collector:4317 or an http:// or https:// URL, got '%s'", cfg.Endpoint)
+		}
+
+		options = append(options, otlpmetricgrpc.WithEndpointURL(cfg.Endpoint))
+	} else {
+		options = append(options, otlpmetricgrpc.WithEndpoint(cfg.Endpoint))
+	}
+
+	if cfg.Insecure {
+		options = append(options, otlpmetricgrpc.WithInsecure())
+	}
+
+	// The connection is established lazily, so this does not fail if the
+	// collector is not reachable yet
+	exporter, err := otlpmetricgrpc.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+
+	return &otlpExporter{
+		endpoint: cfg.Endpoint,
+		interval: interval,
+		gatherer: gatherer,
+		exporter: exporter,
+		resource: resource.NewSchemaless(attribute.String("service.name", otlpServiceName)),
+		start:    start,
+		starts:   newOTLPStartTimes(start),
+	}, nil
+}
+
+// run exports the metrics at every interval until stopChan is closed, and a
+// last time before returning, so that the final interval is not lost
+func (e *otlpExporter) run(stopChan <-chan bool) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			e.exportAndLog()
+			e.shutdown()
+			return
+		case <-ticker.C:
+			e.exportAndLog()
+		}
+	}
+}
+
+func (e *otlpExporter) exportAndLog() {
+	if err := e.export(); err != nil {
+		fmt.Printf("error while exporting metrics via OTLP: %s\n", err.Error())
+	}
+}
+
+func (e *otlpExporter) export() error {
+	families, err := e.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return err
+	}
+
+	now := time.Now()
+	metrics := otlpResourceMetrics(families, e.resource, e.start, e.starts, now)
+	e.starts.done(now)
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+	defer cancel()
+
+	return e.exporter.Export(ctx, metrics)
+}
+
+// shutdown closes the connection to the collector
+func (e *otlpExporter) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+	defer cancel()
+
+	if err := e.exporter.Shutdown(ctx); err != nil {
+		fmt.Printf("error while closing the OTLP exporter: %s\n", err.Error())
+	}
+}
+
+// otlpResourceMetrics converts gathered metric families into OTLP metrics.
+// Gauges start with the exporter, cumulative series as tracked by starts.
+func otlpResourceMetrics(families []*dto.MetricFamily, res *resource.Resource, start time.Time, starts *otlpStartTimes, now time.Time) *metricdata.ResourceMetrics {
+	metrics := make([]metricdata.Metrics, 0, len(families))
+
+	for _, f := range families {
+		m := metricdata.Metrics{Name: f.GetName(), Description: f.GetHelp()}
+
+		switch f.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+			for _, s := range f.Metric {
+				sum.DataPoints = append(sum.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: otlpAttributes(s.Label),
+					StartTime:  starts.observe(f.GetName(), s.Label, s.GetCounter().GetValue()),
+					Time:       now,
+					Value:      s.GetCounter().GetValue(),
+				})
+			}
+			m.Data = sum
+		case dto.MetricType_HISTOGRAM:
+			histogram := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+			for _, s := range f.Metric {
+				seriesStart := starts.observe(f.GetName(), s.Label, float64(s.GetHistogram().GetSampleCount()))
+				histogram.DataPoints = append(histogram.DataPoints, otlpHistogramPoint(s, seriesStart, now))
+			}
+			m.Data = histogram
+		case dto.MetricType_SUMMARY:
+			var summary metricdata.Summary
+			for _, s := range f.Metric {
+				point := metricdata.SummaryDataPoint{
+					Attributes: otlpAttributes(s.Label),
+					StartTime:  starts.observe(f.GetName(), s.Label, float64(s.GetSummary().GetSampleCount())),
+					Time:       now,
+					Count:      s.GetSummary().GetSampleCount(),
+					Sum:        s.GetSummary().GetSampleSum(),
+				}
+
+				for _, q := range s.GetSummary().Quantile {
+					point.QuantileValues = append(point.QuantileValues, metricdata.QuantileValue{Quantile: q.GetQuantile(), Value: q.GetValue()})
+				}
+
+				summary.DataPoints = append(summary.DataPoints, point)
+			}
+			m.Data = summary
+		default:
+			var gauge metricdata.Gauge[float64]
+			for _, s := range f.Metric {
+				value := s.GetGauge().GetValue()
+				if f.GetType() == dto.MetricType_UNTYPED {
+					value = s.GetUntyped().GetValue()
+				}
+
+				gauge.DataPoints = append(gauge.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: otlpAttributes(s.Label),
+					StartTime:  start,
+					Time:       now,
+					Value:      value,
+				})
+			}
+			m.Data = gauge
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return &metricdata.ResourceMetrics{
+		Resource: res,
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Scope:   instrumentation.Scope{Name: otlpServiceName},
+			Metrics: metrics,
+		}},
+	}
+}
+
+// otlpHistogramPoint converts a Prometheus histogram, whose buckets count all
+// observations up to their upper bound, into an OTLP histogram data point,
+// whose buckets only count the observations between two bounds
+func otlpHistogramPoint(s *dto.Metric, start time.Time, now time.Time) metricdata.HistogramDataPoint[float64] {
+	h := s.GetHistogram()
+
+	point := metricdata.HistogramDataPoint[float64]{
+		Attributes: otlpAttributes(s.Label),
+		StartTime:  start,
+		Time:       now,
+		Count:      h.GetSampleCount(),
+		Sum:        h.GetSampleSum(),
+	}
+
+	var previous uint64
+
+	for _, b := range h.Bucket {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			continue
+		}
+
+		point.Bounds = append(point.Bounds, b.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, b.GetCumulativeCount()-previous)
+		previous = b.GetCumulativeCount()
+	}
+
+	// The last bucket counts all observations above the highest bound
+	point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-previous)
+
+	return point
+}
+
+func otlpAttributes(labels []*dto.LabelPair) attribute.Set {
+	attributes := make([]attribute.KeyValue, len(labels))
+	for i, l := range labels {
+		attributes[i] = attribute.String(l.GetName(), l.GetValue())
+	}
+
+	return attribute.NewSet(attributes...)
+}
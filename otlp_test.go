@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func otlpTestRegistry() (*prometheus.Registry, prometheus.Counter) {
+	registry := prometheus.NewRegistry()
+
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_requests_total", Help: "Requests", ConstLabels: prometheus.Labels{"status": "200"}})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_sources", Help: "Sources"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "Seconds", Buckets: []float64{0.1, 1}})
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "test_size", Help: "Size", Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01}})
+
+	registry.MustRegister(counter, gauge, histogram, summary)
+
+	counter.Add(3)
+	gauge.Set(2)
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+
+	return registry, counter
+}
+
+// otlpTestCollector is an OTLP/gRPC metrics receiver that records the export
+// requests and their metadata, and fails them with err if set
+type otlpTestCollector struct {
+	collectorpb.UnimplementedMetricsServiceServer
+
+	lock     sync.Mutex
+	requests []*collectorpb.ExportMetricsServiceRequest
+	metadata []metadata.MD
+	err      error
+}
+
+func (c *otlpTestCollector) Export(ctx context.Context, req *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.requests = append(c.requests, req)
+	c.metadata = append(c.metadata, md)
+
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (c *otlpTestCollector) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.err = err
+}
+
+func (c *otlpTestCollector) exports() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.requests)
+}
+
+// startOTLPTestCollector starts a collector on a random local port, and returns
+// it with its address
+func startOTLPTestCollector(t *testing.T) (*otlpTestCollector, string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	collector := &otlpTestCollector{}
+	server := grpc.NewServer()
+	collectorpb.RegisterMetricsServiceServer(server, collector)
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	return collector, listener.Addr().String(), server.Stop
+}
+
+func TestOTLPResourceMetricsConvertsAllTypes(t *testing.T) {
+	registry, _ := otlpTestRegistry()
+
+	families, err := registry.Gather()
+	require.Nil(t, err)
+
+	start := time.Unix(1000, 0)
+	now := time.Unix(1060, 0)
+	rm := otlpResourceMetrics(families, resource.Empty(), start, newOTLPStartTimes(start), now)
+
+	require.Len(t, rm.ScopeMetrics, 1)
+	assert.Equal(t, otlpServiceName, rm.ScopeMetrics[0].Scope.Name)
+
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m.Data
+	}
+
+	sum, ok := metrics["test_requests_total"].(metricdata.Sum[float64])
+	require.True(t, ok)
+	assert.True(t, sum.IsMonotonic)
+	assert.Equal(t, metricdata.CumulativeTemporality, sum.Temporality)
+	assert.Equal(t, float64(3), sum.DataPoints[0].Value)
+	assert.Equal(t, start, sum.DataPoints[0].StartTime)
+	assert.Equal(t, now, sum.DataPoints[0].Time)
+
+	value, ok := sum.DataPoints[0].Attributes.Value(attribute.Key("status"))
+	require.True(t, ok)
+	assert.Equal(t, "200", value.AsString())
+
+	gauge, ok := metrics["test_sources"].(metricdata.Gauge[float64])
+	require.True(t, ok)
+	assert.Equal(t, float64(2), gauge.DataPoints[0].Value)
+
+	// One bucket more than bounds, counting the observations above the highest
+	histogram, ok := metrics["test_seconds"].(metricdata.Histogram[float64])
+	require.True(t, ok)
+	assert.Equal(t, metricdata.CumulativeTemporality, histogram.Temporality)
+	assert.Equal(t, []float64{0.1, 1}, histogram.DataPoints[0].Bounds)
+	assert.Equal(t, []uint64{1, 1, 1}, histogram.DataPoints[0].BucketCounts)
+	assert.Equal(t, uint64(3), histogram.DataPoints[0].Count)
+
+	summary, ok := metrics["test_size"].(metricdata.Summary)
+	require.True(t, ok)
+	assert.Len(t, summary.DataPoints[0].QuantileValues, 2)
+}
+
+func TestOTLPStartTimesFollowResets(t *testing.T) {
+	label := []*dto.LabelPair{{Name: stringPtr("status"), Value: stringPtr("200")}}
+	starts := newOTLPStartTimes(time.Unix(0, 100))
+
+	assert.Equal(t, time.Unix(0, 100), starts.observe("requests", label, 5))
+	starts.done(time.Unix(0, 200))
+
+	assert.Equal(t, time.Unix(0, 100), starts.observe("requests", label, 7))
+	assert.Equal(t, time.Unix(0, 200), starts.observe("errors", label, 1), "series that appear later start with the previous export")
+	starts.done(time.Unix(0, 300))
+
+	assert.Equal(t, time.Unix(0, 300), starts.observe("requests", label, 2), "a decreased value is a reset")
+	starts.done(time.Unix(0, 400))
+
+	// A series missing from an export (like after a namespace reset) starts
+	// over when it appears again
+	starts.done(time.Unix(0, 500))
+	assert.Equal(t, time.Unix(0, 500), starts.observe("requests", label, 10))
+}
+
+func TestOTLPExporterSendsToCollector(t *testing.T) {
+	collector, address, stop := startOTLPTestCollector(t)
+	defer stop()
+
+	registry, counter := otlpTestRegistry()
+
+	exporter, err := newOTLPExporter(&config.OTLPConfig{
+		Endpoint: address,
+		Insecure: true,
+		Headers:  map[string]string{"authorization": "Bearer token"},
+	}, registry)
+	require.Nil(t, err)
+	defer exporter.shutdown()
+
+	require.Nil(t, exporter.export())
+	counter.Add(1)
+	require.Nil(t, exporter.export())
+
+	require.Equal(t, 2, collector.exports())
+
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+
+	assert.Equal(t, []string{"Bearer token"}, collector.metadata[0].Get("authorization"))
+
+	values := make([]float64, 0, 2)
+	for _, req := range collector.requests {
+		for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+			if m.Name == "test_requests_total" {
+				values = append(values, m.GetSum().DataPoints[0].GetAsDouble())
+			}
+		}
+	}
+	assert.Equal(t, []float64{3, 4}, values)
+}
+
+func TestOTLPExporterReportsRejectedExports(t *testing.T) {
+	collector, address, stop := startOTLPTestCollector(t)
+	defer stop()
+
+	registry, _ := otlpTestRegistry()
+
+	exporter, err := newOTLPExporter(&config.OTLPConfig{Endpoint: address, Insecure: true}, registry)
+	require.Nil(t, err)
+	defer exporter.shutdown()
+
+	collector.fail(status.Error(codes.InvalidArgument, "rejected"))
+	assert.NotNil(t, exporter.export())
+}
+
+func TestOTLPExporterExportsOnStop(t *testing.T) {
+	collector, address, stop := startOTLPTestCollector(t)
+	defer stop()
+
+	registry, _ := otlpTestRegistry()
+
+	exporter, err := newOTLPExporter(&config.OTLPConfig{Endpoint: "http://" + address, Interval: "1h"}, registry)
+	require.Nil(t, err)
+
+	stopChan := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		exporter.run(stopChan)
+		close(done)
+	}()
+
+	close(stopChan)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("exporter did not stop")
+	}
+
+	assert.Equal(t, 1, collector.exports())
+}
+
+func TestOTLPExporterRejectsInvalidEndpoints(t *testing.T) {
+	registry, _ := otlpTestRegistry()
+
+	for _, endpoint := range []string{"", "ftp://otel-collector:4317", "http://"} {
+		_, err := newOTLPExporter(&config.OTLPConfig{Endpoint: endpoint}, registry)
+		assert.NotNil(t, err, endpoint)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Names of the metrics that are emitted for each processed log line
+const (
+	metricResponseCount    = "http_response_count_total"
+	metricResponseSize     = "http_response_size_bytes"
+	metricResponseSizeHist = "http_response_size_bytes_hist"
+	metricUpstreamTime     = "http_upstream_time_seconds"
+	metricResponseTime     = "http_response_time_seconds"
+)
+
+// Label is a single label name and value
+type Label struct {
+	Name  string
+	Value string
+}
+
+// OutputLabels describes the labels of a value emitted to an Output
+type OutputLabels struct {
+	// Values contains the values of all labels of the namespace's metrics
+	// (static labels first, followed by the relabeled ones)
+	Values []string
+
+	// Mapped contains only the relabeled labels that could be mapped from
+	// the current log line
+	Mapped []Label
+}
+
+// Output receives the values extracted from processed log lines. Outputs may
+// ignore metric names that they do not know.
+type Output interface {
+	Count(name string, value float64, labels *OutputLabels)
+	Observe(name string, value float64, labels *OutputLabels)
+	Gauge(name string, value float64, labels *OutputLabels)
+}
+
+// prometheusOutput updates a namespace's Prometheus metrics
+type prometheusOutput struct {
+	metrics *Metrics
+
+	// scale multiplies all counter increments; this compensates for lines
+	// that are left out when sampling
+	scale float64
+}
+
+func (o *prometheusOutput) Count(name string, value float64, labels *OutputLabels) {
+	value *= o.scale
+
+	switch name {
+	case metricResponseCount:
+		o.metrics.countTotal.WithLabelValues(labels.Values...).Add(value)
+	case metricResponseSize:
+		o.metrics.bytesTotal.WithLabelValues(labels.Values...).Add(value)
+	default:
+		o.numeric(name, value, labels)
+	}
+}
+
+func (o *prometheusOutput) Observe(name string, value float64, labels *OutputLabels) {
+	switch name {
+	case metricUpstreamTime:
+		o.metrics.upstreamSeconds.WithLabelValues(labels.Values...).Observe(value)
+		o.metrics.upstreamSecondsHist.WithLabelValues(labels.Values...).Observe(value)
+	case metricResponseTime:
+		o.metrics.responseSeconds.WithLabelValues(labels.Values...).Observe(value)
+		o.metrics.responseSecondsHist.WithLabelValues(labels.Values...).Observe(value)
+	case metricResponseSizeHist:
+		o.metrics.responseSizeHist.WithLabelValues(labels.Values...).Observe(value)
+	default:
+		o.numeric(name, value, labels)
+	}
+}
+
+func (o *prometheusOutput) Gauge(name string, value float64, labels *OutputLabels) {
+	o.numeric(name, value, labels)
+}
+
+func (o *prometheusOutput) numeric(name string, value float64, labels *OutputLabels) {
+	for _, n := range o.metrics.numericMetrics {
+		if n.name == name {
+			n.observe(labels.Values, value)
+			return
+		}
+	}
+}
+
+// datadogOutput sends the values of the well-known metrics to Datadog
+type datadogOutput struct {
+	metrics  *Metrics
+	prefix   string
+	baseTags []string
+
+	// excluded contains the names of labels that are not sent as tags
+	excluded map[string]struct{}
+}
+
+func (o *datadogOutput) Count(name string, value float64, labels *OutputLabels) {
+	switch name {
+	case metricResponseCount:
+		o.metrics.IncrDD(o.prefix+".nginx.response.count_total", o.tags(labels))
+	case metricResponseSize:
+		o.metrics.CountDD(o.prefix+".nginx.response.size_bytes", int64(value), o.tags(labels))
+	}
+}
+
+func (o *datadogOutput) Observe(name string, value float64, labels *OutputLabels) {
+	switch name {
+	case metricUpstreamTime:
+		o.metrics.HistogramDD(o.prefix+".nginx.upstream.time_seconds", value, o.tags(labels))
+	case metricResponseTime:
+		o.metrics.HistogramDD(o.prefix+".nginx.response.time_seconds", value, o.tags(labels))
+	}
+}
+
+func (o *datadogOutput) Gauge(name string, value float64, labels *OutputLabels) {
+}
+
+func (o *datadogOutput) tags(labels *OutputLabels) []string {
+	tags := make([]string, 0, len(o.baseTags)+len(labels.Mapped)+1)
+	tags = append(tags, o.baseTags...)
+
+	for _, l := range labels.Mapped {
+		if _, ok := o.excluded[l.Name]; !ok {
+			tags = append(tags, fmt.Sprintf("%s:%s", l.Name, l.Value))
+		}
+
+		if _, ok := o.excluded["status_group"]; !ok && l.Name == "status" {
+			tags = append(tags, "status_group:"+statusClass(l.Value))
+		}
+	}
+
+	return tags
+}
+
+// statusClass returns the class of an HTTP status code (like "4xx" for 404)
+func statusClass(status string) string {
+	if status == "" {
+		return "unknown"
+	}
+
+	return status[0:1] + "xx"
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satyrius/gonx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingParser is a parser that does not return before it is released for
+// lines containing block
+type blockingParser struct {
+	parser  lineParser
+	block   string
+	release chan struct{}
+}
+
+func (b *blockingParser) ParseString(line string) (*gonx.Entry, error) {
+	if strings.Contains(line, b.block) {
+		<-b.release
+	}
+
+	return b.parser.ParseString(line)
+}
+
+const parseTimeoutConfig = `
+namespace "test" {
+  format = "$status \"$http_user_agent\""
+  parse_timeout = "50ms"
+
+  relabel "user_agent" {
+    from = "http_user_agent"
+    max_values = 1
+  }
+}
+`
+
+func TestLinesExceedingTheParseTimeoutAreDropped(t *testing.T) {
+	nsMetrics := loadNamespace(t, parseTimeoutConfig)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	blocking := &blockingParser{parser: p.parser, block: "curl", release: make(chan struct{})}
+	p.parser = blocking
+
+	// Once all parse slots are taken by abandoned parses, further lines are
+	// dropped right away
+	for i := 0; i < maxConcurrentParses+2; i++ {
+		require.False(t, p.process(`200 "curl"`))
+	}
+
+	assert.Equal(t, map[string]float64{
+		"": maxConcurrentParses + 2,
+	}, metricValues(t, nsMetrics, "test_parse_timeouts_total", ""))
+	assert.Empty(t, metricValues(t, nsMetrics, "test_http_response_count_total", "user_agent"))
+
+	close(blocking.release)
+	assert.Eventually(t, func() bool { return len(p.parseSlots) == 0 }, time.Second, time.Millisecond)
+
+	// The abandoned lines must not have taken the only distinct value
+	require.True(t, p.process(`200 "wget"`))
+
+	assert.Equal(t, map[string]float64{
+		"wget": 1,
+	}, metricValues(t, nsMetrics, "test_http_response_count_total", "user_agent"))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// pauseGate holds back the lines of a log source while it is paused. Since
+// the follower is not read from while paused, no line is lost, and reading
+// resumes where it stopped.
+type pauseGate struct {
+	paused int32
+
+	lock sync.Mutex
+	// resumed is closed when the source is resumed
+	resumed chan struct{}
+}
+
+// pause pauses the source; it returns false if it was already paused
+func (g *pauseGate) pause() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if atomic.LoadInt32(&g.paused) == 1 {
+		return false
+	}
+
+	g.resumed = make(chan struct{})
+	atomic.StoreInt32(&g.paused, 1)
+
+	return true
+}
+
+// resume resumes the source; it returns false if it was not paused
+func (g *pauseGate) resume() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if atomic.LoadInt32(&g.paused) == 0 {
+		return false
+	}
+
+	atomic.StoreInt32(&g.paused, 0)
+	close(g.resumed)
+
+	return true
+}
+
+// wait blocks while the source is paused
+func (g *pauseGate) wait() {
+	if atomic.LoadInt32(&g.paused) == 0 {
+		return
+	}
+
+	g.lock.Lock()
+	resumed := g.resumed
+	g.lock.Unlock()
+
+	if resumed != nil {
+		<-resumed
+	}
+}
+
+// sourceGates holds the pause gates of all running log sources
+type sourceGates struct {
+	lock  sync.Mutex
+	gates map[string]map[string]*pauseGate
+}
+
+var pausableSources = &sourceGates{gates: make(map[string]map[string]*pauseGate)}
+
+func (s *sourceGates) register(namespace string, source string) *pauseGate {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.gates[namespace] == nil {
+		s.gates[namespace] = make(map[string]*pauseGate)
+	}
+
+	g := &pauseGate{}
+	s.gates[namespace][source] = g
+
+	return g
+}
+
+func (s *sourceGates) deregister(namespace string, source string, g *pauseGate) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.gates[namespace][source] == g {
+		delete(s.gates[namespace], source)
+	}
+
+	// A source that ends while paused must not block anything
+	g.resume()
+}
+
+// count returns the number of running sources of a namespace, and how many of
+// them are paused
+func (s *sourceGates) count(namespace string) (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	paused := 0
+	for _, g := range s.gates[namespace] {
+		if atomic.LoadInt32(&g.paused) == 1 {
+			paused++
+		}
+	}
+
+	return len(s.gates[namespace]), paused
+}
+
+// find returns the gates of a source in the given namespace, or in all
+// namespaces if namespace is empty, sorted by namespace
+func (s *sourceGates) find(namespace string, source string) []*pauseGate {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	names := make([]string, 0, len(s.gates))
+	for name := range s.gates {
+		if namespace == "" || name == namespace {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var gates []*pauseGate
+	for _, name := range names {
+		if g, ok := s.gates[name][source]; ok {
+			gates = append(gates, g)
+		}
+	}
+
+	return gates
+}
+
+// authorizedPost wraps a handler that only accepts POST requests carrying the
+// token as bearer token
+func authorizedPost(token string, handler http.HandlerFunc) http.Handler {
+	expectedAuth := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expectedAuth) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
+// pauseHandler pauses (or resumes, if pause is false) the log source given by
+// the "source" query parameter, in the namespace given by the optional
+// "namespace" parameter or in all namespaces
+func pauseHandler(gates *sourceGates, token string, pause bool) http.Handler {
+	action := "resumed"
+	if pause {
+		action = "paused"
+	}
+
+	return authorizedPost(token, func(w http.ResponseWriter, r *http.Request) {
+		source := r.URL.Query().Get("source")
+		namespace := r.URL.Query().Get("namespace")
+
+		if source == "" {
+			http.Error(w, "the source parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		matched := gates.find(namespace, source)
+		if len(matched) == 0 {
+			http.Error(w, fmt.Sprintf("no running source %s", source), http.StatusNotFound)
+			return
+		}
+
+		changed := 0
+		for _, g := range matched {
+			if (pause && g.pause()) || (!pause && g.resume()) {
+				changed++
+			}
+		}
+
+		fmt.Printf("%s source %s in %d namespace(s)\n", action, source, changed)
+		fmt.Fprintf(w, "%s source %s in %d namespace(s)\n", action, source, changed)
+	})
+}
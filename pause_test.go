@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPauseGateBlocksUntilResumed(t *testing.T) {
+	g := &pauseGate{}
+	g.wait()
+
+	assert.True(t, g.pause())
+	assert.False(t, g.pause())
+
+	waited := make(chan struct{})
+	go func() {
+		g.wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("wait returned while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.True(t, g.resume())
+	assert.False(t, g.resume())
+
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("wait did not return after resume")
+	}
+}
+
+func TestPauseHandler(t *testing.T) {
+	gates := &sourceGates{gates: make(map[string]map[string]*pauseGate)}
+	g := gates.register("app", "/var/log/nginx/access.log")
+
+	pause := pauseHandler(gates, "secret", true)
+	resume := pauseHandler(gates, "secret", false)
+
+	request := func(handler http.Handler, query string, auth string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/-/pause?"+query, nil)
+		req.Header.Set("Authorization", auth)
+		handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request(pause, "source=/var/log/nginx/access.log", "Bearer wrong"))
+	assert.Equal(t, http.StatusBadRequest, request(pause, "", "Bearer secret"))
+	assert.Equal(t, http.StatusNotFound, request(pause, "source=/var/log/other.log", "Bearer secret"))
+	assert.Equal(t, http.StatusNotFound, request(pause, "source=/var/log/nginx/access.log&namespace=other", "Bearer secret"))
+
+	assert.Equal(t, http.StatusOK, request(pause, "source=/var/log/nginx/access.log", "Bearer secret"))
+	assert.Equal(t, int32(1), g.paused)
+
+	assert.Equal(t, http.StatusOK, request(resume, "source=/var/log/nginx/access.log&namespace=app", "Bearer secret"))
+	assert.Equal(t, int32(0), g.paused)
+}
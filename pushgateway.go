@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+// pushgatewayNamespaceLabel is the grouping label holding the namespace name
+const pushgatewayNamespaceLabel = "nginxlog_namespace"
+
+// pushgatewayPusher periodically pushes the metrics of each namespace to a
+// Prometheus Pushgateway. The metrics of each namespace are pushed as separate
+// group, which is identified by the configured grouping labels and an
+// additional "nginxlog_namespace" label. The Pushgateway rejects pushes whose
+// metrics carry a grouping label, so that label must not be one that the
+// metrics have (like the "namespace" label of the namespace info metric).
+type pushgatewayPusher struct {
+	url      string
+	interval time.Duration
+	pushers  map[string]*push.Pusher
+}
+
+func newPushgatewayPusher(cfg *config.PushgatewayConfig, gatherers []*namespaceGatherer) (*pushgatewayPusher, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("pushgateway url must not be empty")
+	}
+
+	interval, err := cfg.IntervalOrDefault()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := cfg.Grouping[pushgatewayNamespaceLabel]; ok {
+		return nil, fmt.Errorf("pushgateway grouping must not contain the '%s' label", pushgatewayNamespaceLabel)
+	}
+
+	client, err := pushgatewayClient(cfg.TLS, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pushgatewayPusher{
+		url:      cfg.URL,
+		interval: interval,
+		pushers:  make(map[string]*push.Pusher, len(gatherers)),
+	}
+
+	for _, g := range gatherers {
+		pusher := push.New(cfg.URL, cfg.JobOrDefault()).
+			Gatherer(g).
+			Client(client).
+			Grouping(pushgatewayNamespaceLabel, g.namespace)
+
+		for name, value := range cfg.Grouping {
+			pusher = pusher.Grouping(name, value)
+		}
+
+		if cfg.Username != "" {
+			pusher = pusher.BasicAuth(cfg.Username, cfg.Password)
+		}
+
+		p.pushers[g.namespace] = pusher
+	}
+
+	return p, nil
+}
+
+// pushgatewayClient builds the HTTP client used for pushing, using the
+// configured TLS settings (if any)
+func pushgatewayClient(tlsCfg *config.PushgatewayTLSConfig, timeout time.Duration) (*http.Client, error) {
+	client := &http.Client{Timeout: timeout}
+	if tlsCfg == nil {
+		return client, nil
+	}
+
+	c := &tls.Config{InsecureSkipVerify: tlsCfg.InsecureSkipVerify}
+
+	if tlsCfg.CAFile != "" {
+		ca, err := ioutil.ReadFile(tlsCfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read pushgateway CA file: %s", err.Error())
+		}
+
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("pushgateway CA file %s contains no certificates", tlsCfg.CAFile)
+		}
+	}
+
+	if tlsCfg.CertFile != "" || tlsCfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load pushgateway client certificate: %s", err.Error())
+		}
+
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: c}
+	return client, nil
+}
+
+// run pushes the metrics at every interval until stopChan is closed, and then
+// a last time, so that the final values are not lost
+func (p *pushgatewayPusher) run(stopChan <-chan bool) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			_ = p.pushAll()
+			return
+		case <-ticker.C:
+			_ = p.pushAll()
+		}
+	}
+}
+
+// pushAll pushes the metrics of all namespaces. Errors are logged for each
+// namespace; the returned error only tells if any push failed.
+func (p *pushgatewayPusher) pushAll() error {
+	failed := 0
+
+	for namespace, pusher := range p.pushers {
+		if err := pusher.Push(); err != nil {
+			fmt.Printf("error while pushing metrics of namespace %s to the pushgateway: %s\n", namespace, err.Error())
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("pushing the metrics of %d namespace(s) failed", failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+const pushgatewayConfig = `
+namespace "app1" {
+  format = "$status"
+}
+
+namespace "app2" {
+  format = "$status"
+}
+
+pushgateway {
+  job = "batch"
+  grouping = {
+    "instance" = "web-01"
+  }
+}
+`
+
+func TestPushgatewayPushesEachNamespace(t *testing.T) {
+	var lock sync.Mutex
+	bodies := make(map[string]string)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		lock.Lock()
+		bodies[r.Method+" "+r.URL.Path] = string(body)
+		lock.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var cfg config.Config
+	require.Nil(t, config.LoadConfigFromStream(&cfg, strings.NewReader(pushgatewayConfig), config.TypeHCL))
+	cfg.Pushgateway.URL = server.URL
+
+	gatherers := make([]*namespaceGatherer, 0, len(cfg.Namespaces))
+	for i := range cfg.Namespaces {
+		nsMetrics := NewNSMetrics(&cfg.Namespaces[i], nil)
+		p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+		require.True(t, p.process("200"))
+
+		gatherers = append(gatherers, &namespaceGatherer{namespace: cfg.Namespaces[i].Name, gatherer: nsMetrics})
+	}
+
+	pusher, err := newPushgatewayPusher(cfg.Pushgateway, gatherers)
+	require.Nil(t, err)
+	require.Nil(t, pusher.pushAll())
+
+	// The order of the grouping labels in the path is not defined
+	pushed := make(map[string]string)
+	for request, body := range bodies {
+		path := strings.TrimPrefix(request, "PUT /metrics/job/batch/")
+		require.NotEqual(t, request, path)
+
+		grouping := make(map[string]string)
+		segments := strings.Split(path, "/")
+		require.Len(t, segments, 4)
+		for i := 0; i < len(segments); i += 2 {
+			grouping[segments[i]] = segments[i+1]
+		}
+
+		assert.Equal(t, "web-01", grouping["instance"])
+		pushed[grouping["nginxlog_namespace"]] = body
+	}
+
+	require.Len(t, pushed, 2)
+	for _, ns := range []string{"app1", "app2"} {
+		assert.NotEmpty(t, pushed[ns], "no push for namespace %s", ns)
+	}
+}
+
+func TestPushgatewayRejectsNamespaceGroupingLabel(t *testing.T) {
+	_, err := newPushgatewayPusher(&config.PushgatewayConfig{
+		URL:      "http://localhost:9091",
+		Grouping: map[string]string{"nginxlog_namespace": "x"},
+	}, nil)
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,285 @@
+package relabeling
+
+import (
+	"hash/fnv"
+	"math"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+const unknownValue = "unknown"
+
+// directValue is the host of requests without referer
+const directValue = "direct"
+
+func (r *Relabeling) applyAction(sourceValue string) string {
+	switch r.Action {
+	case config.RelabelActionFirstIP:
+		return firstIP(sourceValue)
+	case config.RelabelActionContentType:
+		return contentType(sourceValue)
+	case config.RelabelActionNonEmpty:
+		return nonEmpty(sourceValue)
+	case config.RelabelActionUpstreamAddr:
+		return upstreamAddr(sourceValue)
+	case config.RelabelActionHour:
+		return hour(sourceValue)
+	case config.RelabelActionHashMod:
+		return hashMod(sourceValue, r.Modulus)
+	case config.RelabelActionCacheHitBool:
+		return cacheHitBool(sourceValue)
+	case config.RelabelActionCIDRMap:
+		return r.cidrMap(sourceValue)
+	case config.RelabelActionNumericBucket:
+		return r.numericBucket(sourceValue)
+	case config.RelabelActionPathPrefix:
+		return pathPrefix(sourceValue)
+	case config.RelabelActionCommonName:
+		return commonName(sourceValue)
+	case config.RelabelActionURLHost:
+		return urlHost(sourceValue)
+	case config.RelabelActionIsStaticAsset:
+		return r.isStaticAsset(sourceValue)
+	}
+
+	return sourceValue
+}
+
+// firstIP returns the first (and thus, originating) address from a list of
+// comma-separated IP addresses as found in the X-Forwarded-For header
+func firstIP(sourceValue string) string {
+	return nonEmpty(strings.TrimSpace(strings.SplitN(sourceValue, ",", 2)[0]))
+}
+
+// nonEmpty maps empty values and "-" (which NGINX logs for empty variables) to
+// "unknown"
+func nonEmpty(sourceValue string) string {
+	if sourceValue == "" || sourceValue == "-" {
+		return unknownValue
+	}
+
+	return sourceValue
+}
+
+// upstreamAddr returns the host (without port) of the last upstream server from
+// NGINX' $upstream_addr variable. When several servers were contacted, their
+// addresses are separated by commas, and by colons when the request was passed
+// on to another server group (like "10.0.0.1:80, 10.0.0.2:80 : 10.0.1.1:80").
+func upstreamAddr(sourceValue string) string {
+	addrs := strings.FieldsFunc(sourceValue, func(r rune) bool {
+		return r == ','
+	})
+
+	if len(addrs) == 0 {
+		return unknownValue
+	}
+
+	last := strings.TrimSpace(addrs[len(addrs)-1])
+	if i := strings.LastIndex(last, " : "); i >= 0 {
+		last = strings.TrimSpace(last[i+3:])
+	}
+
+	if strings.HasPrefix(last, "unix:") {
+		return last
+	}
+
+	if host, _, err := net.SplitHostPort(last); err == nil {
+		last = host
+	}
+
+	return nonEmpty(last)
+}
+
+// hashMod maps a value to one of modulus buckets by its hash, so that values
+// can be grouped without exporting the values themselves. Empty values are
+// mapped to "unknown".
+func hashMod(sourceValue string, modulus int) string {
+	if sourceValue == "" || sourceValue == "-" {
+		return unknownValue
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(sourceValue))
+
+	return strconv.FormatUint(uint64(h.Sum32()%uint32(modulus)), 10)
+}
+
+// cacheHitBool returns "true" if an $upstream_cache_status value means that the
+// response was served from the cache, and "false" otherwise (including requests
+// that did not pass a cache at all)
+func cacheHitBool(sourceValue string) string {
+	switch sourceValue {
+	case "HIT", "STALE", "UPDATING":
+		return "true"
+	}
+
+	return "false"
+}
+
+// cidrMap returns the label of the first configured network that contains an
+// IP address, or the default value ("external" unless configured otherwise) if
+// none does. Values that are no IP addresses are mapped to "unknown".
+func (r *Relabeling) cidrMap(sourceValue string) string {
+	ip := net.ParseIP(strings.TrimSpace(sourceValue))
+	if ip == nil {
+		return unknownValue
+	}
+
+	for i := range r.CIDRs {
+		if r.CIDRs[i].Network.Contains(ip) {
+			return r.CIDRs[i].Label
+		}
+	}
+
+	if r.DefaultValue != "" {
+		return r.DefaultValue
+	}
+
+	return config.DefaultCIDRMapValue
+}
+
+// numericBucket returns the label of the first configured bucket whose
+// threshold is greater than or equal to a number, or the default value ("other"
+// unless configured otherwise) if the number exceeds all thresholds. Values that
+// are no numbers are mapped to "unknown".
+func (r *Relabeling) numericBucket(sourceValue string) string {
+	value, err := strconv.ParseFloat(strings.TrimSpace(sourceValue), 64)
+	if err != nil || math.IsNaN(value) {
+		return unknownValue
+	}
+
+	for i := range r.Buckets {
+		if value <= r.Buckets[i].UpperBound {
+			return r.Buckets[i].Label
+		}
+	}
+
+	if r.DefaultValue != "" {
+		return r.DefaultValue
+	}
+
+	return config.DefaultNumericBucketValue
+}
+
+// pathPrefix returns the first segment of a request path (ignoring the query
+// string), or "/" for the root path itself. Values that are no absolute paths
+// are mapped to "unknown".
+func pathPrefix(sourceValue string) string {
+	if !strings.HasPrefix(sourceValue, "/") {
+		return unknownValue
+	}
+
+	segment := sourceValue[1:]
+	if i := strings.IndexAny(segment, "/?#"); i >= 0 {
+		segment = segment[:i]
+	}
+
+	if segment == "" {
+		return "/"
+	}
+
+	return segment
+}
+
+// timestampLayouts are the layouts of NGINX' $time_local and $time_iso8601
+var timestampLayouts = []string{
+	"02/Jan/2006:15:04:05 -0700",
+	time.RFC3339,
+}
+
+// hour returns the hour of the day (from "00" to "23") of a timestamp, in the
+// timestamp's own time zone
+func hour(sourceValue string) string {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, sourceValue); err == nil {
+			return t.Format("15")
+		}
+	}
+
+	return unknownValue
+}
+
+var (
+	ipv4AnonymizationMask = net.CIDRMask(24, 32)
+	ipv6AnonymizationMask = net.CIDRMask(48, 128)
+)
+
+// anonymizeIP masks the last octet of IPv4 addresses and the last 80 bits of
+// IPv6 addresses. A port (like in "1.2.3.4:5678" or "[::1]:80") is dropped.
+// Values that are no IP addresses are returned unchanged.
+func anonymizeIP(sourceValue string) string {
+	value := sourceValue
+	if host, _, err := net.SplitHostPort(sourceValue); err == nil {
+		value = host
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return sourceValue
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(ipv4AnonymizationMask).String()
+	}
+
+	return ip.Mask(ipv6AnonymizationMask).String()
+}
+
+// contentType maps a Content-Type header value (like "application/json;
+// charset=utf-8") to one of "html", "json", "image" or "other"
+func contentType(sourceValue string) string {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(sourceValue, ";", 2)[0]))
+
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return "html"
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return "json"
+	case strings.HasPrefix(mediaType, "image/"):
+		return "image"
+	}
+
+	return "other"
+}
+
+// urlHost returns the lower-cased host (without port) of a URL like the
+// referer. URLs without scheme ("example.com/page") are accepted as well.
+// Empty values and "-" (requests without referer) are mapped to "direct", and
+// values without host (like relative paths) to "unknown".
+func urlHost(sourceValue string) string {
+	if sourceValue == "" || sourceValue == "-" {
+		return directValue
+	}
+
+	if !strings.Contains(sourceValue, "://") && !strings.HasPrefix(sourceValue, "/") {
+		sourceValue = "//" + sourceValue
+	}
+
+	u, err := url.Parse(sourceValue)
+	if err != nil || u.Hostname() == "" {
+		return unknownValue
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+// isStaticAsset returns "true" if the extension of a request path (ignoring
+// the query string) is one of the relabeling's static extensions, and "false"
+// otherwise, including for missing paths
+func (r *Relabeling) isStaticAsset(sourceValue string) string {
+	if i := strings.IndexAny(sourceValue, "?#"); i >= 0 {
+		sourceValue = sourceValue[:i]
+	}
+
+	if _, ok := r.ExtensionSet[strings.ToLower(path.Ext(sourceValue))]; ok {
+		return "true"
+	}
+
+	return "false"
+}
@@ -6,7 +6,7 @@ import "github.com/tokopedia/prometheus-nginxlog-exporter/config"
 // and do not need to be explicitly configured
 var DefaultRelabelings = []*Relabeling{
 	{
-		config.RelabelConfig{
+		RelabelConfig: config.RelabelConfig{
 			TargetLabel: "method",
 			SourceValue: "request",
 			Split:       1,
@@ -26,9 +26,58 @@ var DefaultRelabelings = []*Relabeling{
 		},
 	},
 	{
-		config.RelabelConfig{
+		RelabelConfig: config.RelabelConfig{
 			TargetLabel: "status",
 			SourceValue: "status",
 		},
 	},
 }
+
+// OptionalRelabelings are hardcoded relabeling configs that are only used when
+// their source field is part of a namespace's log format
+var OptionalRelabelings = []*Relabeling{
+	{
+		RelabelConfig: config.RelabelConfig{
+			TargetLabel: "country",
+			SourceValue: "geoip_country_code",
+			Action:      config.RelabelActionNonEmpty,
+			MaxValues:   50,
+		},
+	},
+	{
+		RelabelConfig: config.RelabelConfig{
+			TargetLabel: "ratelimit",
+			SourceValue: "limit_req_status",
+			Action:      config.RelabelActionNonEmpty,
+
+			WhitelistExists: true,
+			WhitelistMap: map[string]interface{}{
+				"PASSED":           nil,
+				"DELAYED":          nil,
+				"REJECTED":         nil,
+				"DELAYED_DRY_RUN":  nil,
+				"REJECTED_DRY_RUN": nil,
+				"unknown":          nil,
+			},
+		},
+	},
+	{
+		RelabelConfig: config.RelabelConfig{
+			TargetLabel: "scheme",
+			SourceValue: "scheme",
+
+			WhitelistExists: true,
+			WhitelistMap: map[string]interface{}{
+				"http":  nil,
+				"https": nil,
+			},
+		},
+	},
+	{
+		RelabelConfig: config.RelabelConfig{
+			TargetLabel: "server_port",
+			SourceValue: "server_port",
+			MaxValues:   20,
+		},
+	},
+}
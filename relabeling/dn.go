@@ -0,0 +1,111 @@
+package relabeling
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// commonName returns the common name (CN) of a distinguished name as logged by
+// NGINX in $ssl_client_s_dn. Both the RFC 2253 format used since NGINX 1.11.6
+// ("CN=svc,OU=mesh,O=Example") and the legacy format ("/O=Example/CN=svc") are
+// supported. If the name contains several CNs, the most specific one is used.
+// Values without CN (including "-" for requests without client certificate)
+// are mapped to "unknown".
+func commonName(sourceValue string) string {
+	var cn string
+
+	if strings.HasPrefix(sourceValue, "/") {
+		// The legacy format lists the most specific attribute last
+		for _, attr := range strings.Split(sourceValue[1:], "/") {
+			if value, ok := cnValue(attr); ok {
+				cn = value
+			}
+		}
+	} else {
+		// RFC 2253 lists the most specific attribute first
+		for _, attr := range splitRFC2253(sourceValue) {
+			if value, ok := cnValue(attr); ok {
+				cn = unescapeRFC2253(value)
+				break
+			}
+		}
+	}
+
+	return nonEmpty(cn)
+}
+
+// cnValue returns the value of an attribute like "CN=svc", if it is a common
+// name (given by name or by its OID)
+func cnValue(attr string) (string, bool) {
+	i := strings.IndexByte(attr, '=')
+	if i < 0 {
+		return "", false
+	}
+
+	key := strings.TrimSpace(attr[:i])
+	if !strings.EqualFold(key, "CN") && key != "2.5.4.3" {
+		return "", false
+	}
+
+	return strings.TrimSpace(attr[i+1:]), true
+}
+
+// splitRFC2253 splits a distinguished name into its attributes, which are
+// separated by unescaped commas (or plus signs within multi-valued RDNs).
+// Separators within quoted values or escaped by a backslash are kept.
+func splitRFC2253(dn string) []string {
+	var attrs []string
+
+	start := 0
+	quoted := false
+
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ',', ';', '+':
+			if !quoted {
+				attrs = append(attrs, dn[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(attrs, dn[start:])
+}
+
+// unescapeRFC2253 removes the quotes and backslash escapes (including
+// hex-encoded bytes like "\2C") of an attribute value
+func unescapeRFC2253(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
+
+	var b strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 >= len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		if i+2 < len(value) {
+			if decoded, err := hex.DecodeString(value[i+1 : i+3]); err == nil {
+				b.Write(decoded)
+				i += 2
+				continue
+			}
+		}
+
+		b.WriteByte(value[i+1])
+		i++
+	}
+
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package relabeling
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileValue is a label value that is read from a file (like the current
+// release version from "/etc/release"), and re-read after an interval, so
+// that changes of the file are picked up without restarting the exporter
+type fileValue struct {
+	path     string
+	interval time.Duration
+
+	lock   sync.Mutex
+	value  string
+	readAt time.Time
+	failed bool
+}
+
+func newFileValue(path string, interval time.Duration) *fileValue {
+	return &fileValue{path: path, interval: interval, value: unknownValue}
+}
+
+// get returns the file's content (without surrounding whitespace) as read at
+// most one interval ago. If the file cannot be read, the previous value is
+// kept ("unknown" if the file could never be read).
+func (f *fileValue) get(now time.Time) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.readAt.IsZero() && now.Sub(f.readAt) < f.interval {
+		return f.value
+	}
+
+	f.readAt = now
+
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		// Only the first of several consecutive errors is logged
+		if !f.failed {
+			fmt.Printf("could not read label value from %s: %s\n", f.path, err.Error())
+		}
+
+		f.failed = true
+		return f.value
+	}
+
+	f.failed = false
+	f.value = nonEmpty(strings.TrimSpace(string(content)))
+
+	return f.value
+}
@@ -0,0 +1,37 @@
+package relabeling
+
+import "sync"
+
+// valueLimiter caps the number of distinct values of a label. Once the limit
+// is reached, all values that have not been seen before are subsumed under the
+// overflow value.
+type valueLimiter struct {
+	max      int
+	overflow string
+	seen     map[string]struct{}
+	lock     sync.Mutex
+}
+
+func newValueLimiter(max int, overflow string) *valueLimiter {
+	return &valueLimiter{
+		max:      max,
+		overflow: overflow,
+		seen:     make(map[string]struct{}, max),
+	}
+}
+
+func (l *valueLimiter) limit(value string) string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, ok := l.seen[value]; ok {
+		return value
+	}
+
+	if len(l.seen) >= l.max {
+		return l.overflow
+	}
+
+	l.seen[value] = struct{}{}
+	return value
+}
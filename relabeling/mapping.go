@@ -2,11 +2,74 @@ package relabeling
 
 import (
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
 )
 
+// Source returns the source value of the relabeling from the fields of a log
+// line: either the value of its source field, or its template filled in with
+// the values of the referenced fields. It returns false if a field is missing.
+// For dynamic labels, the value is read from the label's file instead.
+func (r *Relabeling) Source(fields map[string]string) (string, bool) {
+	if r.file != nil {
+		return r.file.get(time.Now()), true
+	}
+
+	if len(r.TemplateParts) == 0 {
+		value, ok := fields[r.SourceValue]
+		return value, ok
+	}
+
+	var b strings.Builder
+
+	for _, p := range r.TemplateParts {
+		if p.Field == "" {
+			b.WriteString(p.Literal)
+			continue
+		}
+
+		value, ok := fields[p.Field]
+		if !ok {
+			return "", false
+		}
+
+		b.WriteString(value)
+	}
+
+	return b.String(), true
+}
+
+// MapsMissingSource returns true if a missing source field is mapped like an
+// empty value, instead of leaving the label unset: without a cache status, a
+// request was not served from the cache, and without a path, no static asset
+// was requested
+func (r *Relabeling) MapsMissingSource() bool {
+	return r.Action == config.RelabelActionCacheHitBool || r.Action == config.RelabelActionIsStaticAsset
+}
+
 // Map maps a sourceValue from the access log line according to the relabeling
 // config (matching against whitelists, regular expressions etc.)
 func (r *Relabeling) Map(sourceValue string) (string, error) {
+	value, admit := r.Transform(sourceValue)
+	if admit {
+		value = r.Admit(value)
+	}
+
+	return value, nil
+}
+
+// Transform maps a sourceValue like Map, except for the checks that keep state
+// (the label value pattern and the value limit), which are left to Admit. It
+// only reads the relabeling (apart from caching routes), so that it can
+// safely be abandoned. It returns false if the value must not be passed to
+// Admit, like the values of whitelists.
+func (r *Relabeling) Transform(sourceValue string) (string, bool) {
+	if r.maskedParams != nil {
+		sourceValue = maskQueryParams(sourceValue, r.maskedParams)
+	}
+
 	if r.Split > 0 {
 		values := strings.Split(sourceValue, " ")
 
@@ -17,16 +80,32 @@ func (r *Relabeling) Map(sourceValue string) (string, error) {
 		}
 	}
 
+	if r.Action != "" {
+		sourceValue = r.applyAction(sourceValue)
+	}
+
+	if r.AnonymizeIP {
+		sourceValue = anonymizeIP(sourceValue)
+	}
+
+	if r.routes != nil {
+		sourceValue = r.normalizeRoute(sourceValue)
+	}
+
 	if r.WhitelistExists {
 		if _, ok := r.WhitelistMap[sourceValue]; ok {
-			return sourceValue, nil
+			return sourceValue, false
 		}
 
-		return "other", nil
+		if r.DefaultValue != "" {
+			return r.DefaultValue, false
+		}
+
+		return "other", false
 	}
 
 	if len(r.Matches) > 0 {
-		replacement := ""
+		replacement := r.DefaultValue
 		for i := range r.Matches {
 			if r.Matches[i].CompiledRegexp.MatchString(sourceValue) {
 				replacement = r.Matches[i].CompiledRegexp.ReplaceAllString(sourceValue, r.Matches[i].Replacement)
@@ -36,5 +115,26 @@ func (r *Relabeling) Map(sourceValue string) (string, error) {
 		sourceValue = replacement
 	}
 
-	return sourceValue, nil
+	if r.utf8Replacement != nil {
+		sourceValue = strings.ToValidUTF8(sourceValue, *r.utf8Replacement)
+	}
+
+	return sourceValue, true
+}
+
+// Admit checks a value returned by Transform against the label value pattern
+// and the limit of distinct values, and returns the value of the label
+func (r *Relabeling) Admit(value string) string {
+	// Invalid values bypass the limiter, so that garbage does not use up the
+	// distinct values
+	if r.LabelValueRegexp != nil && !r.LabelValueRegexp.MatchString(value) {
+		atomic.AddUint64(&r.invalidValues, 1)
+		return config.InvalidLabelValue
+	}
+
+	if r.limiter != nil {
+		value = r.limiter.limit(value)
+	}
+
+	return value
 }
@@ -1,8 +1,10 @@
 package relabeling
 
 import (
-	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+	"strconv"
 	"testing"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
 )
 
 func buildRelabeling(cfg config.RelabelConfig) (*Relabeling, error) {
@@ -52,3 +54,630 @@ func TestRequestURIMapping(t *testing.T) {
 	assertMapping(t, r, "GET /users/12345/about HTTP/1.1", "/users/:id/about")
 	assertMapping(t, r, "GET /v1/users/12345 HTTP/1.1", "")
 }
+
+func TestDefaultValueReplacesUnmatchedValues(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{
+		Split:        2,
+		DefaultValue: "unmatched",
+		Matches: []config.RelabelValueMatch{
+			{RegexpString: "^/users/[0-9]+$", Replacement: "/users/:id"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "GET /users/12345 HTTP/1.1", "/users/:id")
+	assertMapping(t, r, "GET /v1/users/12345 HTTP/1.1", "unmatched")
+}
+
+func TestDefaultValueReplacesNonWhitelistedValues(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{
+		Whitelist:    []string{"GET", "POST"},
+		DefaultValue: "OTHER",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "GET", "GET")
+	assertMapping(t, r, "PROPFIND", "OTHER")
+}
+
+func TestFirstIPMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionFirstIP})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "1.2.3.4, 10.0.0.1", "1.2.3.4")
+	assertMapping(t, r, " 1.2.3.4 ", "1.2.3.4")
+	assertMapping(t, r, "-", "unknown")
+	assertMapping(t, r, "", "unknown")
+}
+
+func TestUpstreamAddrMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionUpstreamAddr})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "10.0.0.1:8080", "10.0.0.1")
+	assertMapping(t, r, "10.0.0.1:8080, 10.0.0.2:8080", "10.0.0.2")
+	assertMapping(t, r, "10.0.0.1:80, 10.0.0.2:80 : 10.0.1.1:80", "10.0.1.1")
+	assertMapping(t, r, "[::1]:80", "::1")
+	assertMapping(t, r, "unix:/var/run/app.sock", "unix:/var/run/app.sock")
+	assertMapping(t, r, "-", "unknown")
+	assertMapping(t, r, "", "unknown")
+}
+
+func TestHourMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionHour})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "10/Oct/2020:13:55:36 +0200", "13")
+	assertMapping(t, r, "10/Oct/2020:00:05:00 +0000", "00")
+	assertMapping(t, r, "2020-10-10T09:55:36+02:00", "09")
+	assertMapping(t, r, "-", "unknown")
+	assertMapping(t, r, "yesterday", "unknown")
+}
+
+func TestAnonymizeIPMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{AnonymizeIP: true})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "192.168.17.42", "192.168.17.0")
+	assertMapping(t, r, "2001:db8:85a3:8d3:1319:8a2e:370:7344", "2001:db8:85a3::")
+	assertMapping(t, r, "::ffff:10.1.2.3", "10.1.2.0")
+	assertMapping(t, r, "1.2.3.4:5678", "1.2.3.0")
+	assertMapping(t, r, "[2001:db8:85a3:8d3:1319:8a2e:370:7344]:80", "2001:db8:85a3::")
+	assertMapping(t, r, "[::1]:80", "::")
+	assertMapping(t, r, "example.com:80", "example.com:80")
+	assertMapping(t, r, "-", "-")
+}
+
+func TestAnonymizeIPIsAppliedAfterAction(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionFirstIP, AnonymizeIP: true})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "1.2.3.4, 10.0.0.1", "1.2.3.0")
+	assertMapping(t, r, "-", "unknown")
+}
+
+func TestUnknownActionIsRejected(t *testing.T) {
+	t.Parallel()
+
+	if _, err := buildRelabeling(config.RelabelConfig{Action: "foo"}); err == nil {
+		t.Error("expected error for unknown relabel action")
+	}
+}
+
+func TestContentTypeMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionContentType})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "application/json; charset=utf-8", "json")
+	assertMapping(t, r, "application/problem+json", "json")
+	assertMapping(t, r, "text/html;charset=UTF-8", "html")
+	assertMapping(t, r, "image/png", "image")
+	assertMapping(t, r, "application/octet-stream", "other")
+	assertMapping(t, r, "-", "other")
+}
+
+func TestNonEmptyMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionNonEmpty})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "DE", "DE")
+	assertMapping(t, r, "-", "unknown")
+	assertMapping(t, r, "", "unknown")
+}
+
+func TestCacheHitBoolMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionCacheHitBool})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "HIT", "true")
+	assertMapping(t, r, "STALE", "true")
+	assertMapping(t, r, "UPDATING", "true")
+	assertMapping(t, r, "MISS", "false")
+	assertMapping(t, r, "BYPASS", "false")
+	assertMapping(t, r, "-", "false")
+	assertMapping(t, r, "", "false")
+}
+
+func TestCIDRMapMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{
+		Action: config.RelabelActionCIDRMap,
+		CIDRs: []config.RelabelCIDR{
+			{CIDR: "10.1.0.0/16", Label: "office"},
+			{CIDR: "10.0.0.0/8", Label: "datacenter"},
+			{CIDR: "fd00::/8", Label: "datacenter"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "10.1.2.3", "office")
+	assertMapping(t, r, "10.2.3.4", "datacenter")
+	assertMapping(t, r, "fd12::1", "datacenter")
+	assertMapping(t, r, "8.8.8.8", "external")
+	assertMapping(t, r, "-", "unknown")
+}
+
+func TestCIDRMapRequiresValidCIDRs(t *testing.T) {
+	t.Parallel()
+
+	_, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionCIDRMap})
+	if err == nil {
+		t.Error("expected an error for a cidr_map relabeling without cidrs")
+	}
+
+	_, err = buildRelabeling(config.RelabelConfig{
+		Action: config.RelabelActionCIDRMap,
+		CIDRs:  []config.RelabelCIDR{{CIDR: "10.0.0.0/33", Label: "invalid"}},
+	})
+	if err == nil {
+		t.Error("expected an error for an invalid cidr")
+	}
+}
+
+func TestNumericBucketMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{
+		Action: config.RelabelActionNumericBucket,
+		Buckets: []config.RelabelBucket{
+			{Threshold: "1024", Label: "small"},
+			{Threshold: "1048576", Label: "medium"},
+		},
+		DefaultValue: "large",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "0", "small")
+	assertMapping(t, r, "1024", "small")
+	assertMapping(t, r, "1025", "medium")
+	assertMapping(t, r, "0.5", "small")
+	assertMapping(t, r, "2000000", "large")
+	assertMapping(t, r, "-", "unknown")
+	assertMapping(t, r, "NaN", "unknown")
+}
+
+func TestPathPrefixMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionPathPrefix})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "/api/v1/users?id=1", "api")
+	assertMapping(t, r, "/static", "static")
+	assertMapping(t, r, "/healthz?full=1", "healthz")
+	assertMapping(t, r, "/", "/")
+	assertMapping(t, r, "/?q=1", "/")
+	assertMapping(t, r, "*", "unknown")
+	assertMapping(t, r, "-", "unknown")
+}
+
+func TestCommonNameMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionCommonName})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "CN=orders,OU=mesh,O=Example", "orders")
+	assertMapping(t, r, "OU=mesh, cn = payments ,O=Example", "payments")
+	assertMapping(t, r, `CN=Smith\, John,O=Example`, "Smith, John")
+	assertMapping(t, r, `CN="a+b, c",O=Example`, "a+b, c")
+	assertMapping(t, r, `CN=caf\C3\A9,O=Example`, "café")
+	assertMapping(t, r, "2.5.4.3=search,O=Example", "search")
+	assertMapping(t, r, "/C=US/O=Example/CN=legacy", "legacy")
+	assertMapping(t, r, "O=Example,OU=mesh", "unknown")
+	assertMapping(t, r, "CN=,O=Example", "unknown")
+	assertMapping(t, r, "-", "unknown")
+	assertMapping(t, r, "", "unknown")
+}
+
+func TestURLHostMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionURLHost})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "https://www.Example.com/page?q=1", "www.example.com")
+	assertMapping(t, r, "http://news.example.org:8080/", "news.example.org")
+	assertMapping(t, r, "http://[2001:db8::1]:8080/", "2001:db8::1")
+	assertMapping(t, r, "android-app://com.example.app/", "com.example.app")
+	assertMapping(t, r, "example.net/page", "example.net")
+	assertMapping(t, r, "-", "direct")
+	assertMapping(t, r, "", "direct")
+	assertMapping(t, r, "/relative/path", "unknown")
+	assertMapping(t, r, "http://%zz/", "unknown")
+}
+
+func TestURLHostIsCappedByDefault(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionURLHost})
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i := 0; i < config.DefaultURLHostMaxValues; i++ {
+		host := "site" + strconv.Itoa(i) + ".example.com"
+		assertMapping(t, r, "https://"+host+"/", host)
+	}
+
+	assertMapping(t, r, "https://one-too-many.example.com/", "other")
+}
+
+func TestCommonNameIsLimitedToWhitelist(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{
+		Action:    config.RelabelActionCommonName,
+		Whitelist: []string{"orders", "unknown"},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "CN=orders,O=Example", "orders")
+	assertMapping(t, r, "CN=rogue,O=Example", "other")
+	assertMapping(t, r, "-", "unknown")
+}
+
+func TestNumericBucketRequiresAscendingThresholds(t *testing.T) {
+	t.Parallel()
+
+	_, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionNumericBucket})
+	if err == nil {
+		t.Error("expected an error for a numeric_bucket relabeling without buckets")
+	}
+
+	_, err = buildRelabeling(config.RelabelConfig{
+		Action: config.RelabelActionNumericBucket,
+		Buckets: []config.RelabelBucket{
+			{Threshold: "100", Label: "medium"},
+			{Threshold: "10", Label: "small"},
+		},
+	})
+	if err == nil {
+		t.Error("expected an error for thresholds in descending order")
+	}
+
+	_, err = buildRelabeling(config.RelabelConfig{
+		Action:  config.RelabelActionNumericBucket,
+		Buckets: []config.RelabelBucket{{Threshold: "large", Label: "large"}},
+	})
+	if err == nil {
+		t.Error("expected an error for an invalid threshold")
+	}
+}
+
+func TestTemplateCombinesFields(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Template: "{request_method} {uri}", MaxValues: 1})
+	if err != nil {
+		t.Error(err)
+	}
+
+	source, ok := r.Source(map[string]string{"request_method": "GET", "uri": "/users"})
+	if !ok || source != "GET /users" {
+		t.Errorf("expected 'GET /users', but got '%s'", source)
+	}
+
+	assertMapping(t, r, source, "GET /users")
+	assertMapping(t, r, "POST /users", "other")
+
+	if _, ok := r.Source(map[string]string{"request_method": "GET"}); ok {
+		t.Error("expected the source to be missing if a field is missing")
+	}
+}
+
+func TestInvalidTemplatesAreRejected(t *testing.T) {
+	t.Parallel()
+
+	for _, cfg := range []config.RelabelConfig{
+		{Template: "{request_method} {uri"},
+		{Template: "{} {uri}"},
+		{Template: "{uri}", SourceValue: "request"},
+	} {
+		if _, err := buildRelabeling(cfg); err == nil {
+			t.Errorf("expected an error for template '%s'", cfg.Template)
+		}
+	}
+}
+
+func TestMaxValuesCapsDistinctValues(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{MaxValues: 2})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "a", "a")
+	assertMapping(t, r, "b", "b")
+	assertMapping(t, r, "c", "other")
+	assertMapping(t, r, "a", "a")
+}
+
+func TestMaxValuesUsesConfiguredOverflowValue(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{MaxValues: 1, OverflowValue: "__overflow__"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertMapping(t, r, "a", "a")
+	assertMapping(t, r, "b", "__overflow__")
+}
+
+func TestHashModMapping(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionHashMod, Modulus: 16})
+	if err != nil {
+		t.Error(err)
+	}
+
+	a, _ := r.Map("192.168.17.42")
+	b, _ := r.Map("192.168.17.42")
+	if a != b {
+		t.Errorf("expected the same bucket for the same value, but got '%s' and '%s'", a, b)
+	}
+
+	buckets := make(map[string]struct{})
+	for i := 0; i < 1000; i++ {
+		bucket, _ := r.Map("10.0.0." + strconv.Itoa(i))
+		if n, err := strconv.Atoi(bucket); err != nil || n < 0 || n >= 16 {
+			t.Errorf("expected a bucket from 0 to 15, but got '%s'", bucket)
+		}
+		buckets[bucket] = struct{}{}
+	}
+
+	if len(buckets) != 16 {
+		t.Errorf("expected values to be spread over all 16 buckets, but got %d", len(buckets))
+	}
+
+	assertMapping(t, r, "-", "unknown")
+}
+
+func TestHashModRequiresModulus(t *testing.T) {
+	t.Parallel()
+
+	if _, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionHashMod}); err == nil {
+		t.Error("expected error for missing modulus")
+	}
+}
+
+func TestRoutesNormalizeRequestPaths(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{
+		Routes: []config.RelabelRoute{
+			{RegexpString: `^/api/v(\d+)/users/\d+$`, Replacement: "/api/v$1/users/:id"},
+			{RegexpString: `^/api/v(?P<version>\d+)/users/(?P<user>\d+)/posts/\d+$`, Replacement: "/api/v${version}/users/:id/posts/:post"},
+			{RegexpString: `^/api/`, Replacement: "/api/*"},
+			{RegexpString: `^/api/health$`, Replacement: "/api/health"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertMapping(t, r, "/api/v2/users/12345", "/api/v2/users/:id")
+	assertMapping(t, r, "/api/v2/users/12345?expand=true", "/api/v2/users/:id")
+	assertMapping(t, r, "/api/v1/users/1/posts/2", "/api/v1/users/:id/posts/:post")
+	assertMapping(t, r, "/api/health", "/api/*")
+	assertMapping(t, r, "/index.html", "other")
+
+	// Cached results must not differ from freshly computed ones
+	assertMapping(t, r, "/api/v2/users/12345", "/api/v2/users/:id")
+	assertMapping(t, r, "/index.html", "other")
+}
+
+func TestRoutesUseDefaultValue(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{
+		DefaultValue: "unrouted",
+		Routes:       []config.RelabelRoute{{RegexpString: `^/users/\d+$`, Replacement: "/users/:id"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertMapping(t, r, "/users/1", "/users/:id")
+	assertMapping(t, r, "/about", "unrouted")
+}
+
+func TestInvalidRoutesAreRejected(t *testing.T) {
+	t.Parallel()
+
+	invalid := [][]config.RelabelRoute{
+		{{RegexpString: `^/users/(\d+$`, Replacement: "/users/:id"}},
+		{{RegexpString: `^/users/\d+$`}},
+	}
+
+	for _, routes := range invalid {
+		if _, err := buildRelabeling(config.RelabelConfig{Routes: routes}); err == nil {
+			t.Errorf("expected error for routes %v", routes)
+		}
+	}
+}
+
+func TestRouteCacheIsBounded(t *testing.T) {
+	t.Parallel()
+
+	c := newRouteCache(2)
+	c.put("/a", "a")
+	c.put("/b", "b")
+	c.put("/c", "c")
+
+	if _, ok := c.get("/a"); ok {
+		t.Error("expected the cache to be cleared when full")
+	}
+
+	if route, ok := c.get("/c"); !ok || route != "c" {
+		t.Errorf("expected '/c' to be cached, but got '%s'", route)
+	}
+}
+
+func TestLabelValuePattern(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{LabelValuePattern: `[a-z0-9_/]+`, MaxValues: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertMapping(t, r, "/api", "/api")
+	assertMapping(t, r, "/api\nX-Injected: 1", "invalid")
+	assertMapping(t, r, "/API", "invalid")
+	assertMapping(t, r, "/users", "/users")
+	assertMapping(t, r, "/cart", "other")
+
+	if n := r.InvalidValues(); n != 2 {
+		t.Errorf("expected 2 invalid values, but got %d", n)
+	}
+}
+
+func TestTransformKeepsLimitAndInvalidValuesUntouched(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{LabelValuePattern: `[a-z]+`, MaxValues: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []string{"a", "b", "INVALID"} {
+		if transformed, admit := r.Transform(value); transformed != value || !admit {
+			t.Errorf("expected '%s' to be transformed to itself, but got '%s'", value, transformed)
+		}
+	}
+
+	if n := r.InvalidValues(); n != 0 {
+		t.Errorf("expected no invalid values, but got %d", n)
+	}
+
+	assertMapping(t, r, "b", "b")
+	assertMapping(t, r, "a", "other")
+}
+
+func TestInvalidLabelValuePatternIsRejected(t *testing.T) {
+	t.Parallel()
+
+	if _, err := buildRelabeling(config.RelabelConfig{LabelValuePattern: `[a-z`}); err == nil {
+		t.Error("expected error for invalid label_value_pattern")
+	}
+}
+
+func TestIsStaticAssetAction(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{Action: config.RelabelActionIsStaticAsset})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertMapping(t, r, "/assets/app.js", "true")
+	assertMapping(t, r, "/assets/logo.PNG?v=3", "true")
+	assertMapping(t, r, "/fonts/icons.woff2#iefix", "true")
+	assertMapping(t, r, "/api/users", "false")
+	assertMapping(t, r, "/api/users.json", "false")
+	assertMapping(t, r, "/static.d/", "false")
+	assertMapping(t, r, "-", "false")
+	assertMapping(t, r, "", "false")
+
+	r, err = buildRelabeling(config.RelabelConfig{Action: config.RelabelActionIsStaticAsset, Extensions: []string{"JSON", ".csv"}, Split: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertMapping(t, r, "GET /export/report.csv HTTP/1.1", "true")
+	assertMapping(t, r, "GET /api/users.json HTTP/1.1", "true")
+	assertMapping(t, r, "GET /assets/app.js HTTP/1.1", "false")
+}
+
+func TestIsStaticAssetMapsMissingPathToFalse(t *testing.T) {
+	t.Parallel()
+
+	r, err := buildRelabeling(config.RelabelConfig{SourceValue: "request_uri", Action: config.RelabelActionIsStaticAsset})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, ok := r.Source(map[string]string{"status": "200"})
+	if ok {
+		t.Fatal("expected the source field to be missing")
+	}
+
+	if !r.MapsMissingSource() {
+		t.Fatal("expected a missing path to be mapped")
+	}
+
+	assertMapping(t, r, value, "false")
+}
+
+func TestInvalidStaticAssetExtensionsAreRejected(t *testing.T) {
+	t.Parallel()
+
+	invalid := []config.RelabelConfig{
+		{Action: config.RelabelActionIsStaticAsset, Extensions: []string{"."}},
+		{Action: config.RelabelActionIsStaticAsset, Extensions: []string{"tar.gz"}},
+		{Action: config.RelabelActionPathPrefix, Extensions: []string{".js"}},
+	}
+
+	for _, cfg := range invalid {
+		if _, err := buildRelabeling(cfg); err == nil {
+			t.Errorf("expected error for extensions %v with action '%s'", cfg.Extensions, cfg.Action)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package relabeling
+
+import (
+	"net/url"
+	"strings"
+)
+
+// maskedValue replaces the values of masked query parameters
+const maskedValue = "***"
+
+// maskQueryParams replaces the values of the given query parameters in a URI
+// with "***", keeping the rest of the URI as it is. The URI may be part of a
+// longer value (like "GET /login?token=secret HTTP/1.1" from $request), in
+// which case the query string ends at the next space.
+func maskQueryParams(value string, params map[string]struct{}) string {
+	start := strings.IndexByte(value, '?')
+	if start < 0 {
+		return value
+	}
+
+	start++
+	end := len(value)
+	if i := strings.IndexAny(value[start:], " #"); i >= 0 {
+		end = start + i
+	}
+
+	pairs := strings.Split(value[start:end], "&")
+	masked := false
+
+	for i, pair := range pairs {
+		eq := strings.IndexByte(pair, '=')
+		if eq < 0 {
+			continue
+		}
+
+		name := pair[:eq]
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+
+		if _, ok := params[name]; ok {
+			pairs[i] = pair[:eq+1] + maskedValue
+			masked = true
+		}
+	}
+
+	if !masked {
+		return value
+	}
+
+	return value[:start] + strings.Join(pairs, "&") + value[end:]
+}
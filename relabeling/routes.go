@@ -0,0 +1,76 @@
+package relabeling
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+// routeCacheSize is the maximum number of request paths whose route is cached
+const routeCacheSize = 10000
+
+// routeCache remembers the routes of recently seen request paths, so that the
+// route regular expressions do not need to be evaluated for every log line.
+// When the cache is full, it is cleared.
+type routeCache struct {
+	max     int
+	entries map[string]string
+	lock    sync.Mutex
+}
+
+func newRouteCache(max int) *routeCache {
+	return &routeCache{
+		max:     max,
+		entries: make(map[string]string),
+	}
+}
+
+func (c *routeCache) get(path string) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	route, ok := c.entries[path]
+	return route, ok
+}
+
+func (c *routeCache) put(path string, route string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.entries) >= c.max {
+		c.entries = make(map[string]string)
+	}
+
+	c.entries[path] = route
+}
+
+// normalizeRoute maps a request URI to the route of the first matching route
+// expression. The query string is ignored; URIs that match no route are
+// mapped to the default value, or "other".
+func (r *Relabeling) normalizeRoute(uri string) string {
+	path := uri
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+
+	if route, ok := r.routes.get(path); ok {
+		return route
+	}
+
+	route := r.DefaultValue
+	if route == "" {
+		route = config.DefaultRouteValue
+	}
+
+	for i := range r.Routes {
+		re := r.Routes[i].CompiledRegexp
+		if match := re.FindStringSubmatchIndex(path); match != nil {
+			route = string(re.ExpandString(nil, r.Routes[i].Replacement, path, match))
+			break
+		}
+	}
+
+	r.routes.put(path, route)
+	return route
+}
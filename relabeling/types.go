@@ -1,11 +1,40 @@
 package relabeling
 
-import "github.com/tokopedia/prometheus-nginxlog-exporter/config"
+import (
+	"sync/atomic"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
 
 // Relabeling contains a relabeling configuration and is responsible for
 // executing the rules specified in the original configuration
 type Relabeling struct {
 	config.RelabelConfig
+
+	limiter *valueLimiter
+	routes  *routeCache
+
+	// utf8Replacement replaces invalid UTF-8 sequences in mapped values, if
+	// set
+	utf8Replacement *string
+
+	// file provides the value of a dynamic label instead of a log field;
+	// nil for all other relabelings
+	file *fileValue
+
+	// maskedParams are the query parameters whose values are masked in the
+	// source value; nil if none are masked
+	maskedParams map[string]struct{}
+
+	// invalidValues counts the mapped values that did not match the
+	// label_value_pattern; accessed atomically
+	invalidValues uint64
+}
+
+// InvalidValues returns the number of mapped values that were replaced because
+// they did not match the relabeling's label_value_pattern
+func (r *Relabeling) InvalidValues() uint64 {
+	return atomic.LoadUint64(&r.invalidValues)
 }
 
 // NewRelabelings creates a new set of relabelling runners from a list of
@@ -22,7 +51,88 @@ func NewRelabelings(cfgs []config.RelabelConfig) []*Relabeling {
 
 // NewRelabeling creates a single new relabelling runner
 func NewRelabeling(cfg *config.RelabelConfig) *Relabeling {
-	return &Relabeling{*cfg}
+	r := &Relabeling{RelabelConfig: *cfg}
+
+	if maxValues := cfg.MaxValuesOrDefault(); maxValues > 0 {
+		r.limiter = newValueLimiter(maxValues, cfg.OverflowValueOrDefault())
+	}
+
+	if len(cfg.Routes) > 0 {
+		r.routes = newRouteCache(routeCacheSize)
+	}
+
+	return r
+}
+
+// NewNamespaceRelabelings creates all relabelling runners for a namespace:
+// the configured ones, the default ones and those optional default ones whose
+// source field is part of the namespace's log format. Each runner is created
+// anew, so that no state (like the values seen so far) is shared between
+// namespaces.
+func NewNamespaceRelabelings(cfg *config.NamespaceConfig) []*Relabeling {
+	r := NewRelabelings(cfg.RelabelConfigs)
+
+	if cfg.PathRootRelabeling != nil {
+		r = append(r, NewRelabeling(cfg.PathRootRelabeling))
+	}
+
+	for _, name := range cfg.DynamicLabelNames() {
+		d := NewRelabeling(&config.RelabelConfig{TargetLabel: name})
+		d.file = newFileValue(cfg.DynamicLabels[name], cfg.DynamicLabelIntervalDuration)
+
+		r = append(r, d)
+	}
+
+	for _, d := range DefaultRelabelings {
+		r = append(r, NewRelabeling(&d.RelabelConfig))
+	}
+
+	for _, d := range OptionalRelabelings {
+		if config.FormatContainsField(cfg.Format, d.SourceValue) {
+			r = append(r, NewRelabeling(&d.RelabelConfig))
+		}
+	}
+
+	applyNamespaceOptions(cfg, r)
+
+	return UniqueRelabelings(r)
+}
+
+// NewCustomCounterRelabeling creates the relabeling that caps the label values
+// of a custom counter. Its values are masked and sanitized like the ones of
+// the namespace's relabelings.
+func NewCustomCounterRelabeling(cfg *config.NamespaceConfig, c *config.CustomCounter) *Relabeling {
+	r := NewRelabeling(&config.RelabelConfig{
+		TargetLabel: c.LabelOrDefault(),
+		SourceValue: c.SourceValue,
+		MaxValues:   c.MaxValuesOrDefault(),
+	})
+
+	applyNamespaceOptions(cfg, []*Relabeling{r})
+
+	return r
+}
+
+// applyNamespaceOptions sets the namespace-wide options for masking query
+// parameters and sanitizing UTF-8 on relabelings
+func applyNamespaceOptions(cfg *config.NamespaceConfig, r []*Relabeling) {
+	if len(cfg.MaskQueryParams) > 0 {
+		masked := make(map[string]struct{}, len(cfg.MaskQueryParams))
+		for _, p := range cfg.MaskQueryParams {
+			masked[p] = struct{}{}
+		}
+
+		for i := range r {
+			r[i].maskedParams = masked
+		}
+	}
+
+	if cfg.SanitizeUTF8 {
+		replacement := cfg.UTF8ReplacementOrDefault()
+		for i := range r {
+			r[i].utf8Replacement = &replacement
+		}
+	}
 }
 
 // UniqueRelabelings creates a unique relabelings, the duplicated one at the end will discard.
@@ -0,0 +1,261 @@
+package relabeling
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+func targetLabels(relabelings []*Relabeling) []string {
+	labels := make([]string, len(relabelings))
+	for i := range relabelings {
+		labels[i] = relabelings[i].TargetLabel
+	}
+
+	return labels
+}
+
+func TestOptionalRelabelingsAreOnlyUsedIfFieldIsInFormat(t *testing.T) {
+	t.Parallel()
+
+	withoutGeoIP := NewNamespaceRelabelings(&config.NamespaceConfig{
+		Format: `$remote_addr "$request" $status`,
+	})
+	assert.Equal(t, []string{"method", "status"}, targetLabels(withoutGeoIP))
+
+	withGeoIP := NewNamespaceRelabelings(&config.NamespaceConfig{
+		Format: `$remote_addr "$request" $status $geoip_country_code`,
+	})
+	assert.Equal(t, []string{"method", "status", "country"}, targetLabels(withGeoIP))
+}
+
+func TestOptionalRelabelingsAreMapped(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		format   string
+		labels   []string
+		mappings map[string]string
+	}{
+		{
+			format: `"$request" $status $limit_req_status`,
+			labels: []string{"method", "status", "ratelimit"},
+			mappings: map[string]string{
+				"PASSED":   "PASSED",
+				"REJECTED": "REJECTED",
+				"-":        "unknown",
+				"":         "unknown",
+				"FOO":      "other",
+			},
+		},
+		{
+			format: `$scheme "$request" $status`,
+			labels: []string{"method", "status", "scheme"},
+			mappings: map[string]string{
+				"http":  "http",
+				"https": "https",
+				"ftp":   "other",
+			},
+		},
+		{
+			format:   `$scheme $server_port "$request" $status`,
+			labels:   []string{"method", "status", "scheme", "server_port"},
+			mappings: map[string]string{"8443": "8443"},
+		},
+	}
+
+	for _, test := range tests {
+		relabelings := NewNamespaceRelabelings(&config.NamespaceConfig{Format: test.format})
+		assert.Equal(t, test.labels, targetLabels(relabelings), test.format)
+
+		// The relabeling under test is the last one
+		r := relabelings[len(relabelings)-1]
+		for value, expected := range test.mappings {
+			assertMapping(t, r, value, expected)
+		}
+	}
+}
+
+func TestNamespaceRelabelingsDoNotShareState(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.NamespaceConfig{Format: "$geoip_country_code"}
+
+	a := NewNamespaceRelabelings(cfg)
+	b := NewNamespaceRelabelings(cfg)
+
+	assert.NotSame(t, a[2].limiter, b[2].limiter)
+}
+
+func TestMaxValuesIsCappedPerLabel(t *testing.T) {
+	t.Parallel()
+
+	relabelings := NewNamespaceRelabelings(&config.NamespaceConfig{
+		Format: `"$request" $status "$http_x_tenant_id" "$http_x_region"`,
+		RelabelConfigs: []config.RelabelConfig{
+			{TargetLabel: "tenant", SourceValue: "http_x_tenant_id", MaxValues: 2},
+			{TargetLabel: "region", SourceValue: "http_x_region", MaxValues: 1, OverflowValue: "overflow"},
+		},
+	})
+	tenant, region := relabelings[0], relabelings[1]
+
+	for value, expected := range map[string]string{"t1": "t1", "t2": "t2"} {
+		mapped, err := tenant.Map(value)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, mapped)
+	}
+
+	// The region's cap is reached independently of the tenant's values
+	mapped, _ := region.Map("eu")
+	assert.Equal(t, "eu", mapped)
+	mapped, _ = region.Map("us")
+	assert.Equal(t, "overflow", mapped)
+
+	mapped, _ = tenant.Map("t3")
+	assert.Equal(t, "other", mapped)
+	mapped, _ = tenant.Map("t1")
+	assert.Equal(t, "t1", mapped)
+}
+
+func TestInvalidUTF8IsSanitizedIfEnabled(t *testing.T) {
+	t.Parallel()
+
+	relabelings := NewNamespaceRelabelings(&config.NamespaceConfig{
+		Format:         `$remote_addr "$request" $status`,
+		RelabelConfigs: []config.RelabelConfig{{TargetLabel: "path", SourceValue: "request", Split: 2}},
+		SanitizeUTF8:   true,
+	})
+
+	mapped, err := relabelings[0].Map("GET /users\xff\xfe/1 HTTP/1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/users\uFFFD/1", mapped)
+
+	mapped, err = relabelings[0].Map("GET /users/1 HTTP/1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/users/1", mapped)
+}
+
+func TestInvalidUTF8IsReplacedWithConfiguredReplacement(t *testing.T) {
+	t.Parallel()
+
+	replacement := "?"
+	relabelings := NewNamespaceRelabelings(&config.NamespaceConfig{
+		Format:          `$remote_addr $remote_user "$request" $status`,
+		RelabelConfigs:  []config.RelabelConfig{{TargetLabel: "user", SourceValue: "remote_user"}},
+		SanitizeUTF8:    true,
+		UTF8Replacement: &replacement,
+	})
+
+	mapped, err := relabelings[0].Map("us\xc3er\x80")
+	assert.Nil(t, err)
+	assert.Equal(t, "us?er?", mapped)
+}
+
+func TestInvalidUTF8IsKeptIfNotEnabled(t *testing.T) {
+	t.Parallel()
+
+	relabelings := NewNamespaceRelabelings(&config.NamespaceConfig{
+		Format:         `$remote_addr "$request" $status`,
+		RelabelConfigs: []config.RelabelConfig{{TargetLabel: "path", SourceValue: "request", Split: 2}},
+	})
+
+	mapped, err := relabelings[0].Map("GET /users\xff/1 HTTP/1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/users\xff/1", mapped)
+}
+
+func TestQueryParamsAreMasked(t *testing.T) {
+	t.Parallel()
+
+	relabelings := NewNamespaceRelabelings(&config.NamespaceConfig{
+		Format:          `$remote_addr "$request" $status`,
+		RelabelConfigs:  []config.RelabelConfig{{TargetLabel: "path", SourceValue: "request", Split: 2, MaxValues: 1}},
+		MaskQueryParams: []string{"token", "api_key"},
+	})
+
+	mapped, err := relabelings[0].Map("GET /login?user=foo&token=secret&api%5Fkey=123#top HTTP/1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/login?user=foo&token=***&api%5Fkey=***#top", mapped)
+
+	// Masking happens before the cardinality limit, so that different
+	// tokens do not count as different values
+	mapped, err = relabelings[0].Map("GET /login?user=foo&token=other&api%5Fkey=456#top HTTP/1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/login?user=foo&token=***&api%5Fkey=***#top", mapped)
+
+	mapped, err = relabelings[0].Map("GET /users?token HTTP/1.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "other", mapped)
+}
+
+func TestDynamicLabelIsReadFromFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "dynamic")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "release")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("v1.2.3\n"), 0644))
+
+	relabelings := NewNamespaceRelabelings(&config.NamespaceConfig{
+		Format:                       `$remote_addr "$request" $status`,
+		DynamicLabels:                map[string]string{"release": file},
+		DynamicLabelIntervalDuration: time.Hour,
+	})
+
+	var release *Relabeling
+	for _, r := range relabelings {
+		if r.TargetLabel == "release" {
+			release = r
+		}
+	}
+
+	if !assert.NotNil(t, release) {
+		return
+	}
+
+	value, ok := release.Source(map[string]string{})
+	assert.True(t, ok)
+	assert.Equal(t, "v1.2.3", value)
+
+	// The file is only re-read after the interval
+	assert.Nil(t, ioutil.WriteFile(file, []byte("v1.2.4\n"), 0644))
+	value, _ = release.Source(map[string]string{})
+	assert.Equal(t, "v1.2.3", value)
+
+	assert.Equal(t, "v1.2.4", release.file.get(time.Now().Add(2*time.Hour)))
+
+	// The previous value is kept if the file cannot be read
+	assert.Nil(t, os.Remove(file))
+	assert.Equal(t, "v1.2.4", release.file.get(time.Now().Add(4*time.Hour)))
+}
+
+func TestPathRootIsLimitedToWhitelist(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.NamespaceConfig{
+		Name:     "foo",
+		Format:   `$remote_addr "$request" $status`,
+		PathRoot: &config.PathRootConfig{Whitelist: []string{"api", "static"}},
+	}
+	assert.Nil(t, cfg.Compile())
+
+	relabelings := NewNamespaceRelabelings(cfg)
+	assert.Equal(t, []string{"path_root", "method", "status"}, targetLabels(relabelings))
+
+	for value, expected := range map[string]string{
+		"GET /api/v1/users HTTP/1.1":  "api",
+		"GET /static/app.js HTTP/1.1": "static",
+		"GET /admin HTTP/1.1":         "other",
+	} {
+		mapped, err := relabelings[0].Map(value)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, mapped)
+	}
+}
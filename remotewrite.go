@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+const (
+	// remoteWriteMaxRetries is the number of times a failed request is
+	// retried before the samples are dropped
+	remoteWriteMaxRetries = 3
+
+	// remoteWriteInitialBackoff is the delay before the first retry; it is
+	// doubled for every further retry
+	remoteWriteInitialBackoff = time.Second
+)
+
+// remoteWriteSeries is a single time series of a remote write request
+type remoteWriteSeries struct {
+	labels    []*dto.LabelPair
+	value     float64
+	timestamp int64
+}
+
+// remoteWriter periodically sends the gathered metrics to an endpoint using
+// the Prometheus remote write protocol
+type remoteWriter struct {
+	url         string
+	username    string
+	password    string
+	bearerToken string
+	interval    time.Duration
+	gatherer    prometheus.Gatherer
+	client      *http.Client
+}
+
+func newRemoteWriter(cfg *config.RemoteWriteConfig, gatherer prometheus.Gatherer) (*remoteWriter, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("remote_write url must not be empty")
+	}
+
+	interval, err := cfg.IntervalOrDefault()
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteWriter{
+		url:         cfg.URL,
+		username:    cfg.Username,
+		password:    cfg.Password,
+		bearerToken: cfg.BearerToken,
+		interval:    interval,
+		gatherer:    gatherer,
+		client:      &http.Client{Timeout: interval},
+	}, nil
+}
+
+// run sends the metrics at every interval until stopChan is closed
+func (w *remoteWriter) run(stopChan <-chan bool) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			if err := w.write(stopChan); err != nil {
+				fmt.Printf("error while sending metrics via remote write: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// write gathers the metrics and sends them, retrying with exponential backoff
+// if the request fails with a network error or a server error
+func (w *remoteWriter) write(stopChan <-chan bool) error {
+	families, err := w.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return err
+	}
+
+	body := snappy.Encode(nil, encodeWriteRequest(remoteWriteSeriesOf(families, time.Now())))
+	backoff := remoteWriteInitialBackoff
+
+	for attempt := 0; ; attempt++ {
+		retryable, err := w.send(body)
+		if err == nil {
+			return nil
+		}
+
+		if !retryable || attempt == remoteWriteMaxRetries {
+			return err
+		}
+
+		fmt.Printf("remote write failed, retrying in %s: %s\n", backoff, err.Error())
+
+		select {
+		case <-stopChan:
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+// send sends a compressed write request, returning whether a failed request
+// may be retried
+func (w *remoteWriter) send(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	if w.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.bearerToken)
+	} else if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("server responded with %s: %s", resp.Status, bytes.TrimSpace(msg))
+
+		return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+	}
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return false, nil
+}
+
+// remoteWriteSeriesOf converts gathered metric families into time series, the
+// same way they are exposed for scraping (histograms and summaries become
+// several series with the "_bucket", "_sum" and "_count" suffixes)
+func remoteWriteSeriesOf(families []*dto.MetricFamily, now time.Time) []remoteWriteSeries {
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	series := make([]remoteWriteSeries, 0, len(families))
+
+	add := func(name string, labels []*dto.LabelPair, value float64, extra ...*dto.LabelPair) {
+		all := make([]*dto.LabelPair, 0, len(labels)+len(extra)+1)
+		all = append(all, &dto.LabelPair{Name: stringPtr("__name__"), Value: stringPtr(name)})
+		all = append(all, labels...)
+		all = append(all, extra...)
+
+		// Remote write requires the labels to be sorted by name
+		sort.Slice(all, func(i, j int) bool { return all[i].GetName() < all[j].GetName() })
+
+		series = append(series, remoteWriteSeries{labels: all, value: value, timestamp: timestamp})
+	}
+
+	for _, f := range families {
+		name := f.GetName()
+
+		for _, m := range f.Metric {
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.Label, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.Label, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.Label, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.Bucket {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+
+					add(name+"_bucket", m.Label, float64(b.GetCumulativeCount()), labelPair("le", formatFloat(b.GetUpperBound())))
+				}
+				add(name+"_bucket", m.Label, float64(h.GetSampleCount()), labelPair("le", "+Inf"))
+				add(name+"_sum", m.Label, h.GetSampleSum())
+				add(name+"_count", m.Label, float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add(name, m.Label, q.GetValue(), labelPair("quantile", formatFloat(q.GetQuantile())))
+				}
+				add(name+"_sum", m.Label, s.GetSampleSum())
+				add(name+"_count", m.Label, float64(s.GetSampleCount()))
+			}
+		}
+	}
+
+	return series
+}
+
+// encodeWriteRequest encodes time series as a remote write WriteRequest
+// protobuf message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []remoteWriteSeries) []byte {
+	var req, ts, buf []byte
+
+	for _, s := range series {
+		ts = ts[:0]
+
+		for _, l := range s.labels {
+			buf = buf[:0]
+			buf = appendProtoBytes(buf, 1, []byte(l.GetName()))
+			buf = appendProtoBytes(buf, 2, []byte(l.GetValue()))
+			ts = appendProtoBytes(ts, 1, buf)
+		}
+
+		buf = buf[:0]
+		buf = appendProtoKey(buf, 1, 1) // fixed64
+		buf = appendUint64LE(buf, math.Float64bits(s.value))
+		buf = appendProtoKey(buf, 2, 0) // varint
+		buf = appendUvarint(buf, uint64(s.timestamp))
+		ts = appendProtoBytes(ts, 2, buf)
+
+		req = appendProtoBytes(req, 1, ts)
+	}
+
+	return req
+}
+
+func appendProtoKey(b []byte, field int, wireType int) []byte {
+	return appendUvarint(b, uint64(field<<3|wireType))
+}
+
+// appendProtoBytes appends a length-delimited field
+func appendProtoBytes(b []byte, field int, value []byte) []byte {
+	b = appendProtoKey(b, field, 2)
+	b = appendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendUint64LE(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func labelPair(name string, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: stringPtr(name), Value: stringPtr(value)}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// formatFloat formats "le" and "quantile" label values like the Prometheus
+// text format
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+func remoteWriteTestRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total", Help: "Requests"}, []string{"status"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "Seconds", Buckets: []float64{0.1, 1}})
+
+	registry.MustRegister(counter, histogram)
+
+	counter.WithLabelValues("200").Add(3)
+	counter.WithLabelValues("500").Add(1.5)
+	histogram.Observe(0.05)
+	histogram.Observe(5)
+
+	return registry
+}
+
+// remoteWriteSamples maps the series of a decoded write request, in the text
+// format's notation, to their samples
+func remoteWriteSamples(req *prompb.WriteRequest) map[string][]prompb.Sample {
+	samples := make(map[string][]prompb.Sample)
+
+	for _, ts := range req.Timeseries {
+		key := ""
+		for _, l := range ts.Labels {
+			key += l.Name + "=" + l.Value + ","
+		}
+
+		samples[key] = ts.Samples
+	}
+
+	return samples
+}
+
+func TestWriteRequestIsDecodedByPrometheus(t *testing.T) {
+	families, err := remoteWriteTestRegistry().Gather()
+	require.Nil(t, err)
+
+	now := time.Unix(1000, 0)
+
+	var req prompb.WriteRequest
+	require.Nil(t, req.Unmarshal(encodeWriteRequest(remoteWriteSeriesOf(families, now))))
+
+	assert.Equal(t, map[string][]prompb.Sample{
+		"__name__=test_requests_total,status=200,": {{Value: 3, Timestamp: 1000000}},
+		"__name__=test_requests_total,status=500,": {{Value: 1.5, Timestamp: 1000000}},
+		"__name__=test_seconds_bucket,le=0.1,":     {{Value: 1, Timestamp: 1000000}},
+		"__name__=test_seconds_bucket,le=1,":       {{Value: 1, Timestamp: 1000000}},
+		"__name__=test_seconds_bucket,le=+Inf,":    {{Value: 2, Timestamp: 1000000}},
+		"__name__=test_seconds_sum,":               {{Value: 5.05, Timestamp: 1000000}},
+		"__name__=test_seconds_count,":             {{Value: 2, Timestamp: 1000000}},
+	}, remoteWriteSamples(&req))
+}
+
+func TestRemoteWriterSendsCompressedWriteRequest(t *testing.T) {
+	var received []*prompb.WriteRequest
+	var headers []http.Header
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, _ := ioutil.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var req prompb.WriteRequest
+		if err := req.Unmarshal(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		received = append(received, &req)
+		headers = append(headers, r.Header)
+	}))
+	defer server.Close()
+
+	writer, err := newRemoteWriter(&config.RemoteWriteConfig{URL: server.URL, BearerToken: "token"}, remoteWriteTestRegistry())
+	require.Nil(t, err)
+
+	require.Nil(t, writer.write(make(chan bool)))
+
+	require.Len(t, received, 1)
+	assert.Equal(t, "snappy", headers[0].Get("Content-Encoding"))
+	assert.Equal(t, "Bearer token", headers[0].Get("Authorization"))
+	assert.Len(t, received[0].Timeseries, 7)
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setReusePort fails on platforms without SO_REUSEPORT
+func setReusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("reuse_port is not supported on this platform")
+}
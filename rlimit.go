@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// fdHeadroom is the number of file descriptors that are needed in addition to
+// one per log source (for example, for HTTP connections and syslog listeners)
+const fdHeadroom = 64
+
+// checkFDLimit tests if the process's open file limit suffices for the given
+// number of log sources. If raise is set, a too low soft limit is raised
+// towards the hard limit first.
+func checkFDLimit(sourceCount int, raise bool) error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return fmt.Errorf("could not determine open file limit: %s", err.Error())
+	}
+
+	needed := uint64(sourceCount + fdHeadroom)
+	if limit.Cur >= needed {
+		return nil
+	}
+
+	if raise && limit.Max > limit.Cur {
+		previous := limit.Cur
+		limit.Cur = limit.Max
+
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+			fmt.Printf("could not raise open file limit: %s\n", err.Error())
+		} else {
+			fmt.Printf("raised open file limit from %d to %d\n", previous, limit.Cur)
+			if limit.Cur >= needed {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("the open file limit (%d) is too low for %d log sources; at least %d are recommended (use `ulimit -n` or LimitNOFILE= in the systemd unit to raise it)", limit.Cur, sourceCount, needed)
+}
@@ -0,0 +1,7 @@
+package main
+
+// checkFDLimit is a no-op on Windows, which has no open file limit comparable
+// to RLIMIT_NOFILE
+func checkFDLimit(sourceCount int, raise bool) error {
+	return nil
+}
@@ -0,0 +1,280 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 hash of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Client is a minimal client for S3-compatible object stores, which can only
+// list and read objects. Requests are signed with AWS Signature Version 4, or
+// sent anonymously if no access key is set.
+type Client struct {
+	// Endpoint is the URL of an S3-compatible store (like
+	// "http://minio:9000"), whose buckets are addressed by path. If empty,
+	// AWS S3 of the region is used, addressing buckets by host name.
+	Endpoint string
+	Region   string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	HTTPClient *http.Client
+
+	// now returns the current time; replaced in tests
+	now func() time.Time
+}
+
+// listBucketResult is the response of the ListObjectsV2 operation
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// errorResponse is the body of failed S3 requests
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// ListObjects returns the keys of all objects in a bucket that start with the
+// prefix, in lexical order
+func (c *Client) ListObjects(bucket string, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do(bucket, "", query)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("could not decode object list of bucket %s: %s", bucket, err.Error())
+		}
+
+		for _, o := range result.Contents {
+			keys = append(keys, o.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+// GetObject returns the content of an object, which needs to be closed by the
+// caller
+func (c *Client) GetObject(bucket string, key string) (io.ReadCloser, error) {
+	resp, err := c.do(bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// do sends a signed GET request for an object (or the bucket itself, if key is
+// empty). Responses with a status other than 200 are returned as error.
+func (c *Client) do(bucket string, key string, query url.Values) (*http.Response, error) {
+	u, err := c.objectURL(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.AccessKeyID != "" {
+		c.sign(req)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		var e errorResponse
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+			return nil, fmt.Errorf("request for s3://%s/%s failed: %s (%s)", bucket, key, e.Code, e.Message)
+		}
+
+		return nil, fmt.Errorf("request for s3://%s/%s failed with status %s", bucket, key, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// objectURL returns the URL of an object (without query string)
+func (c *Client) objectURL(bucket string, key string) (*url.URL, error) {
+	if c.Endpoint == "" {
+		return &url.URL{
+			Scheme:  "https",
+			Host:    fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, c.Region),
+			Path:    "/" + key,
+			RawPath: "/" + escapePath(key),
+		}, nil
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %s", err.Error())
+	}
+
+	u.Path += "/" + bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+
+	return u, nil
+}
+
+// sign adds the headers of AWS Signature Version 4 to a request without body
+func (c *Client) sign(req *http.Request) {
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// escape encodes all characters of a string except the unreserved ones of
+// RFC 3986 (and the slash, if keepSlash is set), as required for signing
+func escape(s string, keepSlash bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func escapePath(path string) string {
+	return escape(path, true)
+}
+
+// canonicalQuery encodes the query parameters sorted by name, which is both
+// the canonical form for signing and a valid query string
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, escape(name, false)+"="+escape(value, false))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// seriesLimiter keeps track of the label value combinations of a namespace,
+// and determines the least recently seen combination to evict once more than
+// maxSeries combinations were seen
+type seriesLimiter struct {
+	maxSeries int
+
+	lock sync.Mutex
+	// order contains the label values of all combinations, least recently
+	// seen first
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newSeriesLimiter(maxSeries int) *seriesLimiter {
+	return &seriesLimiter{
+		maxSeries: maxSeries,
+		order:     list.New(),
+		entries:   make(map[string]*list.Element),
+	}
+}
+
+// touch marks a label value combination as recently seen. If this exceeds the
+// maximum number of combinations, the evicted combination is returned; nil
+// otherwise.
+func (l *seriesLimiter) touch(labelValues []string) []string {
+	key := strings.Join(labelValues, "\xff")
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if e, ok := l.entries[key]; ok {
+		l.order.MoveToBack(e)
+		return nil
+	}
+
+	// the label values are reused for the next line, so they must be copied
+	values := make([]string, len(labelValues))
+	copy(values, labelValues)
+
+	l.entries[key] = l.order.PushBack(values)
+
+	if l.order.Len() <= l.maxSeries {
+		return nil
+	}
+
+	oldest := l.order.Front()
+	l.order.Remove(oldest)
+
+	evicted := oldest.Value.([]string)
+	delete(l.entries, strings.Join(evicted, "\xff"))
+
+	return evicted
+}
+
+// deleteSeries removes a label value combination from all metrics that are
+// labeled by the namespace's labels
+func (m *Metrics) deleteSeries(labelValues []string) {
+	m.countTotal.DeleteLabelValues(labelValues...)
+	m.bytesTotal.DeleteLabelValues(labelValues...)
+	m.upstreamSeconds.DeleteLabelValues(labelValues...)
+	m.upstreamSecondsHist.DeleteLabelValues(labelValues...)
+	m.responseSeconds.DeleteLabelValues(labelValues...)
+	m.responseSecondsHist.DeleteLabelValues(labelValues...)
+	m.responseSizeHist.DeleteLabelValues(labelValues...)
+
+	for _, n := range m.numericMetrics {
+		n.delete(labelValues)
+	}
+}
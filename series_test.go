@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesLimiterEvictsLeastRecentlySeen(t *testing.T) {
+	l := newSeriesLimiter(2)
+
+	assert.Nil(t, l.touch([]string{"GET", "200"}))
+	assert.Nil(t, l.touch([]string{"GET", "404"}))
+
+	// Seeing a combination again makes it the most recently seen one
+	assert.Nil(t, l.touch([]string{"GET", "200"}))
+
+	assert.Equal(t, []string{"GET", "404"}, l.touch([]string{"POST", "200"}))
+	assert.Equal(t, []string{"GET", "200"}, l.touch([]string{"GET", "500"}))
+
+	// An evicted combination counts as new when it is seen again
+	assert.Equal(t, []string{"POST", "200"}, l.touch([]string{"GET", "404"}))
+}
+
+func TestSeriesLimiterCopiesLabelValues(t *testing.T) {
+	l := newSeriesLimiter(1)
+
+	labelValues := []string{"GET", "200"}
+	l.touch(labelValues)
+	labelValues[1] = "404"
+
+	assert.Equal(t, []string{"GET", "200"}, l.touch(labelValues))
+}
+
+const maxSeriesConfig = `
+namespace "test" {
+  format = "$status $request_time $http_user_agent"
+  max_series = 2
+
+  relabel "user_agent" {
+    from = "http_user_agent"
+  }
+}
+`
+
+func TestEvictedSeriesAreRemovedFromMetrics(t *testing.T) {
+	nsMetrics := loadNamespace(t, maxSeriesConfig)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	require.True(t, p.process(`200 0.1 curl`))
+	require.True(t, p.process(`200 0.2 wget`))
+	require.True(t, p.process(`200 0.3 curl`))
+	require.True(t, p.process(`200 0.4 firefox`))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(nsMetrics.seriesEvictedTotal))
+
+	assert.Equal(t, map[string]float64{
+		"curl":    2,
+		"firefox": 1,
+	}, metricValues(t, nsMetrics, "test_http_response_count_total", "user_agent"))
+
+	assert.Equal(t, map[string]float64{
+		"curl":    2,
+		"firefox": 1,
+	}, metricValues(t, nsMetrics, "test_http_response_time_seconds_hist", "user_agent"))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/tail"
+)
+
+// channelFollower is a follower that emits the lines sent to its channel, and
+// ends (like a failed follower) when the channel is closed
+type channelFollower struct {
+	source string
+	lines  chan string
+}
+
+func (f *channelFollower) Lines() chan string {
+	return f.lines
+}
+
+func (f *channelFollower) OnError(func(error)) {
+}
+
+func (f *channelFollower) Source() string {
+	return f.source
+}
+
+const sourceUpConfig = `
+namespace "sourceup" {
+  format = "$status"
+}
+`
+
+func TestSourceUpFollowsTheFollowerLifecycle(t *testing.T) {
+	var cfg config.Config
+	require.Nil(t, config.LoadConfigFromStream(&cfg, strings.NewReader(sourceUpConfig), config.TypeHCL))
+
+	nsMetrics := NewNSMetrics(&cfg.Namespaces[0], nil)
+	up := sourceUp.WithLabelValues("sourceup", "access.log")
+
+	// The second round follows the source again after it has ended
+	for i := 0; i < 2; i++ {
+		f := &channelFollower{source: "access.log", lines: make(chan string)}
+
+		done := make(chan struct{})
+		go func() {
+			processSource(f, nsMetrics, false)
+			close(done)
+		}()
+
+		// Once a line has been received, the follower is running
+		f.lines <- "200"
+		assert.Equal(t, float64(1), testutil.ToFloat64(up))
+
+		close(f.lines)
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("source was not processed completely")
+		}
+
+		assert.Equal(t, float64(0), testutil.ToFloat64(up))
+	}
+}
+
+func TestFailingSourceEndsWithoutCrashing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	nsCfg := config.NamespaceConfig{
+		Name:   "failing",
+		Format: "$status",
+		SourceData: config.SourceData{
+			S3: &config.S3Source{Bucket: "logs", Prefix: "web/", Endpoint: server.URL},
+		},
+	}
+	require.Nil(t, nsCfg.Compile())
+
+	nsMetrics := NewNSMetrics(&nsCfg, nil)
+
+	var done sync.WaitGroup
+	processNamespace(nsCfg, nsMetrics, tail.FileFollowerOptions{Oneshot: true}, &done)
+
+	finished := make(chan struct{})
+	go func() {
+		done.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("failing source did not end")
+	}
+
+	assert.Equal(t, float64(0), testutil.ToFloat64(sourceUp.WithLabelValues("failing", "s3://logs/web/")))
+}
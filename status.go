@@ -0,0 +1,139 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/tail"
+)
+
+// statusWindowSeconds is the window over which the rates on the status page
+// are averaged
+const statusWindowSeconds = 60
+
+// statusRefreshSeconds is the interval in which browsers reload the status
+// page
+const statusRefreshSeconds = 5
+
+// lineStatus counts the lines and parse errors of a namespace for the status
+// page. It is carried over when the namespace is reset, so that the rates do
+// not start over on a configuration reload.
+type lineStatus struct {
+	lock        sync.Mutex
+	lines       *secondRing
+	parseErrors *secondRing
+	lastLine    time.Time
+}
+
+func newLineStatus() *lineStatus {
+	return &lineStatus{
+		lines:       newSecondRing(statusWindowSeconds),
+		parseErrors: newSecondRing(statusWindowSeconds),
+	}
+}
+
+func (s *lineStatus) observe(failed bool, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lines.add(now)
+	if failed {
+		s.parseErrors.add(now)
+	}
+
+	s.lastLine = now
+}
+
+// rates returns the lines and parse errors per second within the window, and
+// the time of the last line
+func (s *lineStatus) rates(now time.Time) (float64, float64, time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return float64(s.lines.sum(now)) / statusWindowSeconds, float64(s.parseErrors.sum(now)) / statusWindowSeconds, s.lastLine
+}
+
+// namespaceStatus is a row of the status page
+type namespaceStatus struct {
+	Name           string
+	LineRate       float64
+	ParseErrorRate float64
+	LastLineAge    string
+	ActiveSources  int
+	PausedSources  int
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>nginxlog-exporter status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: .3em 1em; border-bottom: 1px solid #ddd; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>nginxlog-exporter status</h1>
+<p>Rates are averaged over the last {{.Window}} seconds. Follower goroutines: {{.Followers}}</p>
+<table>
+<tr><th>Namespace</th><th>Lines/s</th><th>Parse errors/s</th><th>Last line</th><th>Active sources</th><th>Paused sources</th></tr>
+{{range .Namespaces}}<tr><td>{{.Name}}</td><td>{{printf "%.2f" .LineRate}}</td><td>{{printf "%.2f" .ParseErrorRate}}</td><td>{{.LastLineAge}}</td><td>{{.ActiveSources}}</td><td>{{.PausedSources}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// statusHandler serves an HTML page with the current line and parse error
+// rates, the age of the last line and the number of active log sources of
+// each namespace
+func statusHandler(nsMetricsByName map[string]*NSMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := make([]string, 0, len(nsMetricsByName))
+		for name := range nsMetricsByName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		now := time.Now()
+		namespaces := make([]namespaceStatus, 0, len(names))
+
+		for _, name := range names {
+			nsMetrics := nsMetricsByName[name]
+
+			nsMetrics.lock.RLock()
+			lineRate, parseErrorRate, lastLine := nsMetrics.status.rates(now)
+			nsMetrics.lock.RUnlock()
+
+			age := "never"
+			if !lastLine.IsZero() {
+				age = now.Sub(lastLine).Truncate(time.Second).String() + " ago"
+			}
+
+			active, paused := pausableSources.count(name)
+
+			namespaces = append(namespaces, namespaceStatus{
+				Name:           name,
+				LineRate:       lineRate,
+				ParseErrorRate: parseErrorRate,
+				LastLineAge:    age,
+				ActiveSources:  active,
+				PausedSources:  paused,
+			})
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = statusTemplate.Execute(w, map[string]interface{}{
+			"Refresh":    statusRefreshSeconds,
+			"Window":     statusWindowSeconds,
+			"Followers":  tail.Tracker.Count(),
+			"Namespaces": namespaces,
+		})
+	})
+}
@@ -0,0 +1,233 @@
+package tail
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// journaldCursorSaveInterval is the interval in which the cursor file is
+// updated
+const journaldCursorSaveInterval = 5 * time.Second
+
+// journaldMaxLineSize is the maximum size of a single journal entry in JSON
+// encoding
+const journaldMaxLineSize = 1024 * 1024
+
+type journaldFollower struct {
+	units      []string
+	cursorFile string
+	bufferSize int
+	line       chan string
+	onError    func(error)
+
+	lock   sync.Mutex
+	cursor string
+	saved  string
+}
+
+// journaldEntry is a journal entry as printed by "journalctl -o json". The
+// message is usually a string, but is encoded as array of bytes if it is not
+// valid UTF-8.
+type journaldEntry struct {
+	Cursor  string          `json:"__CURSOR"`
+	Message json.RawMessage `json:"MESSAGE"`
+}
+
+// NewJournaldFollower builds a new follower that reads the messages of the
+// given systemd units from the journal, using journalctl. If cursorFile is
+// set, the position of the last read message is stored in this file, and
+// reading continues after this message when the follower is started again.
+// If bufferSize is not zero, journalctl's output is read with a buffer of this
+// size (instead of 64KiB), which also raises the maximum size of an entry if
+// it is larger than 1MiB.
+func NewJournaldFollower(units []string, cursorFile string, bufferSize int) (Follower, error) {
+	if _, err := exec.LookPath("journalctl"); err != nil {
+		return nil, fmt.Errorf("journald source requires journalctl: %s", err.Error())
+	}
+
+	f := &journaldFollower{
+		units:      units,
+		cursorFile: cursorFile,
+		bufferSize: bufferSize,
+		line:       make(chan string),
+	}
+
+	if cursorFile != "" {
+		cursor, err := ioutil.ReadFile(cursorFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		f.cursor = strings.TrimSpace(string(cursor))
+	}
+
+	return f, nil
+}
+
+func (f *journaldFollower) Source() string {
+	return "journald:" + strings.Join(f.units, ",")
+}
+
+func (f *journaldFollower) OnError(cb func(error)) {
+	f.onError = cb
+}
+
+func (f *journaldFollower) args() []string {
+	args := []string{"--follow", "--output=json", "--no-pager"}
+
+	for _, u := range f.units {
+		args = append(args, "--unit="+u)
+	}
+
+	// Without a cursor, only messages written after the start are read, just
+	// like for files
+	if f.cursor != "" {
+		args = append(args, "--after-cursor="+f.cursor)
+	} else {
+		args = append(args, "--lines=0")
+	}
+
+	return args
+}
+
+func (f *journaldFollower) Lines() chan string {
+	Tracker.register()
+
+	go func() {
+		defer Tracker.deregister()
+		defer close(f.line)
+
+		err := f.follow()
+		if err == nil {
+			err = fmt.Errorf("journalctl exited unexpectedly")
+		}
+
+		if f.onError != nil {
+			f.onError(err)
+		}
+	}()
+	return f.line
+}
+
+func (f *journaldFollower) follow() error {
+	cmd := exec.Command("journalctl", f.args()...)
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if f.cursorFile != "" {
+		go f.saveCursorPeriodically(stop)
+	}
+
+	bufferSize := 64 * 1024
+	if f.bufferSize > 0 {
+		bufferSize = f.bufferSize
+	}
+
+	maxLineSize := journaldMaxLineSize
+	if bufferSize > maxLineSize {
+		maxLineSize = bufferSize
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, bufferSize), maxLineSize)
+
+	for scanner.Scan() {
+		var entry journaldEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		if message, ok := decodeJournaldMessage(entry.Message); ok {
+			f.line <- message
+		}
+
+		f.lock.Lock()
+		f.cursor = entry.Cursor
+		f.lock.Unlock()
+	}
+
+	scanErr := scanner.Err()
+	waitErr := cmd.Wait()
+	f.saveCursor()
+
+	if scanErr != nil {
+		return scanErr
+	}
+
+	return waitErr
+}
+
+func (f *journaldFollower) saveCursorPeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(journaldCursorSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			f.saveCursor()
+		}
+	}
+}
+
+// saveCursor writes the current cursor to the cursor file if it has changed.
+// The file is replaced atomically, so that it is never left half-written.
+func (f *journaldFollower) saveCursor() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.cursorFile == "" || f.cursor == "" || f.cursor == f.saved {
+		return
+	}
+
+	tmp := f.cursorFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(f.cursor+"\n"), 0644); err != nil {
+		fmt.Printf("error while writing journald cursor file %s: %s\n", f.cursorFile, err.Error())
+		return
+	}
+
+	if err := os.Rename(tmp, f.cursorFile); err != nil {
+		fmt.Printf("error while writing journald cursor file %s: %s\n", f.cursorFile, err.Error())
+		return
+	}
+
+	f.saved = f.cursor
+}
+
+func decodeJournaldMessage(raw json.RawMessage) (string, bool) {
+	var message string
+	if err := json.Unmarshal(raw, &message); err == nil {
+		return message, true
+	}
+
+	var bytes []byte
+	var ints []int
+	if err := json.Unmarshal(raw, &ints); err != nil {
+		return "", false
+	}
+
+	for _, b := range ints {
+		bytes = append(bytes, byte(b))
+	}
+
+	return string(bytes), true
+}
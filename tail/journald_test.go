@@ -0,0 +1,100 @@
+package tail
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// journaldOutput is canned "journalctl -o json" output; the second message is
+// not valid UTF-8, and thus encoded as array of bytes
+const journaldOutput = `{"__CURSOR":"s=1;i=1","MESSAGE":"GET / 200","_SYSTEMD_UNIT":"nginx.service"}
+{"__CURSOR":"s=1;i=2","MESSAGE":[71,69,84,32,47,255,32,52,48,52],"_SYSTEMD_UNIT":"nginx.service"}
+`
+
+// fakeJournalctl puts a journalctl script first in PATH, which records its
+// arguments in the returned file and prints the canned output. The returned
+// function restores PATH.
+func fakeJournalctl(t *testing.T, dir string) (string, func()) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake journalctl is a shell script")
+	}
+
+	output := filepath.Join(dir, "output.json")
+	require.Nil(t, ioutil.WriteFile(output, []byte(journaldOutput), 0644))
+
+	args := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + args + "\ncat " + output + "\n"
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "journalctl"), []byte(script), 0755))
+
+	path := os.Getenv("PATH")
+	require.Nil(t, os.Setenv("PATH", dir+string(os.PathListSeparator)+path))
+
+	return args, func() { os.Setenv("PATH", path) }
+}
+
+func TestJournaldFollowerResumesAfterSavedCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journald")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	args, restore := fakeJournalctl(t, dir)
+	defer restore()
+
+	cursorFile := filepath.Join(dir, "cursor")
+
+	f, err := NewJournaldFollower([]string{"nginx.service"}, cursorFile, 0)
+	require.Nil(t, err)
+
+	var followErr error
+	f.OnError(func(err error) { followErr = err })
+
+	assert.Equal(t, []string{"GET / 200", "GET /\xff 404"}, readAllLines(f))
+	assert.NotNil(t, followErr, "journalctl is not expected to exit")
+
+	called, err := ioutil.ReadFile(args)
+	require.Nil(t, err)
+	assert.Equal(t, "--follow --output=json --no-pager --unit=nginx.service --lines=0", strings.TrimSpace(string(called)))
+
+	cursor, err := ioutil.ReadFile(cursorFile)
+	require.Nil(t, err)
+	assert.Equal(t, "s=1;i=2\n", string(cursor))
+
+	// A new follower continues after the last message read before
+	f, err = NewJournaldFollower([]string{"nginx.service"}, cursorFile, 0)
+	require.Nil(t, err)
+	readAllLines(f)
+
+	called, err = ioutil.ReadFile(args)
+	require.Nil(t, err)
+	assert.Equal(t, "--follow --output=json --no-pager --unit=nginx.service --after-cursor=s=1;i=2", strings.TrimSpace(string(called)))
+}
+
+func TestJournaldMessagesAreDecoded(t *testing.T) {
+	tests := []struct {
+		raw     string
+		message string
+		ok      bool
+	}{
+		{`"GET / 200"`, "GET / 200", true},
+		{`[71,69,84,32,47,255]`, "GET /\xff", true},
+		{`[]`, "", true},
+		{``, "", false},
+		{`{"text":"GET / 200"}`, "", false},
+		{`["GET", "/"]`, "", false},
+	}
+
+	for _, test := range tests {
+		message, ok := decodeJournaldMessage(json.RawMessage(test.raw))
+
+		assert.Equal(t, test.ok, ok, test.raw)
+		assert.Equal(t, test.message, message, test.raw)
+	}
+}
@@ -0,0 +1,107 @@
+package tail
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/tokopedia/prometheus-nginxlog-exporter/s3"
+)
+
+type s3Follower struct {
+	client  *s3.Client
+	bucket  string
+	prefix  string
+	line    chan string
+	onError func(error)
+}
+
+// NewS3Follower builds a new follower that reads all objects below a prefix of
+// an S3 bucket once, in lexical order of their keys. Objects whose keys end in
+// ".gz" are decompressed. The follower's channel is closed after the last
+// object has been read.
+func NewS3Follower(client *s3.Client, bucket string, prefix string) (Follower, error) {
+	return &s3Follower{
+		client: client,
+		bucket: bucket,
+		prefix: prefix,
+		line:   make(chan string),
+	}, nil
+}
+
+func (f *s3Follower) Source() string {
+	return "s3://" + f.bucket + "/" + f.prefix
+}
+
+func (f *s3Follower) OnError(cb func(error)) {
+	f.onError = cb
+}
+
+func (f *s3Follower) Lines() chan string {
+	Tracker.register()
+
+	go func() {
+		defer Tracker.deregister()
+		defer close(f.line)
+
+		if err := f.readAll(); err != nil && f.onError != nil {
+			f.onError(err)
+		}
+	}()
+	return f.line
+}
+
+func (f *s3Follower) readAll() error {
+	keys, err := f.client.ListObjects(f.bucket, f.prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		// Directory placeholders created by some tools contain no lines
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+
+		if err := f.readObject(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *s3Follower) readObject(key string) error {
+	body, err := f.client.GetObject(f.bucket, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var r io.Reader = body
+	if strings.HasSuffix(key, ".gz") {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		r = gz
+	}
+
+	reader := bufio.NewReader(r)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			f.line <- line
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -4,4 +4,14 @@ package tail
 type Follower interface {
 	Lines() chan string
 	OnError(func(error))
+
+	// Source returns the name of the source the follower reads from (the file
+	// name for file followers, or the tag for syslog followers)
+	Source() string
+}
+
+// TransportFollower is implemented by followers that receive lines over the
+// network; Transport returns the protocol (like "tcp" or "udp")
+type TransportFollower interface {
+	Transport() string
 }
@@ -5,25 +5,36 @@ import (
 )
 
 type syslogFollower struct {
-	tag  string
-	line chan string
+	tag       string
+	transport string
+	line      chan string
 
 	channel syslog.LogPartsChannel
 	server  *syslog.Server
 }
 
 // NewSyslogFollower builds a new syslog follower from a previously constructed
-// syslog server & channel
-func NewSyslogFollower(tag string, server *syslog.Server, channel syslog.LogPartsChannel) (Follower, error) {
+// syslog server & channel. transport is the protocol that the server receives
+// messages with.
+func NewSyslogFollower(tag string, transport string, server *syslog.Server, channel syslog.LogPartsChannel) (Follower, error) {
 	s := &syslogFollower{
-		tag:     tag,
-		channel: channel,
-		line:    make(chan string),
-		server:  server,
+		tag:       tag,
+		transport: transport,
+		channel:   channel,
+		line:      make(chan string),
+		server:    server,
 	}
 	return s, nil
 }
 
+func (s *syslogFollower) Source() string {
+	return s.tag
+}
+
+func (s *syslogFollower) Transport() string {
+	return s.transport
+}
+
 func (s *syslogFollower) OnError(cb func(error)) {
 	go func() {
 		err := s.server.GetLastError()
@@ -34,7 +45,11 @@ func (s *syslogFollower) OnError(cb func(error)) {
 }
 
 func (s *syslogFollower) Lines() chan string {
+	Tracker.register()
+
 	go func() {
+		defer Tracker.deregister()
+
 		for line := range s.channel {
 			if _, ok := line["tag"].(string); !ok {
 				continue
@@ -1,48 +1,100 @@
 package tail
 
 import (
+	"fmt"
+	"math/rand"
 	"os"
+	"time"
 
 	"github.com/hpcloud/tail"
 )
 
+// FileFollowerOptions contains optional settings for file followers
+type FileFollowerOptions struct {
+	// ReopenBackoff is the maximum delay before a moved or deleted file (for
+	// example, after log rotation) is re-opened. The actual delay is chosen
+	// randomly, so that many files rotated at once are not re-opened at the
+	// same time. If zero, files are re-opened immediately.
+	ReopenBackoff time.Duration
+
+	// Oneshot causes the file to be read once from its beginning up to its
+	// current end, instead of being followed. The follower's channel is
+	// closed as soon as the end of the file has been reached.
+	Oneshot bool
+
+	// ReadFromStart causes all existing content of the file to be read
+	// before following new writes, instead of starting at the file's end
+	ReadFromStart bool
+
+	// ReadBufferBytes is the minimum size of the buffer that the file is
+	// read with. The tail library sizes its buffer by the maximum line length
+	// and splits longer lines, so the buffer is sized to the largest of
+	// ReadBufferBytes, MaxLineBytes and minSplitLineBytes instead. If zero,
+	// the library's default buffer is used and lines of any length are read.
+	ReadBufferBytes int
+
+	// MaxLineBytes is the length up to which lines are never split when
+	// ReadBufferBytes is set (the namespace's max_line_bytes guard)
+	MaxLineBytes int
+
+	// EOFGrace is the time to wait for a moved or deleted file to re-appear
+	// (for example, when a deployment replaces it). If the file re-appears
+	// within this time, it is re-opened; otherwise, it is considered gone and
+	// no longer followed. If zero, the follower waits indefinitely.
+	EOFGrace time.Duration
+}
+
+// minSplitLineBytes is the length up to which lines are never split when a
+// read buffer size is set
+const minSplitLineBytes = 1024 * 1024
+
+// eofGracePollInterval is the interval in which a moved or deleted file is
+// checked for during the EOF grace period
+const eofGracePollInterval = 100 * time.Millisecond
+
 type followerImpl struct {
 	filename string
+	opts     FileFollowerOptions
 	t        *tail.Tail
 	line     chan string
+	onError  func(error)
 }
 
-// NewFollower creates a new Follower instance for a given file (given by name)
-func NewFileFollower(filename string) (Follower, error) {
+// NewFileFollower creates a new Follower instance for a given file (given by name)
+func NewFileFollower(filename string, opts FileFollowerOptions) (Follower, error) {
 	f := &followerImpl{
 		filename: filename,
+		opts:     opts,
 		line:     make(chan string),
 	}
 
-	if err := f.start(); err != nil {
+	if err := f.start(!opts.Oneshot && !opts.ReadFromStart); err != nil {
 		return nil, err
 	}
 
 	return f, nil
 }
 
-func (f *followerImpl) start() error {
+func (f *followerImpl) start(seekToEnd bool) error {
 	var seekInfo *tail.SeekInfo
 
 	_, err := os.Stat(f.filename)
 	if err != nil {
-		if !os.IsNotExist(err) {
+		if !os.IsNotExist(err) || f.opts.Oneshot {
 			return err
 		}
-	} else {
+	} else if seekToEnd {
 		seekInfo = &tail.SeekInfo{Offset: 0, Whence: os.SEEK_END}
 	}
 
 	t, err := tail.TailFile(f.filename, tail.Config{
-		Follow:   true,
-		ReOpen:   true,
+		Follow:   !f.opts.Oneshot,
+		ReOpen:   !f.reopensItself() && !f.opts.Oneshot,
 		Poll:     true,
 		Location: seekInfo,
+
+		// The tail library sizes its read buffer by the maximum line size
+		MaxLineSize: f.maxLineSize(),
 	})
 
 	if err != nil {
@@ -53,19 +105,106 @@ func (f *followerImpl) start() error {
 	return nil
 }
 
-func (f *followerImpl) OnError(cb func(error)) {
-	go func() {
-		err := f.t.Wait()
-		if err != nil {
-			cb(err)
+// maxLineSize returns the maximum line size passed to the tail library, which
+// is zero (no limit) if no read buffer size is set
+func (f *followerImpl) maxLineSize() int {
+	if f.opts.ReadBufferBytes <= 0 {
+		return 0
+	}
+
+	size := minSplitLineBytes
+	if f.opts.MaxLineBytes > size {
+		size = f.opts.MaxLineBytes
+	}
+	if f.opts.ReadBufferBytes > size {
+		size = f.opts.ReadBufferBytes
+	}
+
+	return size
+}
+
+// reopensItself returns true if moved or deleted files are re-opened by the
+// follower instead of the tail library
+func (f *followerImpl) reopensItself() bool {
+	return f.opts.ReopenBackoff > 0 || f.opts.EOFGrace > 0
+}
+
+// reopen starts tailing the file again (from its beginning) after it has been
+// moved or deleted, waiting for a random delay of up to ReopenBackoff
+func (f *followerImpl) reopen() error {
+	if f.opts.ReopenBackoff > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(f.opts.ReopenBackoff))))
+	}
+
+	return f.start(false)
+}
+
+// awaitFile waits up to EOFGrace for the file to exist again after it has
+// been moved or deleted. A file that was replaced by a new one (with a
+// different inode) exists right away.
+func (f *followerImpl) awaitFile() bool {
+	deadline := time.Now().Add(f.opts.EOFGrace)
+
+	for {
+		if _, err := os.Stat(f.filename); err == nil {
+			return true
 		}
-	}()
+
+		if !time.Now().Before(deadline) {
+			return false
+		}
+
+		time.Sleep(eofGracePollInterval)
+	}
+}
+
+func (f *followerImpl) Source() string {
+	return f.filename
+}
+
+func (f *followerImpl) OnError(cb func(error)) {
+	f.onError = cb
 }
 
 func (f *followerImpl) Lines() chan string {
+	Tracker.register()
+
 	go func() {
-		for n := range f.t.Lines {
-			f.line <- n.Text
+		defer Tracker.deregister()
+		defer close(f.line)
+
+		for {
+			for n := range f.t.Lines {
+				f.line <- n.Text
+			}
+
+			// When not re-opening files by itself, the tailer stops without
+			// error as soon as the file is moved or deleted (or, in oneshot
+			// mode, when the end of the file has been reached)
+			err := f.t.Wait()
+
+			// A file that is deleted while the tailer checks it for changes
+			// is reported as error instead
+			if os.IsNotExist(err) && f.reopensItself() {
+				err = nil
+			}
+
+			if err == nil && f.reopensItself() && !f.opts.Oneshot {
+				if f.opts.EOFGrace > 0 && !f.awaitFile() {
+					fmt.Printf("file %s did not re-appear within %s, no longer following it\n", f.filename, f.opts.EOFGrace)
+					return
+				}
+
+				if err = f.reopen(); err == nil {
+					continue
+				}
+			}
+
+			if err != nil && f.onError != nil {
+				f.onError(err)
+			}
+
+			return
 		}
 	}()
 	return f.line
@@ -0,0 +1,143 @@
+package tail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeLogFile writes a log file to a new temporary directory, which must be
+// removed by the caller
+func writeLogFile(t *testing.T, content string) (string, string) {
+	dir, err := ioutil.TempDir("", "tailer")
+	require.Nil(t, err)
+
+	filename := filepath.Join(dir, "access.log")
+	require.Nil(t, ioutil.WriteFile(filename, []byte(content), 0644))
+
+	return dir, filename
+}
+
+func readAllLines(f Follower) []string {
+	var lines []string
+	for line := range f.Lines() {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func TestFileFollowerReadsLinesLongerThanReadBuffer(t *testing.T) {
+	long := strings.Repeat("x", 3*4096)
+	dir, filename := writeLogFile(t, long+"\nshort\n")
+	defer os.RemoveAll(dir)
+
+	f, err := NewFileFollower(filename, FileFollowerOptions{
+		Oneshot:         true,
+		ReadBufferBytes: 4096,
+	})
+	require.Nil(t, err)
+
+	assert.Equal(t, []string{long, "short"}, readAllLines(f))
+}
+
+func TestFileFollowerReadsLinesUpToMaxLineBytes(t *testing.T) {
+	long := strings.Repeat("x", minSplitLineBytes+10)
+	dir, filename := writeLogFile(t, long+"\n")
+	defer os.RemoveAll(dir)
+
+	f, err := NewFileFollower(filename, FileFollowerOptions{
+		Oneshot:         true,
+		ReadBufferBytes: 4096,
+		MaxLineBytes:    2 * minSplitLineBytes,
+	})
+	require.Nil(t, err)
+
+	assert.Equal(t, []string{long}, readAllLines(f))
+}
+
+// nextLine returns the next line of a follower's channel, and false if the
+// channel was closed or no line arrived within a few seconds
+func nextLine(t *testing.T, lines chan string) (string, bool) {
+	select {
+	case line, ok := <-lines:
+		return line, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("no line was read")
+		return "", false
+	}
+}
+
+func TestFileRecreatedWithinEOFGraceIsReadFromStart(t *testing.T) {
+	dir, filename := writeLogFile(t, "first\n")
+	defer os.RemoveAll(dir)
+
+	f, err := NewFileFollower(filename, FileFollowerOptions{
+		ReadFromStart: true,
+		EOFGrace:      5 * time.Second,
+	})
+	require.Nil(t, err)
+
+	lines := f.Lines()
+	line, _ := nextLine(t, lines)
+	require.Equal(t, "first", line)
+
+	require.Nil(t, os.Remove(filename))
+	time.Sleep(500 * time.Millisecond)
+	require.Nil(t, ioutil.WriteFile(filename, []byte("second\nthird\n"), 0644))
+
+	line, _ = nextLine(t, lines)
+	assert.Equal(t, "second", line)
+	line, _ = nextLine(t, lines)
+	assert.Equal(t, "third", line)
+}
+
+func TestFileNotRecreatedWithinEOFGraceIsNoLongerFollowed(t *testing.T) {
+	dir, filename := writeLogFile(t, "first\n")
+	defer os.RemoveAll(dir)
+
+	f, err := NewFileFollower(filename, FileFollowerOptions{
+		ReadFromStart: true,
+		EOFGrace:      200 * time.Millisecond,
+	})
+	require.Nil(t, err)
+
+	lines := f.Lines()
+	line, _ := nextLine(t, lines)
+	require.Equal(t, "first", line)
+
+	require.Nil(t, os.Remove(filename))
+
+	_, ok := nextLine(t, lines)
+	assert.False(t, ok, "the follower should end")
+}
+
+func TestFileIsReopenedWithBackoffWithoutEOFGrace(t *testing.T) {
+	dir, filename := writeLogFile(t, "first\n")
+	defer os.RemoveAll(dir)
+
+	f, err := NewFileFollower(filename, FileFollowerOptions{
+		ReadFromStart: true,
+		ReopenBackoff: 10 * time.Millisecond,
+	})
+	require.Nil(t, err)
+
+	lines := f.Lines()
+	line, _ := nextLine(t, lines)
+	require.Equal(t, "first", line)
+
+	// Without grace period, the follower waits for the file indefinitely
+	require.Nil(t, os.Remove(filename))
+	time.Sleep(time.Second)
+	require.Nil(t, ioutil.WriteFile(filename, []byte("second\n"), 0644))
+
+	line, ok := nextLine(t, lines)
+	assert.True(t, ok)
+	assert.Equal(t, "second", line)
+}
@@ -0,0 +1,28 @@
+package tail
+
+import "sync/atomic"
+
+// GoroutineTracker keeps count of the goroutines that are currently running
+// on behalf of followers. Each follower registers its line-forwarding
+// goroutine when starting it and deregisters it when the goroutine exits, so
+// that followers that do not shut down properly (for example, after log
+// rotation) can be detected.
+type GoroutineTracker struct {
+	active int64
+}
+
+// Tracker is the GoroutineTracker that all followers register with
+var Tracker = &GoroutineTracker{}
+
+func (t *GoroutineTracker) register() {
+	atomic.AddInt64(&t.active, 1)
+}
+
+func (t *GoroutineTracker) deregister() {
+	atomic.AddInt64(&t.active, -1)
+}
+
+// Count returns the number of currently running follower goroutines
+func (t *GoroutineTracker) Count() int64 {
+	return atomic.LoadInt64(&t.active)
+}
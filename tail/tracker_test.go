@@ -0,0 +1,48 @@
+package tail
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+func TestFileFollowerDeregistersOnExit(t *testing.T) {
+	dir, filename := writeLogFile(t, "a\nb\n")
+	defer os.RemoveAll(dir)
+
+	start := Tracker.Count()
+
+	f, err := NewFileFollower(filename, FileFollowerOptions{Oneshot: true})
+	require.Nil(t, err)
+
+	lines := f.Lines()
+	assert.Equal(t, start+1, Tracker.Count())
+
+	for range lines {
+	}
+
+	assert.Eventually(t, func() bool { return Tracker.Count() == start }, time.Second, 10*time.Millisecond)
+}
+
+func TestSyslogFollowerDeregistersOnExit(t *testing.T) {
+	start := Tracker.Count()
+
+	channel := make(syslog.LogPartsChannel)
+	f, err := NewSyslogFollower("nginx", "udp", syslog.NewServer(), channel)
+	require.Nil(t, err)
+
+	lines := f.Lines()
+	assert.Equal(t, start+1, Tracker.Count())
+
+	channel <- format.LogParts{"tag": "nginx", "content": "a"}
+	assert.Equal(t, "a", <-lines)
+
+	close(channel)
+
+	assert.Eventually(t, func() bool { return Tracker.Count() == start }, time.Second, 10*time.Millisecond)
+}
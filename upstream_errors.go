@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/satyrius/gonx"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/relabeling"
+)
+
+const metricUpstreamErrors = "upstream_errors_total"
+
+// upstreamErrors counts the requests that failed with a status of 400 or
+// above by the upstream server that finally handled them and the status
+// class, with the number of distinct upstream servers capped by a relabeling
+type upstreamErrors struct {
+	counter    *prometheus.CounterVec
+	relabeling *relabeling.Relabeling
+}
+
+func newUpstreamErrors(cfg *config.NamespaceConfig) *upstreamErrors {
+	return &upstreamErrors{
+		counter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.NamespacePrefix,
+			ConstLabels: cfg.NamespaceLabels,
+			Name:        metricUpstreamErrors,
+			Help:        cfg.HelpOrDefault(metricUpstreamErrors, "Amount of failed HTTP requests by upstream server and status class"),
+		}, []string{"upstream", "status_class"}),
+		relabeling: relabeling.NewRelabeling(&config.RelabelConfig{
+			TargetLabel: "upstream",
+			SourceValue: "upstream_addr",
+			Action:      config.RelabelActionUpstreamAddr,
+			MaxValues:   config.DefaultUpstreamErrorsMaxValues,
+		}),
+	}
+}
+
+// count increments the counter if the line's status is 400 or above. When
+// several upstream servers were contacted, the error is attributed to the
+// last one.
+func (u *upstreamErrors) count(fields gonx.Fields) {
+	status := fields["status"]
+	if code, err := strconv.Atoi(status); err != nil || len(status) != 3 || code < 400 {
+		return
+	}
+
+	// Lines without upstream server (like those of requests that failed
+	// before being passed on) are counted as "unknown"
+	value, _ := u.relabeling.Source(fields)
+
+	if upstream, err := u.relabeling.Map(value); err == nil {
+		u.counter.WithLabelValues(upstream, statusClass(status)).Inc()
+	}
+}
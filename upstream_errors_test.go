@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+const upstreamErrorsConfig = `
+namespace "test" {
+  format = "$status \"$upstream_addr\""
+  upstream_errors = true
+}
+`
+
+func TestUpstreamErrorsCountsFailedRequestsByLastUpstream(t *testing.T) {
+	var cfg config.Config
+	require.Nil(t, config.LoadConfigFromStream(&cfg, strings.NewReader(upstreamErrorsConfig), config.TypeHCL))
+
+	nsMetrics := NewNSMetrics(&cfg.Namespaces[0], nil)
+	p := newSourceProcessor(nsMetrics.cfg, &nsMetrics.Metrics, "test.log", "host", "127.0.0.1")
+
+	require.True(t, p.process(`200 "10.0.0.1:80"`))
+	require.True(t, p.process(`404 "10.0.0.1:80"`))
+	require.True(t, p.process(`502 "10.0.0.1:80, 10.0.0.2:80"`))
+	require.True(t, p.process(`503 "10.0.0.2:80"`))
+	require.True(t, p.process(`400 "-"`))
+
+	families, err := nsMetrics.Gather()
+	require.Nil(t, err)
+
+	counts := make(map[string]float64)
+	for _, f := range families {
+		if f.GetName() != "test_upstream_errors_total" {
+			continue
+		}
+
+		for _, m := range f.GetMetric() {
+			values := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				values[l.GetName()] = l.GetValue()
+			}
+
+			counts[values["upstream"]+" "+values["status_class"]] = m.GetCounter().GetValue()
+		}
+	}
+
+	assert.Equal(t, map[string]float64{
+		"10.0.0.1 4xx": 1,
+		"10.0.0.2 5xx": 2,
+		"unknown 4xx":  1,
+	}, counts)
+}
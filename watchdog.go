@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// watchIdleSource logs a warning when no line of a log source has been
+// processed for longer than threshold. lastProcessed holds the time of the last
+// processed line in Unix nanoseconds. The warning is logged once per idle
+// period, and the watchdog stops as soon as done is closed.
+func watchIdleSource(namespace string, source string, threshold time.Duration, lastProcessed *int64, done <-chan struct{}) {
+	ticker := time.NewTicker(threshold / 2)
+	defer ticker.Stop()
+
+	var warnedFor int64
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			last := atomic.LoadInt64(lastProcessed)
+			idle := now.Sub(time.Unix(0, last))
+
+			if idle > threshold && warnedFor != last {
+				fmt.Printf("warning: no line of source %s in namespace %s has been processed for %s\n", source, namespace, idle.Round(time.Second))
+				warnedFor = last
+			}
+		}
+	}
+}
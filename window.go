@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tokopedia/prometheus-nginxlog-exporter/config"
+)
+
+// secondRing counts events in a sliding window of whole seconds, using one
+// slot per second of the window
+type secondRing struct {
+	counts []uint64
+
+	// newest is the Unix second of the newest slot
+	newest int64
+}
+
+func newSecondRing(seconds int) *secondRing {
+	return &secondRing{counts: make([]uint64, seconds)}
+}
+
+// advance moves the window forward to the given Unix second, clearing the
+// slots of all seconds that have passed since. Seconds before the newest slot
+// (after the clock was set back) are treated as the newest slot.
+func (r *secondRing) advance(sec int64) {
+	if sec <= r.newest {
+		return
+	}
+
+	n := int64(len(r.counts))
+	if sec-r.newest >= n {
+		for i := range r.counts {
+			r.counts[i] = 0
+		}
+	} else {
+		for s := r.newest + 1; s <= sec; s++ {
+			r.counts[s%n] = 0
+		}
+	}
+
+	r.newest = sec
+}
+
+func (r *secondRing) add(now time.Time) {
+	r.advance(now.Unix())
+	r.counts[r.newest%int64(len(r.counts))]++
+}
+
+// sum returns the number of events within the window ending at now
+func (r *secondRing) sum(now time.Time) uint64 {
+	r.advance(now.Unix())
+
+	total := uint64(0)
+	for _, c := range r.counts {
+		total += c
+	}
+
+	return total
+}
+
+// requestWindow counts the lines of each log source within a sliding window,
+// and exports the counts as gauge. Unlike rate(), this gives the current rate
+// at a glance, without needing a PromQL query.
+type requestWindow struct {
+	desc    *prometheus.Desc
+	seconds int
+
+	lock    sync.Mutex
+	sources map[string]*secondRing
+}
+
+func newRequestWindow(cfg *config.NamespaceConfig) *requestWindow {
+	return &requestWindow{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(cfg.NamespacePrefix, "", "requests_in_last_window"),
+			cfg.HelpOrDefault("requests_in_last_window", "Number of lines of a log source that were counted within the configured request window"),
+			[]string{"source"},
+			cfg.NamespaceLabels,
+		),
+		seconds: int(cfg.RequestWindowDuration / time.Second),
+		sources: make(map[string]*secondRing),
+	}
+}
+
+func (w *requestWindow) observe(source string, now time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	r, ok := w.sources[source]
+	if !ok {
+		r = newSecondRing(w.seconds)
+		w.sources[source] = r
+	}
+
+	r.add(now)
+}
+
+// Describe implements the prometheus.Collector interface
+func (w *requestWindow) Describe(ch chan<- *prometheus.Desc) {
+	ch <- w.desc
+}
+
+// Collect implements the prometheus.Collector interface
+func (w *requestWindow) Collect(ch chan<- prometheus.Metric) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	now := time.Now()
+
+	for source, r := range w.sources {
+		ch <- prometheus.MustNewConstMetric(w.desc, prometheus.GaugeValue, float64(r.sum(now)), source)
+	}
+}
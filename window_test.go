@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecondRingSumsEventsWithinWindow(t *testing.T) {
+	r := newSecondRing(5)
+
+	r.add(time.Unix(100, 0))
+	r.add(time.Unix(101, 0))
+	r.add(time.Unix(101, 500))
+	r.add(time.Unix(103, 0))
+	assert.Equal(t, uint64(4), r.sum(time.Unix(103, 0)))
+
+	// The window covers the seconds 101 to 105, and then 102 to 106
+	assert.Equal(t, uint64(3), r.sum(time.Unix(105, 0)))
+	assert.Equal(t, uint64(1), r.sum(time.Unix(106, 0)))
+
+	// Events of a clock set back count towards the newest second
+	r.add(time.Unix(90, 0))
+	assert.Equal(t, uint64(2), r.sum(time.Unix(106, 0)))
+
+	// A clock advanced past the entire window clears all slots
+	assert.Equal(t, uint64(0), r.sum(time.Unix(200, 0)))
+
+	r.add(time.Unix(201, 0))
+	assert.Equal(t, uint64(1), r.sum(time.Unix(203, 0)))
+}